@@ -0,0 +1,43 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	cfg "sigs.k8s.io/controller-runtime/pkg/config/v1alpha1"
+
+	packagev1alpha1 "github.com/ArangoGutierrez/spack-operator/api/v1alpha1"
+)
+
+// +kubebuilder:object:root=true
+
+// OperatorConfig is the configuration of the operator, read from the file
+// given to its --config flag
+type OperatorConfig struct {
+	metav1.TypeMeta `json:",inline"`
+
+	// ControllerManagerConfigurationSpec returns the configurations for controllers
+	cfg.ControllerManagerConfigurationSpec `json:",inline"`
+
+	// BuildDefaults are the values given to the optional fields left
+	// empty in the Build specs
+	BuildDefaults packagev1alpha1.BuildDefaults `json:"buildDefaults,omitempty"`
+}
+
+func init() {
+	SchemeBuilder.Register(&OperatorConfig{})
+}
@@ -0,0 +1,164 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	s "strings"
+)
+
+// BuildDefaults holds the values given to the optional fields left empty
+// in the Build specs
+type BuildDefaults struct {
+	// BaseImageStream is the ImageStream of the operator base images,
+	// tagged "spack<version>-<os>" for every Spack release and distribution
+	BaseImageStream string `json:"baseImageStream,omitempty"`
+	// SpackVersion is the Spack release of the Builds not requesting one
+	SpackVersion string `json:"spackVersion,omitempty"`
+	// OS is the distribution of the Builds not requesting one
+	OS OperatingSystem `json:"os,omitempty"`
+	// Backend runs the builds of the Builds not selecting one
+	Backend BuildBackend `json:"backend,omitempty"`
+	// RunPolicy of the builds of every environment
+	RunPolicy RunPolicy `json:"runPolicy,omitempty"`
+	// SuccessfulBuildsHistoryLimit is the number of successful builds kept
+	// for every environment
+	SuccessfulBuildsHistoryLimit *int32 `json:"successfulBuildsHistoryLimit,omitempty"`
+	// FailedBuildsHistoryLimit is the number of failed builds kept for
+	// every environment
+	FailedBuildsHistoryLimit *int32 `json:"failedBuildsHistoryLimit,omitempty"`
+	// Tag is the tag images are pushed to when the ImageStream of the
+	// Build does not have one
+	Tag string `json:"tag,omitempty"`
+	// BinaryMirror is the Spack build cache of the Builds not configuring
+	// one, its credentials Secret must exist in the namespace of every Build
+	// +optional
+	BinaryMirror *BinaryMirror `json:"binaryMirror,omitempty"`
+}
+
+// DefaultBuildDefaults returns the built-in defaults of the Build specs
+func DefaultBuildDefaults() BuildDefaults {
+	limit := int32(3)
+	return BuildDefaults{
+		BaseImageStream:              "spack-operator-base",
+		SpackVersion:                 "v0.16.0",
+		OS:                           OSFedora,
+		Backend:                      BackendOpenShift,
+		RunPolicy:                    RunPolicyParallel,
+		SuccessfulBuildsHistoryLimit: &limit,
+		FailedBuildsHistoryLimit:     &limit,
+		Tag:                          "latest",
+	}
+}
+
+// buildDefaults are the defaults applied by Build.Default
+var buildDefaults = DefaultBuildDefaults()
+
+// SetBuildDefaults replaces the defaults of the Build specs, the ones left
+// empty in d keep their built-in value
+func SetBuildDefaults(d BuildDefaults) {
+	defaults := DefaultBuildDefaults()
+	if d.BaseImageStream != "" {
+		defaults.BaseImageStream = d.BaseImageStream
+	}
+	if d.SpackVersion != "" {
+		defaults.SpackVersion = d.SpackVersion
+	}
+	if d.OS != "" {
+		defaults.OS = d.OS
+	}
+	if d.Backend != "" {
+		defaults.Backend = d.Backend
+	}
+	if d.RunPolicy != "" {
+		defaults.RunPolicy = d.RunPolicy
+	}
+	if d.SuccessfulBuildsHistoryLimit != nil {
+		defaults.SuccessfulBuildsHistoryLimit = d.SuccessfulBuildsHistoryLimit
+	}
+	if d.FailedBuildsHistoryLimit != nil {
+		defaults.FailedBuildsHistoryLimit = d.FailedBuildsHistoryLimit
+	}
+	if d.Tag != "" {
+		defaults.Tag = d.Tag
+	}
+	defaults.BinaryMirror = d.BinaryMirror
+	buildDefaults = defaults
+}
+
+// CurrentBuildDefaults returns the defaults applied to the Build specs
+func CurrentBuildDefaults() BuildDefaults {
+	return buildDefaults
+}
+
+// BaseImageTag returns the ImageStreamTag of the operator base image of a
+// Spack release on a distribution
+func (d BuildDefaults) BaseImageTag(spackVersion string, os OperatingSystem) string {
+	return d.BaseImageStream + ":spack" + spackVersion + "-" + string(os)
+}
+
+// setDefaults fills in the optional fields left empty in spec
+func (spec *BuildSpec) setDefaults(d BuildDefaults) {
+	if spec.ImageStream != "" && !s.Contains(spec.ImageStream, ":") {
+		spec.ImageStream += ":" + d.Tag
+	}
+	if o := spec.Output; o != nil {
+		if o.To.Kind == "" {
+			o.To.Kind = OutputImageStreamTag
+		}
+		if o.To.Name != "" && !hasTag(o.To.Name) {
+			o.To.Name += ":" + d.Tag
+		}
+	}
+	if spec.SpackVersion == "" {
+		spec.SpackVersion = d.SpackVersion
+	}
+	if spec.OS == "" {
+		spec.OS = d.OS
+	}
+	if spec.Backend == "" {
+		spec.Backend = d.Backend
+	}
+	if spec.Kubernetes != nil && spec.Kubernetes.Tool == "" {
+		spec.Kubernetes.Tool = ToolBuildah
+	}
+	if spec.RunPolicy == "" {
+		spec.RunPolicy = d.RunPolicy
+	}
+	if spec.SuccessfulBuildsHistoryLimit == nil {
+		limit := *d.SuccessfulBuildsHistoryLimit
+		spec.SuccessfulBuildsHistoryLimit = &limit
+	}
+	if spec.FailedBuildsHistoryLimit == nil {
+		limit := *d.FailedBuildsHistoryLimit
+		spec.FailedBuildsHistoryLimit = &limit
+	}
+	if spec.BinaryMirror == nil && d.BinaryMirror != nil {
+		spec.BinaryMirror = d.BinaryMirror.DeepCopy()
+	}
+	if spec.BinaryMirror != nil && spec.BinaryMirror.Name == "" {
+		spec.BinaryMirror.Name = DefaultBinaryMirrorName
+	}
+	if spec.SourceMirror != nil && spec.SourceMirror.Name == "" {
+		spec.SourceMirror.Name = DefaultSourceMirrorName
+	}
+}
+
+// hasTag returns true when the image reference ref has a tag, the colon of
+// the port of a registry host is not one
+func hasTag(ref string) bool {
+	return s.LastIndex(ref, ":") > s.LastIndex(ref, "/")
+}
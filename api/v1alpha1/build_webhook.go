@@ -0,0 +1,500 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	s "strings"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/yaml"
+
+	"github.com/ArangoGutierrez/spack-operator/pkg/archspec"
+)
+
+// log is for logging in this package.
+var buildlog = logf.Log.WithName("build-resource")
+
+// imageTag matches the tags allowed by the image registries
+var imageTag = regexp.MustCompile(`^[\w][\w.-]{0,127}$`)
+
+// registryRepository matches a registry host followed by an optional
+// repository path, without a tag or a digest
+var registryRepository = regexp.MustCompile(`^[a-zA-Z0-9.-]+(:[0-9]+)?(/[a-z0-9]+([._-][a-z0-9]+)*)*$`)
+
+// spackRelease matches the tags of the Spack releases, such as v0.16.0
+var spackRelease = regexp.MustCompile(`^v?(\d+)\.(\d+)(\.\d+)?$`)
+
+// ociMirrorSpackVersion is the first Spack release supporting OCI mirrors
+const ociMirrorSpackVersion = "v0.21"
+
+// SetupWebhookWithManager registers the webhooks of Build with the Manager
+func (r *Build) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		Complete()
+}
+
+// +kubebuilder:webhook:path=/mutate-multiarch-builder-io-v1alpha1-build,mutating=true,failurePolicy=fail,sideEffects=None,groups=multiarch.builder.io,resources=builds,verbs=create;update,versions=v1alpha1,name=mbuild.multiarch.builder.io,admissionReviewVersions={v1,v1beta1}
+
+var _ webhook.Defaulter = &Build{}
+
+// Default implements webhook.Defaulter so a webhook will be registered for the type.
+// The controller applies it as well, for the Builds stored while the webhook
+// was not deployed.
+func (r *Build) Default() {
+	r.Spec.setDefaults(buildDefaults)
+}
+
+// +kubebuilder:webhook:path=/validate-multiarch-builder-io-v1alpha1-build,mutating=false,failurePolicy=fail,sideEffects=None,groups=multiarch.builder.io,resources=builds,verbs=create;update,versions=v1alpha1,name=vbuild.multiarch.builder.io,admissionReviewVersions={v1,v1beta1}
+
+var _ webhook.Validator = &Build{}
+
+// ValidateCreate implements webhook.Validator so a webhook will be registered for the type
+func (r *Build) ValidateCreate() error {
+	buildlog.Info("validate create", "name", r.Name)
+	return r.validateBuild()
+}
+
+// ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
+func (r *Build) ValidateUpdate(old runtime.Object) error {
+	buildlog.Info("validate update", "name", r.Name)
+
+	// Builds created before the webhook must stay updatable by the
+	// controller, to remove their finalizer among others
+	if oldBuild, ok := old.(*Build); ok && equality.Semantic.DeepEqual(r.Spec, oldBuild.Spec) {
+		return nil
+	}
+	return r.validateBuild()
+}
+
+// ValidateDelete implements webhook.Validator so a webhook will be registered for the type
+func (r *Build) ValidateDelete() error {
+	return nil
+}
+
+// validateBuild rejects the specs the controller cannot build
+func (r *Build) validateBuild() error {
+	allErrs := r.Spec.validate(field.NewPath("spec"))
+	// the operator manages the ImageStreams of the namespace of the Build
+	// only, its author may not be allowed to push to the other ones
+	if o := r.Spec.Output; o != nil && o.To.Kind == OutputImageStreamTag && o.To.Namespace != "" && o.To.Namespace != r.Namespace {
+		allErrs = append(allErrs, field.Forbidden(field.NewPath("spec", "output", "to", "namespace"),
+			"the images can only be pushed to the namespace of the Build"))
+	}
+	if len(allErrs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(GroupVersion.WithKind("Build").GroupKind(), r.Name, allErrs)
+}
+
+func (spec *BuildSpec) validate(fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+
+	// the installs onto a claim do not push any image
+	switch {
+	case spec.Install != nil:
+		allErrs = append(allErrs, spec.Install.validate(fldPath.Child("install"))...)
+		if spec.ImageStream != "" {
+			allErrs = append(allErrs, field.Forbidden(fldPath.Child("imagestream"), "no image is pushed by an install"))
+		}
+		if spec.Output != nil {
+			allErrs = append(allErrs, field.Forbidden(fldPath.Child("output"), "no image is pushed by an install"))
+		}
+		if spec.Runtime != nil {
+			allErrs = append(allErrs, field.Forbidden(fldPath.Child("runtime"), "no image is built by an install"))
+		}
+		if spec.Kubernetes != nil {
+			allErrs = append(allErrs, field.Forbidden(fldPath.Child("kubernetes"), "no image is built by an install"))
+		}
+	case spec.Output != nil:
+		allErrs = append(allErrs, spec.Output.validate(fldPath.Child("output"))...)
+		if spec.ImageStream != "" {
+			allErrs = append(allErrs, field.Forbidden(fldPath.Child("imagestream"), "imagestream and output are exclusive"))
+		}
+	default:
+		allErrs = append(allErrs, validateImageStreamTag(spec.ImageStream, fldPath.Child("imagestream"))...)
+	}
+	if spec.BaseImage != "" {
+		allErrs = append(allErrs, validateImageStreamTag(spec.BaseImage, fldPath.Child("baseImage"))...)
+	}
+	if spec.ImageStreamPolicy != nil {
+		policyPath := fldPath.Child("imageStreamPolicy")
+		switch {
+		case spec.Install != nil:
+			allErrs = append(allErrs, field.Forbidden(policyPath, "no image is pushed by an install"))
+		case spec.Output != nil && spec.Output.To.Kind == OutputDockerImage:
+			allErrs = append(allErrs, field.Forbidden(policyPath, "the images are not pushed to an ImageStream"))
+		case spec.Backend == BackendKubernetes:
+			allErrs = append(allErrs, field.Forbidden(policyPath,
+				"the ImageStream is only managed with the "+string(BackendOpenShift)+" backend"))
+		}
+	}
+
+	envPath := fldPath.Child("environment")
+	if len(spec.Environment) == 0 {
+		allErrs = append(allErrs, field.Required(envPath, "at least one Spack environment is required"))
+	}
+	names := map[string]bool{}
+	for i, env := range spec.Environment {
+		idxPath := envPath.Index(i)
+		if env.Name == nil {
+			allErrs = append(allErrs, field.Required(idxPath.Child("name"), ""))
+		} else {
+			// the name ends up in the names of the environment resources
+			for _, msg := range validation.IsDNS1123Label(*env.Name) {
+				allErrs = append(allErrs, field.Invalid(idxPath.Child("name"), *env.Name, msg))
+			}
+			if names[*env.Name] {
+				allErrs = append(allErrs, field.Duplicate(idxPath.Child("name"), *env.Name))
+			}
+			names[*env.Name] = true
+		}
+		allErrs = append(allErrs, env.validate(idxPath)...)
+	}
+
+	known := make([]string, 0, len(archspec.Microarchitectures))
+	for name := range archspec.Microarchitectures {
+		known = append(known, name)
+	}
+	sort.Strings(known)
+	targets := map[string]bool{}
+	// the images of the targets are gathered in manifest lists, whose
+	// entries are only told apart by their architecture and variant
+	platforms := map[string]string{}
+	for i, target := range spec.Targets {
+		idxPath := fldPath.Child("targets").Index(i)
+		m, ok := archspec.Lookup(target)
+		if !ok {
+			allErrs = append(allErrs, field.NotSupported(idxPath, target, known))
+		}
+		if targets[target] {
+			allErrs = append(allErrs, field.Duplicate(idxPath, target))
+		}
+		targets[target] = true
+		if !ok || spec.Install != nil {
+			continue
+		}
+		platform := m.Architecture + "/" + archspec.Variant(target)
+		if other, found := platforms[platform]; found && other != target {
+			allErrs = append(allErrs, field.Invalid(idxPath, target,
+				fmt.Sprintf("has the platform of %s in the manifest lists, only the x86_64 levels are amd64 variants", other)))
+		}
+		platforms[platform] = target
+	}
+
+	if spec.Runtime != nil {
+		for i, pkg := range spec.Runtime.Packages {
+			// the packages are passed to the package manager in the Dockerfile
+			if pkg == "" || shellUnsafe(pkg) {
+				allErrs = append(allErrs, field.Invalid(fldPath.Child("runtime", "packages").Index(i), pkg,
+					"must be a single package name"))
+			}
+		}
+	}
+
+	if spec.BinaryMirror != nil {
+		allErrs = append(allErrs, spec.BinaryMirror.validate(fldPath.Child("binaryMirror"))...)
+		// the credentials and the key are not recorded by the install Jobs
+		if spec.BinaryMirror.CredentialsSecret != nil && spec.Runtime == nil && spec.Install == nil {
+			allErrs = append(allErrs, field.Required(fldPath.Child("runtime"),
+				"a runtime stage is required with mirror credentials, which would otherwise be recorded in the images"))
+		}
+		if spec.BinaryMirror.SigningKeySecret != nil && spec.Runtime == nil && spec.Install == nil {
+			allErrs = append(allErrs, field.Required(fldPath.Child("runtime"),
+				"a runtime stage is required with a signing key, which would otherwise be recorded in the images"))
+		}
+	}
+	if spec.SourceMirror != nil {
+		allErrs = append(allErrs, spec.SourceMirror.validate(fldPath.Child("sourceMirror"))...)
+		if spec.BinaryMirror != nil && spec.BinaryMirror.Name == spec.SourceMirror.Name {
+			allErrs = append(allErrs, field.Duplicate(fldPath.Child("sourceMirror", "name"), spec.SourceMirror.Name))
+		}
+	}
+	if (spec.BinaryMirror != nil && s.HasPrefix(spec.BinaryMirror.URL, "oci://")) ||
+		(spec.SourceMirror != nil && s.HasPrefix(spec.SourceMirror.URL, "oci://")) {
+		if SpackVersionBefore(spec.SpackVersion, ociMirrorSpackVersion) {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("spackVersion"), spec.SpackVersion,
+				"OCI mirrors require Spack "+ociMirrorSpackVersion+" or later"))
+		}
+	}
+
+	if k := spec.Kubernetes; k != nil && k.Registry != "" && !registryRepository.MatchString(k.Registry) {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("kubernetes", "registry"), k.Registry,
+			"must be a registry host with an optional repository path, such as quay.io/organization"))
+	}
+
+	archs := map[Architecture]bool{}
+	for i, arch := range spec.Architectures {
+		if archs[arch] {
+			allErrs = append(allErrs, field.Duplicate(fldPath.Child("architectures").Index(i), arch))
+		}
+		archs[arch] = true
+	}
+
+	return allErrs
+}
+
+// validate checks that the environment is either a raw spack.yaml or a
+// structured environment with specs
+func (env *SpackEnvionment) validate(fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	structured := len(env.Specs) > 0 || len(env.Packages) > 0 || len(env.Compilers) > 0 ||
+		env.View != "" || env.Concretization != "" || env.Config != nil
+
+	switch {
+	case env.Data != nil && structured:
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("data"), "spack.yaml",
+			"cannot be combined with the structured fields"))
+	case env.Data != nil:
+		if err := validateSpackEnvironment(*env.Data); err != nil {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("data"), "spack.yaml", err.Error()))
+		}
+	case len(env.Specs) == 0:
+		allErrs = append(allErrs, field.Required(fldPath.Child("specs"), "either data or specs is required"))
+	}
+
+	if env.View != "" && !path.IsAbs(env.View) {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("view"), env.View, "must be an absolute path"))
+	}
+	if env.Config != nil {
+		config := map[string]interface{}{}
+		if err := json.Unmarshal(env.Config.Raw, &config); err != nil {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("config"), string(env.Config.Raw), "must be a mapping"))
+		}
+	}
+	for name := range env.Packages {
+		if name == "" {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("packages"), name, "package names cannot be empty"))
+		}
+	}
+	return allErrs
+}
+
+// mirrorSchemes are the URL schemes of the Spack mirrors
+var mirrorSchemes = []string{"file", "http", "https", "oci", "s3"}
+
+// validate checks the URL and the name of a binary mirror
+func (m *BinaryMirror) validate(fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	if m.Name != "" {
+		for _, msg := range validation.IsDNS1123Label(m.Name) {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("name"), m.Name, msg))
+		}
+	}
+	allErrs = append(allErrs, validateMirrorURL(m.URL, fldPath.Child("url"))...)
+	if m.Endpoint != "" {
+		u, err := url.Parse(m.Endpoint)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || shellUnsafe(m.Endpoint) {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("endpoint"), m.Endpoint, "must be an http or https URL"))
+		}
+	}
+	return allErrs
+}
+
+// validate checks that a source mirror is either given by its URL or by
+// the PersistentVolumeClaim holding it
+func (m *SourceMirror) validate(fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	if m.Name != "" {
+		for _, msg := range validation.IsDNS1123Label(m.Name) {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("name"), m.Name, msg))
+		}
+	}
+	switch {
+	case m.URL != "" && m.PersistentVolumeClaim != nil:
+		allErrs = append(allErrs, field.Forbidden(fldPath.Child("persistentVolumeClaim"), "url and persistentVolumeClaim are exclusive"))
+	case m.URL != "":
+		allErrs = append(allErrs, validateMirrorURL(m.URL, fldPath.Child("url"))...)
+	case m.PersistentVolumeClaim == nil:
+		allErrs = append(allErrs, field.Required(fldPath, "either url or persistentVolumeClaim is required"))
+	}
+	if m.Path != "" {
+		if m.PersistentVolumeClaim == nil {
+			allErrs = append(allErrs, field.Forbidden(fldPath.Child("path"), "only a persistentVolumeClaim has a path"))
+		}
+		if s.HasPrefix(m.Path, "/") || s.Contains(m.Path, "..") || shellUnsafe(m.Path) {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("path"), m.Path, "must be a relative path within the claim"))
+		}
+	}
+	return allErrs
+}
+
+// validate checks the claim and the path of the install tree
+func (i *InstallSpec) validate(fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	claimPath := fldPath.Child("persistentVolumeClaim", "name")
+	if i.PersistentVolumeClaim.Name == "" {
+		allErrs = append(allErrs, field.Required(claimPath, ""))
+	} else {
+		// the name of the claim labels the install Jobs sharing it
+		for _, msg := range validation.IsDNS1123Label(i.PersistentVolumeClaim.Name) {
+			allErrs = append(allErrs, field.Invalid(claimPath, i.PersistentVolumeClaim.Name, msg))
+		}
+	}
+	if i.Path != "" && (s.HasPrefix(i.Path, "/") || s.Contains(i.Path, "..")) {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("path"), i.Path, "must be a relative path within the claim"))
+	}
+	return allErrs
+}
+
+// validate checks the reference and the tags of the output
+func (o *BuildOutput) validate(fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	toPath := fldPath.Child("to")
+	switch o.To.Kind {
+	case OutputImageStreamTag:
+		allErrs = append(allErrs, validateImageStreamTag(o.To.Name, toPath.Child("name"))...)
+		if o.To.Namespace != "" {
+			for _, msg := range validation.IsDNS1123Label(o.To.Namespace) {
+				allErrs = append(allErrs, field.Invalid(toPath.Child("namespace"), o.To.Namespace, msg))
+			}
+		}
+	case OutputDockerImage:
+		i := s.LastIndex(o.To.Name, ":")
+		// a registry host is required, the images are pushed by the operator
+		// as well as by the builds
+		if !hasTag(o.To.Name) || !s.Contains(o.To.Name[:i], "/") || !registryRepository.MatchString(o.To.Name[:i]) ||
+			!imageTag.MatchString(o.To.Name[i+1:]) {
+			allErrs = append(allErrs, field.Invalid(toPath.Child("name"), o.To.Name,
+				"must be of the form registry/repository:tag"))
+		}
+		if o.To.Namespace != "" {
+			allErrs = append(allErrs, field.Forbidden(toPath.Child("namespace"), "a DockerImage has no namespace"))
+		}
+	default:
+		allErrs = append(allErrs, field.NotSupported(toPath.Child("kind"), o.To.Kind,
+			[]string{OutputImageStreamTag, OutputDockerImage}))
+	}
+
+	tags := map[string]bool{}
+	for i, tag := range o.AdditionalTags {
+		idxPath := fldPath.Child("additionalTags").Index(i)
+		if !imageTag.MatchString(tag) {
+			allErrs = append(allErrs, field.Invalid(idxPath, tag, "invalid tag"))
+		}
+		if tags[tag] {
+			allErrs = append(allErrs, field.Duplicate(idxPath, tag))
+		}
+		tags[tag] = true
+	}
+	return allErrs
+}
+
+// validateMirrorURL checks that value is the URL of a Spack mirror
+func validateMirrorURL(value string, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	if value == "" {
+		return append(allErrs, field.Required(fldPath, ""))
+	}
+	u, err := url.Parse(value)
+	if err != nil {
+		return append(allErrs, field.Invalid(fldPath, value, err.Error()))
+	}
+	supported := false
+	for _, scheme := range mirrorSchemes {
+		supported = supported || u.Scheme == scheme
+	}
+	if !supported {
+		allErrs = append(allErrs, field.NotSupported(fldPath.Child("scheme"), u.Scheme, mirrorSchemes))
+	}
+	// the URL is passed to Spack in the build script
+	if shellUnsafe(value) {
+		allErrs = append(allErrs, field.Invalid(fldPath, value, "must not contain whitespace or shell metacharacters"))
+	}
+	return allErrs
+}
+
+// SpackVersionBefore returns true when version is a Spack release older
+// than the release min, the other versions (e.g. develop) are assumed to be
+// recent enough
+func SpackVersionBefore(version, min string) bool {
+	v, m := spackRelease.FindStringSubmatch(version), spackRelease.FindStringSubmatch(min)
+	if v == nil || m == nil {
+		return false
+	}
+	for i := 1; i <= 2; i++ {
+		a, _ := strconv.Atoi(v[i])
+		b, _ := strconv.Atoi(m[i])
+		if a != b {
+			return a < b
+		}
+	}
+	return false
+}
+
+// shellUnsafe returns true when value contains whitespace or shell
+// metacharacters, the values rendered into the build scripts must not
+func shellUnsafe(value string) bool {
+	return s.ContainsAny(value, " \t\n;&|$`\\\"'<>(){}*?!#~")
+}
+
+// validateImageStreamTag checks that value is a name:tag reference to an
+// ImageStreamTag
+func validateImageStreamTag(value string, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	parts := s.Split(value, ":")
+	if len(parts) != 2 {
+		return append(allErrs, field.Invalid(fldPath, value, "must be of the form name:tag"))
+	}
+	for _, msg := range validation.IsDNS1123Subdomain(parts[0]) {
+		allErrs = append(allErrs, field.Invalid(fldPath, value, "invalid ImageStream name: "+msg))
+	}
+	if !imageTag.MatchString(parts[1]) {
+		allErrs = append(allErrs, field.Invalid(fldPath, value, "invalid tag: "+parts[1]))
+	}
+	return allErrs
+}
+
+// validateSpackEnvironment checks that data is a Spack environment, a YAML
+// document holding a "spack" mapping ("env" for older Spack releases)
+func validateSpackEnvironment(data string) error {
+	manifest := map[string]interface{}{}
+	if err := yaml.Unmarshal([]byte(data), &manifest); err != nil {
+		return fmt.Errorf("invalid YAML: %v", err)
+	}
+
+	root, ok := manifest["spack"]
+	if !ok {
+		if root, ok = manifest["env"]; !ok {
+			return fmt.Errorf("missing the spack section")
+		}
+	}
+	env, ok := root.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("the spack section must be a mapping")
+	}
+	if specs, ok := env["specs"]; ok {
+		if _, ok := specs.([]interface{}); !ok {
+			return fmt.Errorf("specs must be a list")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,346 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+const spackYAML = `spack:
+  specs:
+  - zlib
+  view: true
+`
+
+func strPtr(v string) *string { return &v }
+
+func validBuild() *Build {
+	return &Build{Spec: BuildSpec{
+		ImageStream: "stack:v1",
+		Environment: []SpackEnvionment{{Name: strPtr("mpi"), Data: strPtr(spackYAML)}},
+	}}
+}
+
+func TestValidateBuild(t *testing.T) {
+	tests := []struct {
+		name   string
+		mutate func(*Build)
+		valid  bool
+	}{
+		{"valid", func(b *Build) {}, true},
+		{"legacy env section", func(b *Build) { b.Spec.Environment[0].Data = strPtr("env:\n  specs: [zlib]\n") }, true},
+		{"no environment", func(b *Build) { b.Spec.Environment = nil }, false},
+		{"nil name", func(b *Build) { b.Spec.Environment[0].Name = nil }, false},
+		{"nil data", func(b *Build) { b.Spec.Environment[0].Data = nil }, false},
+		{"structured", func(b *Build) {
+			b.Spec.Environment[0].Data = nil
+			b.Spec.Environment[0].Specs = []string{"zlib"}
+			b.Spec.Environment[0].Config = &runtime.RawExtension{Raw: []byte(`{"build_jobs":4}`)}
+		}, true},
+		{"data and structured", func(b *Build) { b.Spec.Environment[0].Specs = []string{"zlib"} }, false},
+		{"structured without specs", func(b *Build) {
+			b.Spec.Environment[0].Data = nil
+			b.Spec.Environment[0].View = "/opt/view"
+		}, false},
+		{"relative view", func(b *Build) {
+			b.Spec.Environment[0].Data = nil
+			b.Spec.Environment[0].Specs = []string{"zlib"}
+			b.Spec.Environment[0].View = "view"
+		}, false},
+		{"config not a mapping", func(b *Build) {
+			b.Spec.Environment[0].Data = nil
+			b.Spec.Environment[0].Specs = []string{"zlib"}
+			b.Spec.Environment[0].Config = &runtime.RawExtension{Raw: []byte(`["build_jobs"]`)}
+		}, false},
+		{"invalid name", func(b *Build) { b.Spec.Environment[0].Name = strPtr("MPI_env") }, false},
+		{"duplicate names", func(b *Build) {
+			b.Spec.Environment = append(b.Spec.Environment, SpackEnvionment{Name: strPtr("mpi"), Data: strPtr(spackYAML)})
+		}, false},
+		{"image stream without tag", func(b *Build) { b.Spec.ImageStream = "stack" }, false},
+		{"image stream without name", func(b *Build) { b.Spec.ImageStream = ":v1" }, false},
+		{"invalid yaml", func(b *Build) { b.Spec.Environment[0].Data = strPtr("spack: [zlib") }, false},
+		{"not a spack environment", func(b *Build) { b.Spec.Environment[0].Data = strPtr("packages:\n  all: {}\n") }, false},
+		{"specs not a list", func(b *Build) { b.Spec.Environment[0].Data = strPtr("spack:\n  specs: zlib\n") }, false},
+		{"known target", func(b *Build) { b.Spec.Targets = []string{"x86_64_v3", "neoverse_n1"} }, true},
+		{"unknown target", func(b *Build) { b.Spec.Targets = []string{"pentium4"} }, false},
+		{"targets of the same platform", func(b *Build) { b.Spec.Targets = []string{"haswell", "skylake_avx512"} }, false},
+		{"x86_64 levels", func(b *Build) { b.Spec.Targets = []string{"x86_64_v2", "x86_64_v3", "x86_64_v4"} }, true},
+		{"x86_64 level and microarchitecture", func(b *Build) { b.Spec.Targets = []string{"x86_64_v3", "haswell"} }, true},
+		{"duplicate architectures", func(b *Build) {
+			b.Spec.Architectures = []Architecture{ArchitectureAMD64, ArchitectureAMD64}
+		}, false},
+		{"runtime packages", func(b *Build) {
+			b.Spec.Runtime = &RuntimeSpec{Packages: []string{"libgomp", "openssl-libs"}}
+		}, true},
+		{"runtime package with a command", func(b *Build) {
+			b.Spec.Runtime = &RuntimeSpec{Packages: []string{"libgomp && curl evil"}}
+		}, false},
+		{"s3 mirror", func(b *Build) {
+			b.Spec.Runtime = &RuntimeSpec{}
+			b.Spec.BinaryMirror = &BinaryMirror{
+				Name:              "cache",
+				URL:               "s3://spack/buildcache",
+				Endpoint:          "http://minio.spack-operator-system.svc:9000",
+				CredentialsSecret: &corev1.LocalObjectReference{Name: "minio"},
+			}
+		}, true},
+		{"mirror with an unknown scheme", func(b *Build) {
+			b.Spec.BinaryMirror = &BinaryMirror{URL: "ftp://mirror/buildcache"}
+		}, false},
+		{"signing key without runtime", func(b *Build) {
+			b.Spec.BinaryMirror = &BinaryMirror{
+				URL:              "s3://spack/buildcache",
+				SigningKeySecret: &corev1.LocalObjectReference{Name: "gpg"},
+			}
+		}, false},
+		{"air-gapped source mirror claim", func(b *Build) {
+			b.Spec.SourceMirror = &SourceMirror{
+				PersistentVolumeClaim: &corev1.LocalObjectReference{Name: "spack-mirror"},
+				Path:                  "mirror",
+				AirGapped:             true,
+			}
+		}, true},
+		{"source mirror url and claim", func(b *Build) {
+			b.Spec.SourceMirror = &SourceMirror{
+				URL:                   "http://mirror.local/spack",
+				PersistentVolumeClaim: &corev1.LocalObjectReference{Name: "spack-mirror"},
+			}
+		}, false},
+		{"source mirror path escaping the claim", func(b *Build) {
+			b.Spec.SourceMirror = &SourceMirror{
+				PersistentVolumeClaim: &corev1.LocalObjectReference{Name: "spack-mirror"},
+				Path:                  "../etc",
+			}
+		}, false},
+		{"mirrors with the same name", func(b *Build) {
+			b.Spec.BinaryMirror = &BinaryMirror{Name: "local", URL: "http://mirror.local/cache"}
+			b.Spec.SourceMirror = &SourceMirror{Name: "local", URL: "http://mirror.local/spack"}
+		}, false},
+		{"mirror credentials without runtime", func(b *Build) {
+			b.Spec.BinaryMirror = &BinaryMirror{
+				URL:               "oci://registry.local/buildcache",
+				CredentialsSecret: &corev1.LocalObjectReference{Name: "registry"},
+			}
+		}, false},
+		{"oci mirror with an old spack", func(b *Build) {
+			b.Spec.SpackVersion = "v0.20.3"
+			b.Spec.BinaryMirror = &BinaryMirror{URL: "oci://registry.local/buildcache"}
+		}, false},
+		{"oci mirror", func(b *Build) {
+			b.Spec.SpackVersion = "v0.21.0"
+			b.Spec.BinaryMirror = &BinaryMirror{URL: "oci://registry.local/buildcache"}
+		}, true},
+		{"oci source mirror on develop", func(b *Build) {
+			b.Spec.SpackVersion = "develop"
+			b.Spec.SourceMirror = &SourceMirror{URL: "oci://registry.local/sources"}
+		}, true},
+		{"kubernetes push registry", func(b *Build) {
+			b.Spec.Backend = BackendKubernetes
+			b.Spec.Kubernetes = &KubernetesBuildSpec{
+				Registry:   "registry.local:5000/spack",
+				PushSecret: &corev1.LocalObjectReference{Name: "push"},
+			}
+		}, true},
+		{"kubernetes push registry with a tag", func(b *Build) {
+			b.Spec.Backend = BackendKubernetes
+			b.Spec.Kubernetes = &KubernetesBuildSpec{Registry: "quay.io/spack:latest"}
+		}, false},
+		{"install onto a claim", func(b *Build) {
+			b.Spec.ImageStream = ""
+			b.Spec.Install = &InstallSpec{
+				PersistentVolumeClaim: corev1.LocalObjectReference{Name: "spack-tree"},
+				Path:                  "x86_64",
+			}
+			b.Spec.BinaryMirror = &BinaryMirror{
+				URL:              "s3://spack/buildcache",
+				SigningKeySecret: &corev1.LocalObjectReference{Name: "gpg"},
+			}
+		}, true},
+		{"install with an image stream", func(b *Build) {
+			b.Spec.Install = &InstallSpec{PersistentVolumeClaim: corev1.LocalObjectReference{Name: "spack-tree"}}
+		}, false},
+		{"install without a claim", func(b *Build) {
+			b.Spec.ImageStream = ""
+			b.Spec.Install = &InstallSpec{}
+		}, false},
+		{"install path escaping the claim", func(b *Build) {
+			b.Spec.ImageStream = ""
+			b.Spec.Install = &InstallSpec{
+				PersistentVolumeClaim: corev1.LocalObjectReference{Name: "spack-tree"},
+				Path:                  "tree/../../etc",
+			}
+		}, false},
+		{"output to an image stream of another namespace", func(b *Build) {
+			b.Spec.ImageStream = ""
+			b.Spec.Output = &BuildOutput{
+				To:             corev1.ObjectReference{Kind: OutputImageStreamTag, Namespace: "shared", Name: "stack:v1"},
+				AdditionalTags: []string{"latest", "0a1b2c3"},
+			}
+		}, false},
+		{"output to an image stream of the namespace of the Build", func(b *Build) {
+			b.Namespace = "hpc"
+			b.Spec.ImageStream = ""
+			b.Spec.Output = &BuildOutput{
+				To:             corev1.ObjectReference{Kind: OutputImageStreamTag, Namespace: "hpc", Name: "stack:v1"},
+				AdditionalTags: []string{"latest", "0a1b2c3"},
+			}
+		}, true},
+		{"output to a registry", func(b *Build) {
+			b.Spec.ImageStream = ""
+			b.Spec.Output = &BuildOutput{
+				To:         corev1.ObjectReference{Kind: OutputDockerImage, Name: "registry.local:5000/hpc/stack:v1"},
+				PushSecret: &corev1.LocalObjectReference{Name: "push"},
+			}
+		}, true},
+		{"output and image stream", func(b *Build) {
+			b.Spec.Output = &BuildOutput{To: corev1.ObjectReference{Kind: OutputImageStreamTag, Name: "stack:v1"}}
+		}, false},
+		{"output without a registry", func(b *Build) {
+			b.Spec.ImageStream = ""
+			b.Spec.Output = &BuildOutput{To: corev1.ObjectReference{Kind: OutputDockerImage, Name: "stack:v1"}}
+		}, false},
+		{"output of an unknown kind", func(b *Build) {
+			b.Spec.ImageStream = ""
+			b.Spec.Output = &BuildOutput{To: corev1.ObjectReference{Kind: "ImageStreamImage", Name: "stack:v1"}}
+		}, false},
+		{"duplicate additional tags", func(b *Build) {
+			b.Spec.ImageStream = ""
+			b.Spec.Output = &BuildOutput{
+				To:             corev1.ObjectReference{Kind: OutputImageStreamTag, Name: "stack:v1"},
+				AdditionalTags: []string{"latest", "latest"},
+			}
+		}, false},
+		{"image stream policy", func(b *Build) {
+			b.Spec.ImageStreamPolicy = &ImageStreamPolicy{
+				LookupLocal:     true,
+				ReferencePolicy: TagReferenceLocal,
+				TagRetention:    TagRetentionRetain,
+			}
+		}, true},
+		{"image stream policy of a registry output", func(b *Build) {
+			b.Spec.ImageStream = ""
+			b.Spec.Output = &BuildOutput{To: corev1.ObjectReference{Kind: OutputDockerImage, Name: "quay.io/hpc/stack:v1"}}
+			b.Spec.ImageStreamPolicy = &ImageStreamPolicy{LookupLocal: true}
+		}, false},
+		{"image stream policy with the Kubernetes backend", func(b *Build) {
+			b.Spec.Backend = BackendKubernetes
+			b.Spec.ImageStreamPolicy = &ImageStreamPolicy{LookupLocal: true}
+		}, false},
+		{"install with an output", func(b *Build) {
+			b.Spec.ImageStream = ""
+			b.Spec.Install = &InstallSpec{PersistentVolumeClaim: corev1.LocalObjectReference{Name: "spack-tree"}}
+			b.Spec.Output = &BuildOutput{To: corev1.ObjectReference{Kind: OutputImageStreamTag, Name: "stack:v1"}}
+		}, false},
+	}
+
+	for _, tt := range tests {
+		b := validBuild()
+		tt.mutate(b)
+		err := b.ValidateCreate()
+		if tt.valid && err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+		}
+		if !tt.valid && err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+		}
+	}
+}
+
+func TestValidateUpdateUnchangedSpec(t *testing.T) {
+	old := validBuild()
+	old.Spec.Environment = nil
+
+	b := old.DeepCopy()
+	b.Finalizers = nil
+	if err := b.ValidateUpdate(old); err != nil {
+		t.Errorf("unexpected error updating a Build with an unchanged spec: %v", err)
+	}
+
+	b.Spec.ImageStream = "stack"
+	if err := b.ValidateUpdate(old); err == nil {
+		t.Error("expected an error updating a Build to an invalid spec")
+	}
+}
+
+func TestDefault(t *testing.T) {
+	defer SetBuildDefaults(BuildDefaults{})
+
+	b := validBuild()
+	b.Spec.ImageStream = "stack"
+	b.Default()
+	if b.Spec.ImageStream != "stack:latest" || b.Spec.SpackVersion != "v0.16.0" || b.Spec.OS != OSFedora || b.Spec.Backend != BackendOpenShift || b.Spec.BaseImage != "" ||
+		b.Spec.RunPolicy != RunPolicyParallel || *b.Spec.SuccessfulBuildsHistoryLimit != 3 {
+		t.Errorf("unexpected built-in defaults: %+v", b.Spec)
+	}
+
+	limit := int32(1)
+	SetBuildDefaults(BuildDefaults{SpackVersion: "v0.17.0", FailedBuildsHistoryLimit: &limit, Tag: "dev"})
+	b = validBuild()
+	b.Spec.ImageStream = "stack"
+	b.Spec.RunPolicy = RunPolicySerial
+	b.Default()
+	if b.Spec.ImageStream != "stack:dev" || b.Spec.SpackVersion != "v0.17.0" ||
+		b.Spec.RunPolicy != RunPolicySerial || *b.Spec.FailedBuildsHistoryLimit != 1 ||
+		*b.Spec.SuccessfulBuildsHistoryLimit != 3 {
+		t.Errorf("unexpected configured defaults: %+v", b.Spec)
+	}
+	if err := b.ValidateCreate(); err != nil {
+		t.Errorf("defaulted Build is invalid: %v", err)
+	}
+
+	SetBuildDefaults(BuildDefaults{BinaryMirror: &BinaryMirror{URL: "s3://spack"}})
+	b = validBuild()
+	b.Default()
+	if b.Spec.BinaryMirror == nil || b.Spec.BinaryMirror.Name != DefaultBinaryMirrorName || b.Spec.BinaryMirror.URL != "s3://spack" {
+		t.Errorf("unexpected binary mirror default: %+v", b.Spec.BinaryMirror)
+	}
+
+	b = validBuild()
+	b.Spec.ImageStream = ""
+	b.Spec.Output = &BuildOutput{To: corev1.ObjectReference{Name: "stack"}}
+	b.Default()
+	if b.Spec.Output.To.Kind != OutputImageStreamTag || b.Spec.Output.To.Name != "stack:latest" {
+		t.Errorf("unexpected output defaults: %+v", b.Spec.Output.To)
+	}
+	b.Spec.Output.To = corev1.ObjectReference{Kind: OutputDockerImage, Name: "registry.local:5000/stack"}
+	b.Default()
+	if b.Spec.Output.To.Name != "registry.local:5000/stack:latest" {
+		t.Errorf("unexpected output tag default: %s", b.Spec.Output.To.Name)
+	}
+}
+
+func TestSpackVersionBefore(t *testing.T) {
+	tests := []struct {
+		version, min string
+		before       bool
+	}{
+		{"v0.16.0", "v0.17", true},
+		{"v0.17.0", "v0.17", false},
+		{"0.21.1", "v0.21", false},
+		{"v1.0.0", "v0.21", false},
+		{"develop", "v0.21", false},
+		{"", "v0.21", false},
+	}
+	for _, tt := range tests {
+		if before := SpackVersionBefore(tt.version, tt.min); before != tt.before {
+			t.Errorf("SpackVersionBefore(%q, %q) = %v, want %v", tt.version, tt.min, before, tt.before)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+)
+
+// DefaultBinaryMirrorName is the name of the binary mirror in the Spack
+// configuration of the builds
+const DefaultBinaryMirrorName = "buildcache"
+
+// Keys of the Secret holding the credentials of a binary mirror
+const (
+	// MirrorAccessKeyID and MirrorSecretAccessKey are the credentials of
+	// an S3-compatible mirror
+	MirrorAccessKeyID     = "AWS_ACCESS_KEY_ID"
+	MirrorSecretAccessKey = "AWS_SECRET_ACCESS_KEY"
+	// MirrorOCIUsername and MirrorOCIPassword are the credentials of an
+	// OCI registry mirror
+	MirrorOCIUsername = "OCI_USERNAME"
+	MirrorOCIPassword = "OCI_PASSWORD"
+	// MirrorSigningKey is the ASCII-armored GPG private key signing the
+	// packages pushed to a mirror, as exported by gpg --export-secret-keys
+	// --armor
+	MirrorSigningKey = "SIGNING_KEY"
+)
+
+// BinaryMirror describes a Spack binary mirror, the build cache packages
+// are installed from when available and pushed to once built from source
+type BinaryMirror struct {
+	// Name of the mirror in the Spack configuration, defaults to buildcache
+	// +optional
+	Name string `json:"name,omitempty"`
+	// URL of the mirror: s3://bucket/prefix for S3-compatible object
+	// stores, oci://registry/repository for OCI registries (Spack v0.21 and
+	// later), or a file, http or https URL
+	URL string `json:"url"`
+	// Endpoint is the URL of the S3-compatible object store, such as a
+	// MinIO server, when it is not AWS S3
+	// +optional
+	Endpoint string `json:"endpoint,omitempty"`
+	// CredentialsSecret references a Secret of the namespace of the Build
+	// holding AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY for an S3 mirror,
+	// or OCI_USERNAME and OCI_PASSWORD for an OCI mirror. The credentials
+	// are only available to the builder stage, the Build must request a
+	// runtime stage so that they are not recorded in the images.
+	// +optional
+	CredentialsSecret *corev1.LocalObjectReference `json:"credentialsSecret,omitempty"`
+	// SigningKeySecret references a Secret of the namespace of the Build
+	// holding the GPG key under SIGNING_KEY. The key is trusted by Spack,
+	// the packages pushed to the mirror are signed with it and the
+	// signature of the packages installed from the mirror is verified. As
+	// with the credentials the Build must request a runtime stage.
+	// +optional
+	SigningKeySecret *corev1.LocalObjectReference `json:"signingKeySecret,omitempty"`
+	// Push uploads the packages built from source to the mirror, defaults
+	// to true
+	// +optional
+	Push *bool `json:"push,omitempty"`
+	// InsecureSkipTLSVerify disables the verification of the certificate
+	// of the mirror, for local stand-ins
+	// +optional
+	InsecureSkipTLSVerify bool `json:"insecureSkipTLSVerify,omitempty"`
+}
+
+// IsPushEnabled returns true when the packages built from source are
+// pushed to the mirror
+func (m *BinaryMirror) IsPushEnabled() bool {
+	return m.Push == nil || *m.Push
+}
+
+// DefaultSourceMirrorName is the name of the source mirror in the Spack
+// configuration of the builds
+const DefaultSourceMirrorName = "sources"
+
+// SourceMirror describes a Spack source mirror, as created by spack mirror
+// create, the source archives of the packages built from source are
+// fetched from
+type SourceMirror struct {
+	// Name of the mirror in the Spack configuration, defaults to sources
+	// +optional
+	Name string `json:"name,omitempty"`
+	// URL of the mirror: http or https for a mirror served over HTTP,
+	// oci://registry/repository for a mirror pushed as OCI artifacts
+	// (Spack v0.21 and later). Exclusive with PersistentVolumeClaim.
+	// +optional
+	URL string `json:"url,omitempty"`
+	// PersistentVolumeClaim of the namespace of the Build holding the
+	// mirror, the operator serves it to the builds over HTTP. Exclusive
+	// with URL.
+	// +optional
+	PersistentVolumeClaim *corev1.LocalObjectReference `json:"persistentVolumeClaim,omitempty"`
+	// Path of the mirror within the PersistentVolumeClaim, defaults to its
+	// root
+	// +optional
+	Path string `json:"path,omitempty"`
+	// InsecureSkipTLSVerify disables the verification of the certificate
+	// of the mirror
+	// +optional
+	InsecureSkipTLSVerify bool `json:"insecureSkipTLSVerify,omitempty"`
+	// AirGapped disables every network fetch but the ones from the mirrors
+	// of the Build, on a best-effort basis: the fetches go through a proxy
+	// refusing the connections and git only clones local repositories, but
+	// the network of the builds is not restricted. The source archives of
+	// the environments are fetched before anything is built, the build
+	// fails with the SourceArchiveMissing reason when one is missing from
+	// the mirror.
+	// +optional
+	AirGapped bool `json:"airGapped,omitempty"`
+}
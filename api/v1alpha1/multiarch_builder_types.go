@@ -17,24 +17,472 @@ limitations under the License.
 package v1alpha1
 
 import (
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 )
 
 // BuildSpec defines the desired state of a package
 // +k8s:openapi-gen=true
 type BuildSpec struct {
-	// ImageStream stores the stream where to push the built image
+	// ImageStream stores the stream where to push the built image, as an
+	// ImageStreamTag of the namespace of the Build. Superseded by Output.
+	// +optional
 	ImageStream string `json:"imagestream,omitempty"`
+	// Output is where the images of the environments are pushed, an
+	// ImageStreamTag of the namespace of the Build or an image of any OCI
+	// registry
+	// +optional
+	Output *BuildOutput `json:"output,omitempty"`
+	// ImageStreamPolicy configures the ImageStream the images are pushed
+	// to with the OpenShift backend, created by the operator when it does
+	// not exist
+	// +optional
+	ImageStreamPolicy *ImageStreamPolicy `json:"imageStreamPolicy,omitempty"`
 	// Environment stores the spack.yaml env configuration file
 	Environment []SpackEnvionment `json:"environment,omitempty"`
+	// Architectures lists the CPU architectures every environment is built
+	// for, each one on nodes of that architecture. When empty the
+	// environments are built on any node.
+	// +optional
+	Architectures []Architecture `json:"architectures,omitempty"`
+	// Targets lists the archspec microarchitectures (e.g. x86_64_v3,
+	// skylake_avx512, neoverse_n1) every environment is built for, each one
+	// on nodes able to run it. Targets take precedence over Architectures.
+	// The images of the targets of an architecture share a manifest list
+	// entry unless they are x86_64 levels, the amd64 variants, so a Build
+	// pushing images lists a single other target per architecture.
+	// +optional
+	Targets []string `json:"targets,omitempty"`
+	// SpackVersion is the Spack release the environments are built with,
+	// defaulted from the operator configuration
+	// +optional
+	SpackVersion string `json:"spackVersion,omitempty"`
+	// OS is the distribution the environments are built on and for,
+	// defaulted from the operator configuration
+	// +optional
+	OS OperatingSystem `json:"os,omitempty"`
+	// BaseImage is the ImageStreamTag of the image holding Spack the
+	// environments are built on. When empty they are built on the base
+	// image of the SpackRelease of SpackVersion.
+	// +optional
+	BaseImage string `json:"baseImage,omitempty"`
+	// Runtime requests images holding only the environments, copied from
+	// the build stage into a slim runtime image. When unset the images
+	// hold Spack and the whole build tree.
+	// +optional
+	Runtime *RuntimeSpec `json:"runtime,omitempty"`
+	// BinaryMirror is the Spack build cache packages are installed from
+	// and pushed to, defaulted from the operator configuration
+	// +optional
+	BinaryMirror *BinaryMirror `json:"binaryMirror,omitempty"`
+	// SourceMirror is the Spack mirror the source archives of the packages
+	// are fetched from, optionally the only network location the builds
+	// can reach
+	// +optional
+	SourceMirror *SourceMirror `json:"sourceMirror,omitempty"`
+	// Templates references a ConfigMap of the namespace of the Build whose
+	// keys override the build templates of the same name shipped with the
+	// operator (Dockerfile.tmpl, build.sh.tmpl)
+	// +optional
+	Templates *corev1.LocalObjectReference `json:"templates,omitempty"`
+	// Backend runs the builds of the environments, defaulted from the
+	// operator configuration
+	// +optional
+	Backend BuildBackend `json:"backend,omitempty"`
+	// Kubernetes configures the build Pods of the Kubernetes backend
+	// +optional
+	Kubernetes *KubernetesBuildSpec `json:"kubernetes,omitempty"`
+	// Install requests the environments to be installed onto a
+	// PersistentVolumeClaim by Jobs running Spack, instead of being built
+	// into images
+	// +optional
+	Install *InstallSpec `json:"install,omitempty"`
+	// RunPolicy describes how the builds of an environment run when
+	// several are started, defaulted from the operator configuration
+	// +optional
+	RunPolicy RunPolicy `json:"runPolicy,omitempty"`
+	// SuccessfulBuildsHistoryLimit is the number of successful builds kept
+	// for every environment, defaulted from the operator configuration
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	SuccessfulBuildsHistoryLimit *int32 `json:"successfulBuildsHistoryLimit,omitempty"`
+	// FailedBuildsHistoryLimit is the number of failed builds kept for
+	// every environment, defaulted from the operator configuration
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	FailedBuildsHistoryLimit *int32 `json:"failedBuildsHistoryLimit,omitempty"`
+}
+
+// RuntimeSpec describes the runtime stage of the environment images
+type RuntimeSpec struct {
+	// Image the runtime stage is based on, defaults to the image of the
+	// distribution of the Build. It must use the package manager of that
+	// distribution when Packages are listed.
+	// +optional
+	Image string `json:"image,omitempty"`
+	// Packages are the OS packages installed in the runtime stage, such as
+	// the system libraries the environments link against
+	// +optional
+	Packages []string `json:"packages,omitempty"`
+}
+
+// BuildOutput describes where the images of the environments are pushed.
+// Each environment is pushed to the tags of To suffixed with its name, and
+// with its platform when the Build lists architectures or targets.
+type BuildOutput struct {
+	// To is the ImageStreamTag (stream:tag, in the namespace of the Build)
+	// or the DockerImage (registry/repository:tag) the images are pushed
+	// to. The tag defaults to the one of the operator configuration.
+	To corev1.ObjectReference `json:"to"`
+	// AdditionalTags the images are tagged with once pushed, such as the
+	// version of the software, a git SHA or latest
+	// +optional
+	AdditionalTags []string `json:"additionalTags,omitempty"`
+	// PushSecret is a kubernetes.io/dockerconfigjson Secret authenticating
+	// the builds and the operator against the registry of To, in place of
+	// the push Secret of the Kubernetes backend
+	// +optional
+	PushSecret *corev1.LocalObjectReference `json:"pushSecret,omitempty"`
+}
+
+// Kinds of the outputs of a Build
+const (
+	// OutputImageStreamTag pushes the images to an ImageStreamTag
+	OutputImageStreamTag = "ImageStreamTag"
+	// OutputDockerImage pushes the images to a registry repository
+	OutputDockerImage = "DockerImage"
+)
+
+// ImageStreamPolicy configures the ImageStream of the ImageStreamTags the
+// images are pushed to. The Builds pushing to the same ImageStream must
+// agree on its policy.
+type ImageStreamPolicy struct {
+	// LookupLocal lets the workloads of the namespace of the ImageStream
+	// refer to its tags by name, as its lookupPolicy.local
+	// +optional
+	LookupLocal bool `json:"lookupLocal,omitempty"`
+	// ReferencePolicy of the tags of the ImageStream, Local serves their
+	// images through the integrated registry. Each tag produced by the
+	// Build is added to the spec of the ImageStream once pushed, following
+	// its last image. The tags are left as they are when unset.
+	// +optional
+	ReferencePolicy TagReferencePolicy `json:"referencePolicy,omitempty"`
+	// ScheduledImport periodically imports the tags of the ImageStream
+	// tracking the images of other registries
+	// +optional
+	ScheduledImport bool `json:"scheduledImport,omitempty"`
+	// TagRetention tells whether the tags produced by the Build are
+	// deleted along with it, defaults to Delete
+	// +optional
+	TagRetention TagRetentionPolicy `json:"tagRetention,omitempty"`
+}
+
+// TagReferencePolicy is how the images of the tags of an ImageStream are
+// pulled, as the reference policy of an ImageStream tag
+// +kubebuilder:validation:Enum=Source;Local
+type TagReferencePolicy string
+
+// Reference policies of the tags
+const (
+	// TagReferenceSource pulls the images from their source registry
+	TagReferenceSource TagReferencePolicy = "Source"
+	// TagReferenceLocal pulls the images through the integrated registry
+	TagReferenceLocal TagReferencePolicy = "Local"
+)
+
+// TagRetentionPolicy tells what becomes of the tags produced by a Build
+// when it is deleted
+// +kubebuilder:validation:Enum=Delete;Retain
+type TagRetentionPolicy string
+
+// Retention policies of the tags
+const (
+	// TagRetentionDelete deletes the tags along with the Build
+	TagRetentionDelete TagRetentionPolicy = "Delete"
+	// TagRetentionRetain leaves the tags in the ImageStream
+	TagRetentionRetain TagRetentionPolicy = "Retain"
+)
+
+// BuildBackend is the kind of resources the images of the environments
+// are built with
+// +kubebuilder:validation:Enum=OpenShift;Kubernetes
+type BuildBackend string
+
+// Build backends
+const (
+	// BackendOpenShift builds the images with OpenShift BuildConfigs and
+	// pushes them to ImageStreams
+	BackendOpenShift BuildBackend = "OpenShift"
+	// BackendKubernetes builds the images in Pods and pushes them to an
+	// OCI registry
+	BackendKubernetes BuildBackend = "Kubernetes"
+)
+
+// KubernetesBuildSpec configures the Pods building the images with the
+// Kubernetes backend
+type KubernetesBuildSpec struct {
+	// Tool building the images in the Pods, defaults to Buildah
+	// +optional
+	Tool BuildTool `json:"tool,omitempty"`
+	// Image of the build Pods, defaults to the image configured in the
+	// operator for the tool
+	// +optional
+	Image string `json:"image,omitempty"`
+	// Registry is the repository prefix the images are pushed to, such as
+	// quay.io/organization. Defaults to the namespace of the Build in the
+	// image registry of the operator.
+	// +optional
+	Registry string `json:"registry,omitempty"`
+	// PushSecret is a kubernetes.io/dockerconfigjson Secret authenticating
+	// the build Pods against the registries they pull from and push to, and
+	// the operator when it pushes the manifest lists and additional tags
+	// +optional
+	PushSecret *corev1.LocalObjectReference `json:"pushSecret,omitempty"`
+}
+
+// BuildTool builds the images in the Pods of the Kubernetes backend
+// +kubebuilder:validation:Enum=Buildah;Kaniko
+type BuildTool string
+
+// Build tools
+const (
+	// ToolBuildah builds the images with rootless buildah
+	ToolBuildah BuildTool = "Buildah"
+	// ToolKaniko builds the images with the kaniko executor
+	ToolKaniko BuildTool = "Kaniko"
+)
+
+// InstallMountPath is where the install claim of a Build is mounted in the
+// install Jobs. The installed binaries reference the software installed
+// under it, the Pods using them must mount the claim at the same path.
+const InstallMountPath = "/opt/spack-install"
+
+// InstallSpec describes the install tree the environments are installed
+// into, shared by the Builds of a namespace as a traditional /opt/spack
+// tree. The packages are installed under opt/, the environments under
+// environments/ and their views under views/, both named after the Build,
+// the environment and the platform. The install Jobs of the Builds sharing
+// a claim run one at a time, whatever their run policy.
+type InstallSpec struct {
+	// PersistentVolumeClaim of the namespace of the Build holding the
+	// install tree. Its name labels the install Jobs, it must be a DNS
+	// label.
+	PersistentVolumeClaim corev1.LocalObjectReference `json:"persistentVolumeClaim"`
+	// Path of the install tree within the claim, defaults to its root
+	// +optional
+	Path string `json:"path,omitempty"`
+	// Image of the install Jobs, defaults to the Spack base image of the
+	// Build. It must provide flock, which locks the install tree.
+	// +optional
+	Image string `json:"image,omitempty"`
 }
 
+// RunPolicy describes how the builds of an environment run, as the run
+// policy of an OpenShift BuildConfig
+// +kubebuilder:validation:Enum=Parallel;Serial;SerialLatestOnly
+type RunPolicy string
+
+// Run policies of the builds
+const (
+	RunPolicyParallel         RunPolicy = "Parallel"
+	RunPolicySerial           RunPolicy = "Serial"
+	RunPolicySerialLatestOnly RunPolicy = "SerialLatestOnly"
+)
+
+// Architecture is a CPU architecture as reported by the kubernetes.io/arch
+// node label
+// +kubebuilder:validation:Enum=amd64;arm64;ppc64le;s390x
+type Architecture string
+
+// Architectures supported by the builds
+const (
+	ArchitectureAMD64   Architecture = "amd64"
+	ArchitectureARM64   Architecture = "arm64"
+	ArchitecturePPC64LE Architecture = "ppc64le"
+	ArchitectureS390X   Architecture = "s390x"
+)
+
+// OperatingSystem is a distribution Spack base images are built on
+// +kubebuilder:validation:Enum=fedora;ubi8;rockylinux8;ubuntu20.04;amazonlinux2
+type OperatingSystem string
+
+// Distributions of the Spack base images
+const (
+	OSFedora       OperatingSystem = "fedora"
+	OSUBI8         OperatingSystem = "ubi8"
+	OSRockyLinux8  OperatingSystem = "rockylinux8"
+	OSUbuntu2004   OperatingSystem = "ubuntu20.04"
+	OSAmazonLinux2 OperatingSystem = "amazonlinux2"
+)
+
 // BuildStatus defines the observed state of a build
 // +k8s:openapi-gen=true
 type BuildStatus struct {
 	State      InstallStatus `json:"state,omitempty"`
 	LastUpdate metav1.Time   `json:"lastUpdate,omitempty"`
 	Reason     string        `json:"reason,omitempty"`
+	// StartTimestamp is the time the first build of the environments started
+	// +optional
+	StartTimestamp *metav1.Time `json:"startTimestamp,omitempty"`
+	// CompletionTimestamp is the time the last build of the environments
+	// finished, set once every environment build has finished
+	// +optional
+	CompletionTimestamp *metav1.Time `json:"completionTimestamp,omitempty"`
+	// Environments holds the observed state of every Spack Environment
+	// in the BuildSpec, one entry per environment and platform
+	// +optional
+	Environments []EnvironmentStatus `json:"environments,omitempty"`
+	// ManifestLists holds the manifest lists assembled from the images of
+	// every platform, one entry per environment, when the BuildSpec lists
+	// architectures or targets
+	// +optional
+	ManifestLists []ManifestListStatus `json:"manifestLists,omitempty"`
+	// Outputs holds the images pushed to the output of the Build, one entry
+	// per environment
+	// +optional
+	Outputs []OutputStatus `json:"outputs,omitempty"`
+	// SigningKeyFingerprint is the fingerprint of the GPG key signing the
+	// packages pushed to the binary mirror
+	// +optional
+	SigningKeyFingerprint string `json:"signingKeyFingerprint,omitempty"`
+	// ObservedGeneration is the generation of the Build the status was
+	// computed for
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// Conditions describe the current state of the Build
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// EnvironmentStatus defines the observed state of the resources built
+// for a single Spack Environment on a single platform
+type EnvironmentStatus struct {
+	// Name of the Spack Environment
+	Name string `json:"name"`
+	// Architecture the environment is built for, empty when the BuildSpec
+	// does not list any architecture
+	// +optional
+	Architecture Architecture `json:"architecture,omitempty"`
+	// Target is the archspec microarchitecture the environment is built
+	// for, empty when the BuildSpec does not list any target
+	// +optional
+	Target string `json:"target,omitempty"`
+	// ConfigMap holding the spack.yaml of the environment
+	ConfigMap string `json:"configMap,omitempty"`
+	// BuildConfig producing the image of the environment for the platform,
+	// the PodTemplate of the build Pods with the Kubernetes backend
+	BuildConfig string `json:"buildConfig,omitempty"`
+	// Image is the ImageStreamTag (namespace/stream:tag out of the
+	// namespace of the Build) or the registry image the environment image
+	// is pushed to
+	Image      string        `json:"image,omitempty"`
+	State      InstallStatus `json:"state,omitempty"`
+	LastUpdate metav1.Time   `json:"lastUpdate,omitempty"`
+	Reason     string        `json:"reason,omitempty"`
+	// LatestBuild is the name of the latest OpenShift Build spawned by
+	// the BuildConfig
+	// +optional
+	LatestBuild string `json:"latestBuild,omitempty"`
+	// ImageDigest is the digest of the image pushed by the latest
+	// OpenShift Build
+	// +optional
+	ImageDigest string `json:"imageDigest,omitempty"`
+	// ExitCode is the exit code of the container of the latest build Pod
+	// with the Kubernetes backend, once it has terminated
+	// +optional
+	ExitCode *int32 `json:"exitCode,omitempty"`
+	// View is the directory of the view of the environment on the install
+	// claim, when the Build installs the environments
+	// +optional
+	View string `json:"view,omitempty"`
+	// InstalledSpecs are the root specs of the environment installed by
+	// the latest install Job, the first ones when they do not fit in the
+	// termination message of the Job. All of them are listed in the
+	// installed-specs file of the environment directory on the claim.
+	// +optional
+	InstalledSpecs []InstalledSpec `json:"installedSpecs,omitempty"`
+	// InstalledSpecCount is the number of root specs of the environment
+	// installed by the latest install Job
+	// +optional
+	InstalledSpecCount int32 `json:"installedSpecCount,omitempty"`
+	// StartTimestamp is the time the latest OpenShift Build started running
+	// +optional
+	StartTimestamp *metav1.Time `json:"startTimestamp,omitempty"`
+	// CompletionTimestamp is the time the latest OpenShift Build finished,
+	// whether it failed or succeeded
+	// +optional
+	CompletionTimestamp *metav1.Time `json:"completionTimestamp,omitempty"`
+	// SpecHash is the hash of the BuildConfig spec the latest OpenShift
+	// Build was started for
+	// +optional
+	SpecHash string `json:"specHash,omitempty"`
+	// ObservedGeneration is the generation of the Build the latest
+	// OpenShift Build was started for
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// History records the latest OpenShift Builds started for the
+	// environment, most recent first
+	// +optional
+	History []BuildRecord `json:"history,omitempty"`
+}
+
+// InstalledSpec is a root spec of an environment installed onto the
+// install claim of a Build
+type InstalledSpec struct {
+	// Spec is the name and version of the package
+	Spec string `json:"spec"`
+	// Hash is the DAG hash of the installed spec
+	Hash string `json:"hash"`
+}
+
+// ManifestListStatus defines the observed state of the manifest list
+// referencing the per-architecture images of a Spack Environment
+type ManifestListStatus struct {
+	// Name of the Spack Environment
+	Name string `json:"name"`
+	// Image is the ImageStreamTag (namespace/stream:tag out of the
+	// namespace of the Build) or the registry image the manifest list is
+	// pushed to
+	Image string `json:"image,omitempty"`
+	// Digest of the pushed manifest list
+	// +optional
+	Digest string `json:"digest,omitempty"`
+	// Manifests are the digests of the per-architecture images referenced
+	// by the manifest list
+	// +optional
+	Manifests  []string    `json:"manifests,omitempty"`
+	LastUpdate metav1.Time `json:"lastUpdate,omitempty"`
+	Reason     string      `json:"reason,omitempty"`
+}
+
+// OutputStatus defines the observed state of the images of a Spack
+// Environment pushed to the output of a Build
+type OutputStatus struct {
+	// Name of the Spack Environment
+	Name string `json:"name"`
+	// Images are the references of every tag the environment is pushed to
+	// +optional
+	Images []string `json:"images,omitempty"`
+	// Digest of the image, the manifest list when the Build lists
+	// architectures or targets, all the tags point to
+	// +optional
+	Digest     string      `json:"digest,omitempty"`
+	LastUpdate metav1.Time `json:"lastUpdate,omitempty"`
+	Reason     string      `json:"reason,omitempty"`
+}
+
+// BuildRecord records which generation of a Build produced which image
+type BuildRecord struct {
+	// Generation of the Build the OpenShift Build was started for
+	Generation int64 `json:"generation"`
+	// Build is the name of the OpenShift Build
+	Build string `json:"build"`
+	// ImageDigest is the digest of the image pushed by the OpenShift Build
+	// +optional
+	ImageDigest string `json:"imageDigest,omitempty"`
 }
 
 // +kubebuilder:object:root=true
@@ -56,12 +504,114 @@ type Build struct {
 	Status BuildStatus `json:"status,omitempty"`
 }
 
-// SpackEnvionment holds the definition of a Spack Environment.
+// SpackEnvionment holds the definition of a Spack Environment, either as a
+// raw spack.yaml in Data or through the structured fields, which the
+// operator renders into a spack.yaml
 type SpackEnvionment struct {
 	// Name of the Spack Environment profile to be used in buildConfig.
 	Name *string `json:"name"`
 	// Specification of the Spack Environment to be consumed by the Spack builder.
-	Data *string `json:"data"`
+	// It cannot be combined with the structured fields.
+	// +optional
+	Data *string `json:"data,omitempty"`
+	// Specs are the root specs installed in the environment
+	// +optional
+	Specs []string `json:"specs,omitempty"`
+	// Packages holds the preferences of the packages of the environment,
+	// indexed by package name ("all" applies to every package)
+	// +optional
+	Packages map[string]SpackPackage `json:"packages,omitempty"`
+	// Compilers available to the environment besides the ones of the base image
+	// +optional
+	Compilers []SpackCompiler `json:"compilers,omitempty"`
+	// View is the absolute directory the environment view is linked to and
+	// copied from into the runtime image, defaults to /opt/view
+	// +optional
+	View string `json:"view,omitempty"`
+	// Concretization selects whether the specs are concretized together
+	// or separately
+	// +optional
+	Concretization Concretization `json:"concretization,omitempty"`
+	// Config holds the Spack configuration of the environment, as the
+	// config section of a spack.yaml
+	// +kubebuilder:pruning:PreserveUnknownFields
+	// +optional
+	Config *runtime.RawExtension `json:"config,omitempty"`
+}
+
+// Concretization selects how the specs of a Spack environment are concretized
+// +kubebuilder:validation:Enum=together;separately
+type Concretization string
+
+// Concretization modes of the Spack environments
+const (
+	ConcretizationTogether   Concretization = "together"
+	ConcretizationSeparately Concretization = "separately"
+)
+
+// SpackPackage holds the preferences of a package in a Spack environment
+type SpackPackage struct {
+	// Version lists the preferred versions of the package
+	// +optional
+	Version []string `json:"version,omitempty"`
+	// Variants are the preferred variants of the package (e.g. "+mpi ~shared")
+	// +optional
+	Variants string `json:"variants,omitempty"`
+	// Compiler lists the preferred compilers of the package
+	// +optional
+	Compiler []string `json:"compiler,omitempty"`
+	// Target lists the preferred microarchitectures of the package
+	// +optional
+	Target []string `json:"target,omitempty"`
+	// Providers lists the preferred providers of virtual packages (e.g.
+	// mpi: [openmpi])
+	// +optional
+	Providers map[string][]string `json:"providers,omitempty"`
+	// Buildable is false when the package must come from Externals
+	// +optional
+	Buildable *bool `json:"buildable,omitempty"`
+	// Externals lists installations of the package already in the base image
+	// +optional
+	Externals []SpackExternal `json:"externals,omitempty"`
+}
+
+// SpackExternal is an installation of a package not managed by Spack
+type SpackExternal struct {
+	// Spec of the installed package (e.g. openmpi@4.0.5)
+	Spec string `json:"spec"`
+	// Prefix the package is installed in
+	// +optional
+	Prefix string `json:"prefix,omitempty"`
+	// Modules loading the package
+	// +optional
+	Modules []string `json:"modules,omitempty"`
+}
+
+// SpackCompiler is a compiler available to a Spack environment
+type SpackCompiler struct {
+	// Spec of the compiler (e.g. gcc@10.2.0)
+	Spec string `json:"spec"`
+	// Paths of the compiler executables
+	Paths SpackCompilerPaths `json:"paths"`
+	// OperatingSystem the compiler runs on (e.g. centos8)
+	OperatingSystem string `json:"operatingSystem"`
+	// Target architecture of the compiler (e.g. x86_64)
+	Target string `json:"target"`
+	// Modules loaded to use the compiler
+	// +optional
+	Modules []string `json:"modules,omitempty"`
+}
+
+// SpackCompilerPaths are the paths of the executables of a compiler
+type SpackCompilerPaths struct {
+	// +optional
+	CC string `json:"cc,omitempty"`
+	// +optional
+	CXX string `json:"cxx,omitempty"`
+	// +optional
+	F77 string `json:"f77,omitempty"`
+	// +optional
+	FC string `json:"fc,omitempty"`
 }
 
 // +kubebuilder:object:root=true
@@ -101,6 +651,52 @@ const (
 	// DeletedStatus indicates that the package build have been
 	// created
 	DeletedStatus InstallStatus = "deleted"
+
+	// NewPackage indicates that the package build have been
+	// created but not yet scheduled
+	NewPackage InstallStatus = "new"
+
+	// PendingPackage indicates that the package build is
+	// waiting for its build pod to start
+	PendingPackage InstallStatus = "pending"
+
+	// RunningPackage indicates that the package build is
+	// running
+	RunningPackage InstallStatus = "running"
+
+	// CompletedPackage indicates that the package build have
+	// succeeded and the image has been pushed
+	CompletedPackage InstallStatus = "complete"
+
+	// FailedPackage indicates that the package build have
+	// executed and failed
+	FailedPackage InstallStatus = "failed"
+
+	// CancelledPackage indicates that the package build have
+	// been stopped before finishing
+	CancelledPackage InstallStatus = "cancelled"
+)
+
+// Condition types of a Build
+const (
+	// ConditionEnvironmentValid reports whether the resources of every
+	// Spack Environment have been created
+	ConditionEnvironmentValid = "EnvironmentValid"
+
+	// ConditionBaseImageReady reports whether the Spack base image the
+	// environments are built from is available
+	ConditionBaseImageReady = "BaseImageReady"
+
+	// ConditionBuildSucceeded reports whether the latest build of every
+	// Spack Environment has succeeded
+	ConditionBuildSucceeded = "BuildSucceeded"
+
+	// ConditionImagePushed reports whether the image of every Spack
+	// Environment has been pushed
+	ConditionImagePushed = "ImagePushed"
+
+	// ConditionReady reports whether the Build has produced all of its images
+	ConditionReady = "Ready"
 )
 
 func init() {
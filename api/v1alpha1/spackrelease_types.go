@@ -0,0 +1,141 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// DefaultSpackGitURI is the repository Spack releases are checked out from
+const DefaultSpackGitURI = "https://github.com/spack/spack"
+
+// SpackReleaseSpec defines the Spack release an operator base image is
+// built for
+type SpackReleaseSpec struct {
+	// Version of Spack (e.g. v0.16.0), Builds select the release through
+	// their SpackVersion. A version is held by a single SpackRelease.
+	// +kubebuilder:validation:MinLength=1
+	Version string `json:"version"`
+	// GitURI is the Spack repository, defaults to
+	// https://github.com/spack/spack
+	// +optional
+	GitURI string `json:"gitURI,omitempty"`
+	// GitRef is the branch, tag or commit checked out, defaults to Version
+	// +optional
+	GitRef string `json:"gitRef,omitempty"`
+	// OperatingSystems lists the distributions a base image is built on,
+	// defaults to the distribution of the operator configuration
+	// +optional
+	OperatingSystems []OperatingSystem `json:"operatingSystems,omitempty"`
+}
+
+// SpackReleaseStatus defines the observed state of the base images of a
+// Spack release
+type SpackReleaseStatus struct {
+	State      InstallStatus `json:"state,omitempty"`
+	LastUpdate metav1.Time   `json:"lastUpdate,omitempty"`
+	Reason     string        `json:"reason,omitempty"`
+	// Namespace of the ImageStream holding the base images
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+	// Images holds the observed state of the base image of every
+	// distribution
+	// +optional
+	Images []BaseImageStatus `json:"images,omitempty"`
+	// ObservedGeneration is the generation of the SpackRelease the status
+	// was computed for
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+}
+
+// BaseImageStatus defines the observed state of the base image of a Spack
+// release on a single distribution
+type BaseImageStatus struct {
+	// OS is the distribution the base image is built on
+	OS OperatingSystem `json:"os"`
+	// Image is the ImageStreamTag where the base image is pushed
+	// +optional
+	Image string `json:"image,omitempty"`
+	// BuildConfig producing the base image
+	// +optional
+	BuildConfig string        `json:"buildConfig,omitempty"`
+	State       InstallStatus `json:"state,omitempty"`
+	Reason      string        `json:"reason,omitempty"`
+	// LatestBuild is the name of the latest OpenShift Build spawned by
+	// the BuildConfig
+	// +optional
+	LatestBuild string `json:"latestBuild,omitempty"`
+	// ImageDigest is the digest of the image pushed by the latest
+	// OpenShift Build
+	// +optional
+	ImageDigest string `json:"imageDigest,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:path=spackreleases,scope=Cluster
+// +kubebuilder:printcolumn:name="Version",type=string,JSONPath=`.spec.version`
+// +kubebuilder:printcolumn:name="State",type=string,JSONPath=`.status.state`
+
+// SpackRelease is the Schema for the Spack releases API, the operator
+// builds the base images holding every release Builds can be built with
+type SpackRelease struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec SpackReleaseSpec `json:"spec,omitempty"`
+	// +optional
+	Status SpackReleaseStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// SpackReleaseList contains a list of SpackRelease
+type SpackReleaseList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []SpackRelease `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&SpackRelease{}, &SpackReleaseList{})
+}
+
+// GitURIOrDefault returns the Spack repository the release is checked out from
+func (r *SpackRelease) GitURIOrDefault() string {
+	if r.Spec.GitURI != "" {
+		return r.Spec.GitURI
+	}
+	return DefaultSpackGitURI
+}
+
+// GitRefOrDefault returns the git reference of the release
+func (r *SpackRelease) GitRefOrDefault() string {
+	if r.Spec.GitRef != "" {
+		return r.Spec.GitRef
+	}
+	return r.Spec.Version
+}
+
+// OperatingSystemsOrDefault returns the distributions the base images of
+// the release are built on, os when the release does not list any
+func (r *SpackRelease) OperatingSystemsOrDefault(os OperatingSystem) []OperatingSystem {
+	if len(r.Spec.OperatingSystems) > 0 {
+		return r.Spec.OperatingSystems
+	}
+	return []OperatingSystem{os}
+}
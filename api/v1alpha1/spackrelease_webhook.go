@@ -0,0 +1,92 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+	"fmt"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+// log is for logging in this package.
+var spackreleaselog = logf.Log.WithName("spackrelease-resource")
+
+// spackReleaseReader lists the SpackReleases the new versions are checked
+// against, set when the webhook is registered
+var spackReleaseReader client.Reader
+
+// SetupWebhookWithManager registers the webhooks of SpackRelease with the
+// Manager
+func (r *SpackRelease) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	spackReleaseReader = mgr.GetAPIReader()
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		Complete()
+}
+
+// +kubebuilder:webhook:path=/validate-multiarch-builder-io-v1alpha1-spackrelease,mutating=false,failurePolicy=fail,sideEffects=None,groups=multiarch.builder.io,resources=spackreleases,verbs=create;update,versions=v1alpha1,name=vspackrelease.multiarch.builder.io,admissionReviewVersions={v1,v1beta1}
+
+var _ webhook.Validator = &SpackRelease{}
+
+// ValidateCreate implements webhook.Validator so a webhook will be registered for the type
+func (r *SpackRelease) ValidateCreate() error {
+	spackreleaselog.Info("validate create", "name", r.Name)
+	return r.validateVersion()
+}
+
+// ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
+func (r *SpackRelease) ValidateUpdate(old runtime.Object) error {
+	spackreleaselog.Info("validate update", "name", r.Name)
+
+	if oldRelease, ok := old.(*SpackRelease); ok && oldRelease.Spec.Version == r.Spec.Version {
+		return nil
+	}
+	return r.validateVersion()
+}
+
+// ValidateDelete implements webhook.Validator so a webhook will be registered for the type
+func (r *SpackRelease) ValidateDelete() error {
+	return nil
+}
+
+// validateVersion rejects the versions of the other SpackReleases: the base
+// images are tagged with the version, which Builds select them with
+func (r *SpackRelease) validateVersion() error {
+	if spackReleaseReader == nil {
+		return nil
+	}
+	releases := &SpackReleaseList{}
+	if err := spackReleaseReader.List(context.TODO(), releases); err != nil {
+		return apierrors.NewInternalError(fmt.Errorf("listing the SpackReleases: %v", err))
+	}
+	for _, other := range releases.Items {
+		if other.Name != r.Name && other.Spec.Version == r.Spec.Version {
+			return apierrors.NewInvalid(GroupVersion.WithKind("SpackRelease").GroupKind(), r.Name, field.ErrorList{
+				field.Invalid(field.NewPath("spec", "version"), r.Spec.Version,
+					"already the version of SpackRelease "+other.Name),
+			})
+		}
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestValidateSpackReleaseVersion(t *testing.T) {
+	release := func(name, version string) *SpackRelease {
+		return &SpackRelease{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec:       SpackReleaseSpec{Version: version},
+		}
+	}
+	scheme := runtime.NewScheme()
+	if err := AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	spackReleaseReader = fake.NewClientBuilder().WithScheme(scheme).WithObjects(release("spack-0-16", "v0.16.0")).Build()
+	defer func() { spackReleaseReader = nil }()
+
+	tests := []struct {
+		name  string
+		err   func() error
+		valid bool
+	}{
+		{"new version", release("spack-0-17", "v0.17.0").ValidateCreate, true},
+		{"version of another release", release("spack-legacy", "v0.16.0").ValidateCreate, false},
+		{"unchanged version", func() error {
+			return release("spack-0-16", "v0.16.0").ValidateUpdate(release("spack-0-16", "v0.16.0"))
+		}, true},
+		{"update to the version of another release", func() error {
+			return release("spack-0-17", "v0.16.0").ValidateUpdate(release("spack-0-17", "v0.17.0"))
+		}, false},
+	}
+	for _, tt := range tests {
+		if err := tt.err(); (err == nil) != tt.valid {
+			t.Errorf("%s: got error %v, want valid %t", tt.name, err, tt.valid)
+		}
+	}
+}
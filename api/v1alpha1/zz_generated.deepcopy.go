@@ -21,9 +21,56 @@ limitations under the License.
 package v1alpha1
 
 import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BaseImageStatus) DeepCopyInto(out *BaseImageStatus) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BaseImageStatus.
+func (in *BaseImageStatus) DeepCopy() *BaseImageStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(BaseImageStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BinaryMirror) DeepCopyInto(out *BinaryMirror) {
+	*out = *in
+	if in.CredentialsSecret != nil {
+		in, out := &in.CredentialsSecret, &out.CredentialsSecret
+		*out = new(corev1.LocalObjectReference)
+		**out = **in
+	}
+	if in.SigningKeySecret != nil {
+		in, out := &in.SigningKeySecret, &out.SigningKeySecret
+		*out = new(corev1.LocalObjectReference)
+		**out = **in
+	}
+	if in.Push != nil {
+		in, out := &in.Push, &out.Push
+		*out = new(bool)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BinaryMirror.
+func (in *BinaryMirror) DeepCopy() *BinaryMirror {
+	if in == nil {
+		return nil
+	}
+	out := new(BinaryMirror)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Build) DeepCopyInto(out *Build) {
 	*out = *in
@@ -51,6 +98,36 @@ func (in *Build) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BuildDefaults) DeepCopyInto(out *BuildDefaults) {
+	*out = *in
+	if in.SuccessfulBuildsHistoryLimit != nil {
+		in, out := &in.SuccessfulBuildsHistoryLimit, &out.SuccessfulBuildsHistoryLimit
+		*out = new(int32)
+		**out = **in
+	}
+	if in.FailedBuildsHistoryLimit != nil {
+		in, out := &in.FailedBuildsHistoryLimit, &out.FailedBuildsHistoryLimit
+		*out = new(int32)
+		**out = **in
+	}
+	if in.BinaryMirror != nil {
+		in, out := &in.BinaryMirror, &out.BinaryMirror
+		*out = new(BinaryMirror)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BuildDefaults.
+func (in *BuildDefaults) DeepCopy() *BuildDefaults {
+	if in == nil {
+		return nil
+	}
+	out := new(BuildDefaults)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *BuildList) DeepCopyInto(out *BuildList) {
 	*out = *in
@@ -83,9 +160,60 @@ func (in *BuildList) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BuildOutput) DeepCopyInto(out *BuildOutput) {
+	*out = *in
+	out.To = in.To
+	if in.AdditionalTags != nil {
+		in, out := &in.AdditionalTags, &out.AdditionalTags
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.PushSecret != nil {
+		in, out := &in.PushSecret, &out.PushSecret
+		*out = new(corev1.LocalObjectReference)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BuildOutput.
+func (in *BuildOutput) DeepCopy() *BuildOutput {
+	if in == nil {
+		return nil
+	}
+	out := new(BuildOutput)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BuildRecord) DeepCopyInto(out *BuildRecord) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BuildRecord.
+func (in *BuildRecord) DeepCopy() *BuildRecord {
+	if in == nil {
+		return nil
+	}
+	out := new(BuildRecord)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *BuildSpec) DeepCopyInto(out *BuildSpec) {
 	*out = *in
+	if in.Output != nil {
+		in, out := &in.Output, &out.Output
+		*out = new(BuildOutput)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ImageStreamPolicy != nil {
+		in, out := &in.ImageStreamPolicy, &out.ImageStreamPolicy
+		*out = new(ImageStreamPolicy)
+		**out = **in
+	}
 	if in.Environment != nil {
 		in, out := &in.Environment, &out.Environment
 		*out = make([]SpackEnvionment, len(*in))
@@ -93,6 +221,56 @@ func (in *BuildSpec) DeepCopyInto(out *BuildSpec) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.Architectures != nil {
+		in, out := &in.Architectures, &out.Architectures
+		*out = make([]Architecture, len(*in))
+		copy(*out, *in)
+	}
+	if in.Targets != nil {
+		in, out := &in.Targets, &out.Targets
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Runtime != nil {
+		in, out := &in.Runtime, &out.Runtime
+		*out = new(RuntimeSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.BinaryMirror != nil {
+		in, out := &in.BinaryMirror, &out.BinaryMirror
+		*out = new(BinaryMirror)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.SourceMirror != nil {
+		in, out := &in.SourceMirror, &out.SourceMirror
+		*out = new(SourceMirror)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Templates != nil {
+		in, out := &in.Templates, &out.Templates
+		*out = new(corev1.LocalObjectReference)
+		**out = **in
+	}
+	if in.Kubernetes != nil {
+		in, out := &in.Kubernetes, &out.Kubernetes
+		*out = new(KubernetesBuildSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Install != nil {
+		in, out := &in.Install, &out.Install
+		*out = new(InstallSpec)
+		**out = **in
+	}
+	if in.SuccessfulBuildsHistoryLimit != nil {
+		in, out := &in.SuccessfulBuildsHistoryLimit, &out.SuccessfulBuildsHistoryLimit
+		*out = new(int32)
+		**out = **in
+	}
+	if in.FailedBuildsHistoryLimit != nil {
+		in, out := &in.FailedBuildsHistoryLimit, &out.FailedBuildsHistoryLimit
+		*out = new(int32)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BuildSpec.
@@ -109,6 +287,42 @@ func (in *BuildSpec) DeepCopy() *BuildSpec {
 func (in *BuildStatus) DeepCopyInto(out *BuildStatus) {
 	*out = *in
 	in.LastUpdate.DeepCopyInto(&out.LastUpdate)
+	if in.StartTimestamp != nil {
+		in, out := &in.StartTimestamp, &out.StartTimestamp
+		*out = (*in).DeepCopy()
+	}
+	if in.CompletionTimestamp != nil {
+		in, out := &in.CompletionTimestamp, &out.CompletionTimestamp
+		*out = (*in).DeepCopy()
+	}
+	if in.Environments != nil {
+		in, out := &in.Environments, &out.Environments
+		*out = make([]EnvironmentStatus, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.ManifestLists != nil {
+		in, out := &in.ManifestLists, &out.ManifestLists
+		*out = make([]ManifestListStatus, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Outputs != nil {
+		in, out := &in.Outputs, &out.Outputs
+		*out = make([]OutputStatus, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]v1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BuildStatus.
@@ -121,6 +335,229 @@ func (in *BuildStatus) DeepCopy() *BuildStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *EnvironmentStatus) DeepCopyInto(out *EnvironmentStatus) {
+	*out = *in
+	in.LastUpdate.DeepCopyInto(&out.LastUpdate)
+	if in.ExitCode != nil {
+		in, out := &in.ExitCode, &out.ExitCode
+		*out = new(int32)
+		**out = **in
+	}
+	if in.InstalledSpecs != nil {
+		in, out := &in.InstalledSpecs, &out.InstalledSpecs
+		*out = make([]InstalledSpec, len(*in))
+		copy(*out, *in)
+	}
+	if in.StartTimestamp != nil {
+		in, out := &in.StartTimestamp, &out.StartTimestamp
+		*out = (*in).DeepCopy()
+	}
+	if in.CompletionTimestamp != nil {
+		in, out := &in.CompletionTimestamp, &out.CompletionTimestamp
+		*out = (*in).DeepCopy()
+	}
+	if in.History != nil {
+		in, out := &in.History, &out.History
+		*out = make([]BuildRecord, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EnvironmentStatus.
+func (in *EnvironmentStatus) DeepCopy() *EnvironmentStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(EnvironmentStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ImageStreamPolicy) DeepCopyInto(out *ImageStreamPolicy) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ImageStreamPolicy.
+func (in *ImageStreamPolicy) DeepCopy() *ImageStreamPolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(ImageStreamPolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *InstallSpec) DeepCopyInto(out *InstallSpec) {
+	*out = *in
+	out.PersistentVolumeClaim = in.PersistentVolumeClaim
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new InstallSpec.
+func (in *InstallSpec) DeepCopy() *InstallSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(InstallSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *InstalledSpec) DeepCopyInto(out *InstalledSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new InstalledSpec.
+func (in *InstalledSpec) DeepCopy() *InstalledSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(InstalledSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KubernetesBuildSpec) DeepCopyInto(out *KubernetesBuildSpec) {
+	*out = *in
+	if in.PushSecret != nil {
+		in, out := &in.PushSecret, &out.PushSecret
+		*out = new(corev1.LocalObjectReference)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KubernetesBuildSpec.
+func (in *KubernetesBuildSpec) DeepCopy() *KubernetesBuildSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(KubernetesBuildSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ManifestListStatus) DeepCopyInto(out *ManifestListStatus) {
+	*out = *in
+	if in.Manifests != nil {
+		in, out := &in.Manifests, &out.Manifests
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	in.LastUpdate.DeepCopyInto(&out.LastUpdate)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ManifestListStatus.
+func (in *ManifestListStatus) DeepCopy() *ManifestListStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ManifestListStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OutputStatus) DeepCopyInto(out *OutputStatus) {
+	*out = *in
+	if in.Images != nil {
+		in, out := &in.Images, &out.Images
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	in.LastUpdate.DeepCopyInto(&out.LastUpdate)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OutputStatus.
+func (in *OutputStatus) DeepCopy() *OutputStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(OutputStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RuntimeSpec) DeepCopyInto(out *RuntimeSpec) {
+	*out = *in
+	if in.Packages != nil {
+		in, out := &in.Packages, &out.Packages
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RuntimeSpec.
+func (in *RuntimeSpec) DeepCopy() *RuntimeSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(RuntimeSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SourceMirror) DeepCopyInto(out *SourceMirror) {
+	*out = *in
+	if in.PersistentVolumeClaim != nil {
+		in, out := &in.PersistentVolumeClaim, &out.PersistentVolumeClaim
+		*out = new(corev1.LocalObjectReference)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SourceMirror.
+func (in *SourceMirror) DeepCopy() *SourceMirror {
+	if in == nil {
+		return nil
+	}
+	out := new(SourceMirror)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SpackCompiler) DeepCopyInto(out *SpackCompiler) {
+	*out = *in
+	out.Paths = in.Paths
+	if in.Modules != nil {
+		in, out := &in.Modules, &out.Modules
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SpackCompiler.
+func (in *SpackCompiler) DeepCopy() *SpackCompiler {
+	if in == nil {
+		return nil
+	}
+	out := new(SpackCompiler)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SpackCompilerPaths) DeepCopyInto(out *SpackCompilerPaths) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SpackCompilerPaths.
+func (in *SpackCompilerPaths) DeepCopy() *SpackCompilerPaths {
+	if in == nil {
+		return nil
+	}
+	out := new(SpackCompilerPaths)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *SpackEnvionment) DeepCopyInto(out *SpackEnvionment) {
 	*out = *in
@@ -134,6 +571,30 @@ func (in *SpackEnvionment) DeepCopyInto(out *SpackEnvionment) {
 		*out = new(string)
 		**out = **in
 	}
+	if in.Specs != nil {
+		in, out := &in.Specs, &out.Specs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Packages != nil {
+		in, out := &in.Packages, &out.Packages
+		*out = make(map[string]SpackPackage, len(*in))
+		for key, val := range *in {
+			(*out)[key] = *val.DeepCopy()
+		}
+	}
+	if in.Compilers != nil {
+		in, out := &in.Compilers, &out.Compilers
+		*out = make([]SpackCompiler, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Config != nil {
+		in, out := &in.Config, &out.Config
+		*out = new(runtime.RawExtension)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SpackEnvionment.
@@ -145,3 +606,180 @@ func (in *SpackEnvionment) DeepCopy() *SpackEnvionment {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SpackExternal) DeepCopyInto(out *SpackExternal) {
+	*out = *in
+	if in.Modules != nil {
+		in, out := &in.Modules, &out.Modules
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SpackExternal.
+func (in *SpackExternal) DeepCopy() *SpackExternal {
+	if in == nil {
+		return nil
+	}
+	out := new(SpackExternal)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SpackPackage) DeepCopyInto(out *SpackPackage) {
+	*out = *in
+	if in.Version != nil {
+		in, out := &in.Version, &out.Version
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Compiler != nil {
+		in, out := &in.Compiler, &out.Compiler
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Target != nil {
+		in, out := &in.Target, &out.Target
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Providers != nil {
+		in, out := &in.Providers, &out.Providers
+		*out = make(map[string][]string, len(*in))
+		for key, val := range *in {
+			var outVal []string
+			if val == nil {
+				(*out)[key] = nil
+			} else {
+				in, out := &val, &outVal
+				*out = make([]string, len(*in))
+				copy(*out, *in)
+			}
+			(*out)[key] = outVal
+		}
+	}
+	if in.Buildable != nil {
+		in, out := &in.Buildable, &out.Buildable
+		*out = new(bool)
+		**out = **in
+	}
+	if in.Externals != nil {
+		in, out := &in.Externals, &out.Externals
+		*out = make([]SpackExternal, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SpackPackage.
+func (in *SpackPackage) DeepCopy() *SpackPackage {
+	if in == nil {
+		return nil
+	}
+	out := new(SpackPackage)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SpackRelease) DeepCopyInto(out *SpackRelease) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SpackRelease.
+func (in *SpackRelease) DeepCopy() *SpackRelease {
+	if in == nil {
+		return nil
+	}
+	out := new(SpackRelease)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *SpackRelease) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SpackReleaseList) DeepCopyInto(out *SpackReleaseList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]SpackRelease, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SpackReleaseList.
+func (in *SpackReleaseList) DeepCopy() *SpackReleaseList {
+	if in == nil {
+		return nil
+	}
+	out := new(SpackReleaseList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *SpackReleaseList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SpackReleaseSpec) DeepCopyInto(out *SpackReleaseSpec) {
+	*out = *in
+	if in.OperatingSystems != nil {
+		in, out := &in.OperatingSystems, &out.OperatingSystems
+		*out = make([]OperatingSystem, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SpackReleaseSpec.
+func (in *SpackReleaseSpec) DeepCopy() *SpackReleaseSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(SpackReleaseSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SpackReleaseStatus) DeepCopyInto(out *SpackReleaseStatus) {
+	*out = *in
+	in.LastUpdate.DeepCopyInto(&out.LastUpdate)
+	if in.Images != nil {
+		in, out := &in.Images, &out.Images
+		*out = make([]BaseImageStatus, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SpackReleaseStatus.
+func (in *SpackReleaseStatus) DeepCopy() *SpackReleaseStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(SpackReleaseStatus)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -0,0 +1,118 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// The node-labeler runs on every node, detects the archspec
+// microarchitecture of its CPU and labels the Node with it, and with the
+// microarchitectures it can run binaries built for, so that microarch
+// targeted builds get scheduled onto compatible nodes.
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"runtime"
+	s "strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+
+	"github.com/ArangoGutierrez/spack-operator/pkg/archspec"
+)
+
+var log = ctrl.Log.WithName("node-labeler")
+
+func main() {
+	var cpuinfo string
+	var interval time.Duration
+	flag.StringVar(&cpuinfo, "cpuinfo", "/proc/cpuinfo", "File describing the CPU of the node.")
+	flag.DurationVar(&interval, "interval", time.Hour, "Interval between two labelings of the node.")
+	opts := zap.Options{}
+	opts.BindFlags(flag.CommandLine)
+	flag.Parse()
+
+	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
+
+	nodeName := os.Getenv("NODE_NAME")
+	if nodeName == "" {
+		log.Info("NODE_NAME must be set to the name of the node")
+		os.Exit(1)
+	}
+
+	c, err := client.New(ctrl.GetConfigOrDie(), client.Options{})
+	if err != nil {
+		log.Error(err, "unable to create the client")
+		os.Exit(1)
+	}
+
+	ctx := ctrl.SetupSignalHandler()
+	for {
+		// labels are applied again on every interval in case they were removed
+		if err := labelNode(ctx, c, nodeName, cpuinfo); err != nil {
+			log.Error(err, "Failed to label the node", "node", nodeName)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// labelNode sets the archspec labels of the node from the CPU described in
+// the cpuinfo file, replacing the ones of a previous detection
+func labelNode(ctx context.Context, c client.Client, nodeName, cpuinfo string) error {
+	f, err := os.Open(cpuinfo)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	host, err := archspec.ParseCPUInfo(runtime.GOARCH, f)
+	if err != nil {
+		return err
+	}
+	labels := map[string]string{archspec.TargetLabel: host.Target()}
+	for _, name := range host.Compatible() {
+		labels[archspec.CompatibleLabel(name)] = "true"
+	}
+
+	node := &corev1.Node{}
+	if err := c.Get(ctx, types.NamespacedName{Name: nodeName}, node); err != nil {
+		return err
+	}
+	patch := client.MergeFrom(node.DeepCopy())
+	if node.Labels == nil {
+		node.Labels = map[string]string{}
+	}
+	for key := range node.Labels {
+		if s.HasPrefix(key, archspec.CompatibleLabelPrefix) && labels[key] == "" {
+			delete(node.Labels, key)
+		}
+	}
+	for key, value := range labels {
+		node.Labels[key] = value
+	}
+	if err := c.Patch(ctx, node, patch); err != nil {
+		return err
+	}
+	log.Info("Node labeled", "node", nodeName, "target", host.Target(), "compatible", len(labels)-1)
+	return nil
+}
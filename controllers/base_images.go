@@ -0,0 +1,149 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"fmt"
+	s "strings"
+	"text/template"
+
+	packagev1alpha1 "github.com/ArangoGutierrez/spack-operator/api/v1alpha1"
+)
+
+// osProfile describes how the Spack base image of a distribution is
+// bootstrapped
+type osProfile struct {
+	// Image the base image is built on
+	Image string
+	// Update refreshes the package metadata and upgrades the image
+	Update string
+	// Install installs the packages given as arguments
+	Install string
+	// Clean removes the package manager caches
+	Clean string
+	// Packages are the tools Spack needs to build packages
+	Packages []string
+}
+
+// rpmPackages are the packages of the Spack prerequisites on the
+// distributions shipping RPMs
+var rpmPackages = s.Fields(`binutils bzip2 curl file findutils gcc gcc-c++ gcc-gfortran
+	git gnupg2 gzip hostname iproute make patch python3 python3-pip tar unzip util-linux which xz`)
+
+// osProfiles indexes the bootstrap profiles by distribution
+var osProfiles = map[packagev1alpha1.OperatingSystem]osProfile{
+	packagev1alpha1.OSFedora: {
+		Image:    "registry.fedoraproject.org/fedora:latest",
+		Update:   "dnf update -y",
+		Install:  "dnf install -y",
+		Clean:    "dnf clean all && rm -rf /var/cache/dnf",
+		Packages: append(s.Fields("Lmod tcl"), rpmPackages...),
+	},
+	packagev1alpha1.OSUBI8: {
+		Image:    "registry.access.redhat.com/ubi8/ubi:latest",
+		Update:   "dnf update -y",
+		Install:  "dnf install -y",
+		Clean:    "dnf clean all && rm -rf /var/cache/dnf",
+		Packages: rpmPackages,
+	},
+	packagev1alpha1.OSRockyLinux8: {
+		Image:    "docker.io/rockylinux/rockylinux:8",
+		Update:   "dnf update -y",
+		Install:  "dnf install -y",
+		Clean:    "dnf clean all && rm -rf /var/cache/dnf",
+		Packages: rpmPackages,
+	},
+	packagev1alpha1.OSUbuntu2004: {
+		Image:   "docker.io/library/ubuntu:20.04",
+		Update:  "apt-get update -y",
+		Install: "apt-get install -y --no-install-recommends",
+		Clean:   "rm -rf /var/lib/apt/lists/*",
+		Packages: s.Fields(`binutils build-essential bzip2 ca-certificates curl file g++ gcc
+			gfortran git gnupg2 iproute2 lmod make patch python3 python3-pip tar unzip util-linux xz-utils`),
+	},
+	packagev1alpha1.OSAmazonLinux2: {
+		Image:    "public.ecr.aws/amazonlinux/amazonlinux:2",
+		Update:   "yum update -y",
+		Install:  "yum install -y",
+		Clean:    "yum clean all && rm -rf /var/cache/yum",
+		Packages: rpmPackages,
+	},
+}
+
+// lookupOSProfile returns the bootstrap profile of a distribution
+func lookupOSProfile(os packagev1alpha1.OperatingSystem) (osProfile, error) {
+	profile, ok := osProfiles[os]
+	if !ok {
+		return osProfile{}, fmt.Errorf("unsupported operating system %q", os)
+	}
+	return profile, nil
+}
+
+// baseImageTemplate is the Dockerfile of the Spack base images, it copies
+// the Spack sources checked out by the BuildConfig onto an image of the
+// distribution holding the tools Spack needs to build packages
+var baseImageTemplate = template.Must(template.New("base").Parse(`
+FROM {{ .Image }}
+
+ENV SPACK_ROOT=/opt/spack             \
+    DEBIAN_FRONTEND=noninteractive    \
+    CURRENTLY_BUILDING_DOCKER_IMAGE=1 \
+    container=docker
+
+RUN {{ .Update }} \
+ && {{ .Install }} \
+{{- range .Packages }}
+        {{ . }} \
+{{- end }}
+ && python3 -m pip install boto3 \
+ && {{ .Clean }}
+
+COPY bin   $SPACK_ROOT/bin
+COPY etc   $SPACK_ROOT/etc
+COPY lib   $SPACK_ROOT/lib
+COPY share $SPACK_ROOT/share
+COPY var   $SPACK_ROOT/var
+RUN mkdir -p $SPACK_ROOT/opt/spack
+
+RUN ln -s $SPACK_ROOT/share/spack/docker/entrypoint.bash \
+          /usr/local/bin/docker-shell \
+ && ln -s $SPACK_ROOT/share/spack/docker/entrypoint.bash \
+          /usr/local/bin/interactive-shell \
+ && ln -s $SPACK_ROOT/share/spack/docker/entrypoint.bash \
+          /usr/local/bin/spack-env
+
+RUN mkdir -p /root/.spack \
+ && cp $SPACK_ROOT/share/spack/docker/modules.yaml \
+        /root/.spack/modules.yaml \
+ && rm -rf /root/*.* /run/nologin $SPACK_ROOT/.git
+
+ENV PATH "$PATH:$SPACK_ROOT/bin"
+RUN mkdir /opt/spack-environment
+WORKDIR /opt/spack-environment
+`))
+
+// baseImageRecipe returns the Dockerfile of the Spack base image of a
+// distribution
+func baseImageRecipe(profile osProfile) string {
+	var buf bytes.Buffer
+	if err := baseImageTemplate.Execute(&buf, profile); err != nil {
+		// the template only references fields of osProfile
+		panic(err)
+	}
+	return buf.String()
+}
@@ -0,0 +1,112 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+
+	packagev1alpha1 "github.com/ArangoGutierrez/spack-operator/api/v1alpha1"
+)
+
+// buildBackend runs the builds producing the images of the environments of
+// a Build. The backends share the configMaps of the environments and the
+// build logic, each one owns the resources running the builds.
+type buildBackend interface {
+	// repository returns the registry repository the images of an image
+	// stream of a namespace are pushed to by the builds of a Build
+	repository(ctx context.Context, spkg *packagev1alpha1.Build, namespace, stream string) (string, error)
+	// builderImage returns the image the builder stage of the Dockerfiles
+	// is based on, for the base image base
+	builderImage(ctx context.Context, spkg *packagev1alpha1.Build, base *corev1.ObjectReference) (string, error)
+	// pullSpec returns the registry reference Pods pull the base image
+	// base with
+	pullSpec(ctx context.Context, spkg *packagev1alpha1.Build, base *corev1.ObjectReference) (string, error)
+	// setBaseImageCondition checks whether the base image can be pulled by
+	// the builds
+	setBaseImageCondition(ctx context.Context, spkg *packagev1alpha1.Build, base *corev1.ObjectReference) error
+	// sync converges the resources building the image of an environment
+	// for a platform, recording them in keep
+	sync(ctx context.Context, spkg *packagev1alpha1.Build, eb *envBuild, keep map[string]bool) error
+	// validate returns why the resources building an environment cannot
+	// be used, empty when they can
+	validate(ctx context.Context, spkg *packagev1alpha1.Build, env *packagev1alpha1.EnvironmentStatus) (string, error)
+	// update refreshes the status of an environment from its latest build,
+	// starting a new build when the resources building it have changed
+	update(ctx context.Context, spkg *packagev1alpha1.Build, env *packagev1alpha1.EnvironmentStatus) error
+	// prune deletes the resources of a Build owned by the backend that are
+	// not listed in keep, keyed by "<Kind>/<name>"
+	prune(ctx context.Context, spkg *packagev1alpha1.Build, keep map[string]bool) error
+	// deleteImages removes the images pushed for a Build being deleted
+	deleteImages(ctx context.Context, spkg *packagev1alpha1.Build) error
+}
+
+// envBuild describes the build of the image of an environment for a
+// platform
+type envBuild struct {
+	// Name of the resources running the builds
+	Name     string
+	Env      packagev1alpha1.SpackEnvionment
+	Platform buildPlatform
+	// Base is the base image the environment is built on
+	Base *corev1.ObjectReference
+	// Dockerfile is the recipe of the image
+	Dockerfile string
+	// ConfigMap holds the spack.yaml of the environment
+	ConfigMap string
+	// BuildLogic is the configMap holding the build script
+	BuildLogic string
+	// Output is where the image is pushed, with the tag Tag
+	Output *imageOutput
+	Tag    string
+}
+
+// backend returns the backend running the builds of spkg, the install
+// backend when it installs its environments onto a claim
+func (r *BuildReconciler) backend(spkg *packagev1alpha1.Build) (buildBackend, error) {
+	var backend buildBackend
+	switch spkg.Spec.Backend {
+	case packagev1alpha1.BackendOpenShift, "":
+		if !r.openShift {
+			return nil, fmt.Errorf("the OpenShift build API is not available, select the %s backend", packagev1alpha1.BackendKubernetes)
+		}
+		backend = &openShiftBackend{r: r}
+	case packagev1alpha1.BackendKubernetes:
+		backend = &kubernetesBackend{r: r}
+	default:
+		return nil, fmt.Errorf("unknown build backend %s", spkg.Spec.Backend)
+	}
+	if spkg.Spec.Install != nil {
+		return &installBackend{r: r, images: backend}, nil
+	}
+	return backend, nil
+}
+
+// backends returns every backend available on the cluster, the resources
+// of a Build are pruned from all of them when it switches backends
+func (r *BuildReconciler) backends() []buildBackend {
+	backends := []buildBackend{
+		&kubernetesBackend{r: r},
+		&installBackend{r: r, images: &kubernetesBackend{r: r}},
+	}
+	if r.openShift {
+		backends = append(backends, &openShiftBackend{r: r})
+	}
+	return backends
+}
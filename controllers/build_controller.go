@@ -18,23 +18,33 @@ package controllers
 
 import (
 	"context"
-	"time"
 
 	"github.com/go-logr/logr"
 	buildv1 "github.com/openshift/api/build/v1"
 	imagev1 "github.com/openshift/api/image/v1"
+	batchv1 "k8s.io/api/batch/v1"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/rest"
 
 	"k8s.io/klog"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	packagev1alpha1 "github.com/ArangoGutierrez/spack-operator/api/v1alpha1"
+	"github.com/ArangoGutierrez/spack-operator/pkg/registry"
 )
 
 // BuildReconciler reconciles a Build object
@@ -43,13 +53,35 @@ type BuildReconciler struct {
 	Log       logr.Logger
 	Scheme    *runtime.Scheme
 	AssetsDir string
+	// BuildClient is a REST client for the build.openshift.io API, used for
+	// the subresources the controller-runtime client cannot reach
+	BuildClient rest.Interface
+	// Registry pushes the multi-architecture manifest lists, they are not
+	// assembled when nil
+	Registry *registry.Client
+	// ImageRegistry is the registry the Kubernetes backend pushes the
+	// images to, under a repository per namespace and image stream
+	ImageRegistry string
+	// BuildahImage runs the buildah build Pods of the Kubernetes backend
+	BuildahImage string
+	// KanikoImage runs the kaniko build Pods of the Kubernetes backend
+	KanikoImage string
+	// APIReader reads the objects that must not be read from the cache, the
+	// locks of the install claims among others, the Client when nil
+	APIReader client.Reader
+
+	// openShift is true when the build.openshift.io API is served, the
+	// OpenShift backend is only available then
+	openShift bool
 }
 
 // +kubebuilder:rbac:groups=multiarch.builder.io,resources=builds,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=multiarch.builder.io,resources=builds/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups=multiarch.builder.io,resources=builds/finalizers,verbs=update
+// +kubebuilder:rbac:groups=multiarch.builder.io,resources=spackreleases,verbs=get;list;watch
 // +kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=core,resources=pods/log,verbs=get
+// +kubebuilder:rbac:groups=core,resources=podtemplates,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=apps,resources=daemonsets,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=core,resources=namespaces,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=core,resources=nodes,verbs=get;list;watch;create;update;patch;delete
@@ -63,9 +95,11 @@ type BuildReconciler struct {
 // +kubebuilder:rbac:groups=security.openshift.io,resources=securitycontextconstraints,verbs=use;get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=image.openshift.io,resources=imagestreams,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=image.openshift.io,resources=imagestreams/finalizers,verbs=get;list;watch;create;update;patch;delete
-// +kubebuilder:rbac:groups=image.openshift.io,resources=imagestreams/layers,verbs=get
+// +kubebuilder:rbac:groups=image.openshift.io,resources=imagestreams/layers,verbs=get;update
+// +kubebuilder:rbac:groups=image.openshift.io,resources=imagestreamtags,verbs=get;delete
 // +kubebuilder:rbac:groups=core,resources=imagestreams/layers,verbs=get
 // +kubebuilder:rbac:groups=build.openshift.io,resources=buildconfigs,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=build.openshift.io,resources=buildconfigs/instantiate,verbs=create
 // +kubebuilder:rbac:groups=build.openshift.io,resources=builds,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=rbac.authorization.k8s.io,resources=clusterroles,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=rbac.authorization.k8s.io,resources=clusterrolebindings,verbs=get;list;watch;create;update;patch;delete
@@ -82,6 +116,7 @@ type BuildReconciler struct {
 // +kubebuilder:rbac:groups=monitoring.coreos.com,resources=prometheusrules,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=route.openshift.io,resources=routes,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;update;patch;delete
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -95,32 +130,84 @@ func (r *BuildReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl
 	if err != nil {
 		// handle deletion of resource
 		if errors.IsNotFound(err) {
-			// User deleted the cluster resource, so we need to delete the associated resources
-			r.Log.Info("resource has been deleted", "req", req.Name, "got", spkg.Name)
-			return r.deleteBuild(ctx, spkg)
+			// the associated resources have been removed by the finalizer
+			// and the garbage collector, there is nothing left to do
+			r.Log.Info("resource has been deleted", "req", req.Name)
+			return ctrl.Result{}, nil
 		}
 
 		r.Log.Error(err, "requeueing event since there was an error reading object")
 		return ctrl.Result{Requeue: true}, err
 	}
 
+	// Builds stored while the defaulting webhook was not deployed miss the
+	// optional fields, the controller works on their defaulted spec while
+	// the finalizer is patched on the stored one, the validating webhook
+	// may reject the defaulted spec of these Builds
+	stored := spkg.DeepCopy()
+	spkg.Default()
+
+	// User deleted the cluster resource, so we need to delete the associated resources
+	if !spkg.DeletionTimestamp.IsZero() {
+		if !controllerutil.ContainsFinalizer(spkg, buildFinalizer) {
+			return ctrl.Result{}, nil
+		}
+		return r.deleteBuild(ctx, stored, spkg)
+	}
+
+	if !controllerutil.ContainsFinalizer(spkg, buildFinalizer) {
+		if err := r.patchFinalizer(ctx, stored, true); err != nil {
+			r.Log.Error(err, "Failed to add the finalizer")
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{Requeue: true}, nil
+	}
+
+	// converge the resources of every environment on each pass
+	if err := r.syncResources(ctx, spkg); err != nil {
+		return ctrl.Result{}, err
+	}
+
 	r.Log.Info("reconciling at status: " + string(spkg.InstallStatus()))
 	switch spkg.InstallStatus() {
 	case packagev1alpha1.EmptyStatus:
 		return r.createBuild(ctx, spkg)
 	case packagev1alpha1.InitializedStatus:
 		return r.validateBuild(ctx, spkg)
-	case packagev1alpha1.ValidatedPackage:
-		r.Log.Info("Spack Package Validated", "package", spkg.Name)
-		return ctrl.Result{RequeueAfter: 5 * time.Second}, nil
 	}
 
-	return ctrl.Result{RequeueAfter: 5 * time.Second}, nil
+	// track the builds spawned for the validated package
+	return r.updateStatus(ctx, spkg)
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *BuildReconciler) SetupWithManager(mgr ctrl.Manager) error {
 
+	if r.BuildahImage == "" {
+		r.BuildahImage = DefaultBuildahImage
+	}
+	if r.KanikoImage == "" {
+		r.KanikoImage = DefaultKanikoImage
+	}
+
+	// the OpenShift backend is only available on clusters serving BuildConfigs
+	gvk := buildv1.GroupVersion.WithKind("BuildConfig")
+	if _, err := mgr.GetRESTMapper().RESTMapping(gvk.GroupKind(), gvk.Version); err == nil {
+		r.openShift = true
+	} else if !meta.IsNoMatchError(err) {
+		return err
+	} else {
+		r.Log.Info("the OpenShift build API is not available, only the Kubernetes backend can be used")
+	}
+
+	if r.openShift && r.BuildClient == nil {
+		c, err := apiutil.RESTClientForGVK(gvk, false, mgr.GetConfig(), serializer.NewCodecFactory(mgr.GetScheme()))
+		if err != nil {
+			return err
+		}
+		r.BuildClient = c
+	}
+
 	// we want to initate reconcile loop only on change under labels or spec of the object
 	// we want to initate reconcile loop only on spec change of the object
 	p := predicate.Funcs{
@@ -132,12 +219,94 @@ func (r *BuildReconciler) SetupWithManager(mgr ctrl.Manager) error {
 		},
 	}
 
-	return ctrl.NewControllerManagedBy(mgr).
+	b := ctrl.NewControllerManagedBy(mgr).
 		For(&packagev1alpha1.Build{}).
 		Owns(&v1.Pod{}).
-		Owns(&buildv1.BuildConfig{}, builder.WithPredicates(p)).
-		Owns(&imagev1.ImageStream{}, builder.WithPredicates(p)).
-		Complete(r)
+		Owns(&v1.PodTemplate{}).
+		Owns(&batchv1.Job{}).
+		Owns(&v1.ConfigMap{}).
+		Watches(&source.Kind{Type: &packagev1alpha1.SpackRelease{}}, handler.EnqueueRequestsFromMapFunc(r.spackReleaseBuilds))
+	if r.openShift {
+		b = b.Owns(&buildv1.BuildConfig{}, builder.WithPredicates(p, predicate.GenerationChangedPredicate{})).
+			Watches(&source.Kind{Type: &imagev1.ImageStream{}}, handler.EnqueueRequestsFromMapFunc(r.imageStreamBuilds),
+				builder.WithPredicates(p, predicate.GenerationChangedPredicate{})).
+			Watches(&source.Kind{Type: &buildv1.Build{}}, handler.EnqueueRequestsFromMapFunc(buildRequests))
+	}
+	return b.Complete(r)
+}
+
+// spackReleaseBuilds maps a SpackRelease to the Builds whose environments
+// are built on its base image
+func (r *BuildReconciler) spackReleaseBuilds(obj client.Object) []reconcile.Request {
+	release, ok := obj.(*packagev1alpha1.SpackRelease)
+	if !ok {
+		return nil
+	}
+	builds := &packagev1alpha1.BuildList{}
+	if err := r.Client.List(context.Background(), builds); err != nil {
+		r.Log.Error(err, "Failed to list the Builds of a SpackRelease", "release", release.Name)
+		return nil
+	}
+
+	requests := []reconcile.Request{}
+	for i := range builds.Items {
+		b := &builds.Items[i]
+		b.Default()
+		if b.Spec.BaseImage == "" && b.Spec.SpackVersion == release.Spec.Version {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: types.NamespacedName{Namespace: b.Namespace, Name: b.Name},
+			})
+		}
+	}
+	return requests
+}
+
+// imageStreamBuilds maps an ImageStream to the Builds of the OpenShift
+// backend pushing their images to it, which recreate it once deleted. The
+// ImageStreams are not owned by the Builds, several may share one.
+func (r *BuildReconciler) imageStreamBuilds(obj client.Object) []reconcile.Request {
+	builds := &packagev1alpha1.BuildList{}
+	if err := r.Client.List(context.Background(), builds, client.InNamespace(obj.GetNamespace())); err != nil {
+		r.Log.Error(err, "Failed to list the Builds of an ImageStream", "imageStream", obj.GetName())
+		return nil
+	}
+
+	requests := []reconcile.Request{}
+	for i := range builds.Items {
+		b := &builds.Items[i]
+		b.Default()
+		if b.Spec.Install != nil || b.Spec.Backend != packagev1alpha1.BackendOpenShift {
+			continue
+		}
+		o := buildOutput(b)
+		if o.Kind == packagev1alpha1.OutputImageStreamTag && o.Namespace == obj.GetNamespace() && o.Name == obj.GetName() {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: types.NamespacedName{Namespace: b.Namespace, Name: b.Name},
+			})
+		}
+	}
+	return requests
+}
+
+// apiReader returns the reader of the objects that must not be read from
+// the cache
+func (r *BuildReconciler) apiReader() client.Reader {
+	if r.APIReader == nil {
+		return r.Client
+	}
+	return r.APIReader
+}
+
+// buildRequests maps an OpenShift Build to the Build CR its BuildConfig
+// was created for, using the label inherited from the BuildConfig
+func buildRequests(obj client.Object) []reconcile.Request {
+	name, ok := obj.GetLabels()[buildLabel]
+	if !ok {
+		return nil
+	}
+	return []reconcile.Request{{
+		NamespacedName: types.NamespacedName{Namespace: obj.GetNamespace(), Name: name},
+	}}
 }
 
 func validateUpdateEvent(e *event.UpdateEvent) bool {
@@ -0,0 +1,548 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	s "strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	buildv1 "github.com/openshift/api/build/v1"
+	imagev1 "github.com/openshift/api/image/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	restfake "k8s.io/client-go/rest/fake"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	packagev1alpha1 "github.com/ArangoGutierrez/spack-operator/api/v1alpha1"
+)
+
+const testSpackYAML = `spack:
+  specs:
+  - zlib
+  view: true
+`
+
+func strPtr(v string) *string { return &v }
+
+// testBuild returns a Build of a single environment pushed to stack:v1
+func testBuild() *packagev1alpha1.Build {
+	return &packagev1alpha1.Build{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "builds", Name: "stack"},
+		Spec: packagev1alpha1.BuildSpec{
+			ImageStream: "stack:v1",
+			Environment: []packagev1alpha1.SpackEnvionment{{Name: strPtr("mpi"), Data: strPtr(testSpackYAML)}},
+		},
+	}
+}
+
+// newTestReconciler returns a reconciler of an OpenShift cluster holding objs
+func newTestReconciler(t *testing.T, objs ...client.Object) *BuildReconciler {
+	scheme := runtime.NewScheme()
+	for _, add := range []func(*runtime.Scheme) error{
+		clientgoscheme.AddToScheme, packagev1alpha1.AddToScheme, buildv1.AddToScheme, imagev1.AddToScheme,
+	} {
+		if err := add(scheme); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).Build()
+	return &BuildReconciler{
+		Client:    c,
+		Log:       logr.Discard(),
+		Scheme:    scheme,
+		AssetsDir: "../build/assets",
+		openShift: true,
+	}
+}
+
+func TestReconcileFinalizerKeepsStoredSpec(t *testing.T) {
+	// a Build stored before the defaulting webhook was deployed
+	spkg := testBuild()
+	r := newTestReconciler(t, spkg)
+	ctx := context.Background()
+	key := types.NamespacedName{Namespace: spkg.Namespace, Name: spkg.Name}
+
+	if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key}); err != nil {
+		t.Fatal(err)
+	}
+	got := &packagev1alpha1.Build{}
+	if err := r.Get(ctx, key, got); err != nil {
+		t.Fatal(err)
+	}
+	if !controllerutil.ContainsFinalizer(got, buildFinalizer) {
+		t.Fatalf("finalizer not added: %v", got.Finalizers)
+	}
+	if !equality.Semantic.DeepEqual(got.Spec, spkg.Spec) {
+		t.Errorf("adding the finalizer changed the spec to %+v", got.Spec)
+	}
+
+	now := metav1.Now()
+	got.DeletionTimestamp = &now
+	if err := r.Update(ctx, got); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key}); err != nil {
+		t.Fatal(err)
+	}
+	deleted := &packagev1alpha1.Build{}
+	if err := r.Get(ctx, key, deleted); err != nil {
+		t.Fatal(err)
+	}
+	if controllerutil.ContainsFinalizer(deleted, buildFinalizer) {
+		t.Errorf("finalizer not removed: %v", deleted.Finalizers)
+	}
+	if !equality.Semantic.DeepEqual(deleted.Spec, spkg.Spec) {
+		t.Errorf("removing the finalizer changed the spec to %+v", deleted.Spec)
+	}
+}
+
+func TestBackend(t *testing.T) {
+	tests := []struct {
+		name      string
+		openShift bool
+		backend   packagev1alpha1.BuildBackend
+		install   bool
+		want      string
+	}{
+		{"default on OpenShift", true, "", false, "*controllers.openShiftBackend"},
+		{"OpenShift", true, packagev1alpha1.BackendOpenShift, false, "*controllers.openShiftBackend"},
+		{"Kubernetes on OpenShift", true, packagev1alpha1.BackendKubernetes, false, "*controllers.kubernetesBackend"},
+		{"Kubernetes", false, packagev1alpha1.BackendKubernetes, false, "*controllers.kubernetesBackend"},
+		{"OpenShift off OpenShift", false, packagev1alpha1.BackendOpenShift, false, ""},
+		{"unknown", true, packagev1alpha1.BuildBackend("Tekton"), false, ""},
+		{"install", false, packagev1alpha1.BackendKubernetes, true, "*controllers.installBackend"},
+	}
+	for _, tt := range tests {
+		r := newTestReconciler(t)
+		r.openShift = tt.openShift
+		spkg := testBuild()
+		spkg.Spec.Backend = tt.backend
+		if tt.install {
+			spkg.Spec.Install = &packagev1alpha1.InstallSpec{}
+		}
+		backend, err := r.backend(spkg)
+		got := ""
+		if err == nil {
+			got = fmt.Sprintf("%T", backend)
+		}
+		if got != tt.want {
+			t.Errorf("%s: got backend %q (%v), want %q", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestReconcileRecordsUnavailableBackend(t *testing.T) {
+	spkg := testBuild()
+	spkg.Spec.Backend = packagev1alpha1.BackendOpenShift
+	spkg.Finalizers = []string{buildFinalizer}
+	r := newTestReconciler(t, spkg)
+	r.openShift = false
+	ctx := context.Background()
+	key := types.NamespacedName{Namespace: spkg.Namespace, Name: spkg.Name}
+
+	if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key}); err == nil {
+		t.Fatal("the OpenShift backend was selected off OpenShift")
+	}
+	got := &packagev1alpha1.Build{}
+	if err := r.Get(ctx, key, got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Status.State != packagev1alpha1.ErroredPackage || got.Status.Reason == "" {
+		t.Errorf("got state %s %q, want %s with a reason", got.Status.State, got.Status.Reason, packagev1alpha1.ErroredPackage)
+	}
+	if c := meta.FindStatusCondition(got.Status.Conditions, packagev1alpha1.ConditionReady); c == nil || c.Reason != "BackendNotAvailable" {
+		t.Errorf("got Ready condition %+v", c)
+	}
+}
+
+func TestReconcileRejectsOutputOfAnotherNamespace(t *testing.T) {
+	spkg := testBuild()
+	spkg.Spec.ImageStream = ""
+	spkg.Spec.Output = &packagev1alpha1.BuildOutput{
+		To: corev1.ObjectReference{Kind: packagev1alpha1.OutputImageStreamTag, Namespace: "shared", Name: "stack:v1"},
+	}
+	spkg.Finalizers = []string{buildFinalizer}
+	r := newTestReconciler(t, spkg)
+	ctx := context.Background()
+	key := types.NamespacedName{Namespace: spkg.Namespace, Name: spkg.Name}
+
+	if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key}); err == nil {
+		t.Fatal("the output of another namespace was accepted")
+	}
+	got := &packagev1alpha1.Build{}
+	if err := r.Get(ctx, key, got); err != nil {
+		t.Fatal(err)
+	}
+	if c := meta.FindStatusCondition(got.Status.Conditions, packagev1alpha1.ConditionReady); got.Status.State != packagev1alpha1.ErroredPackage ||
+		c == nil || c.Reason != "OutputForbidden" {
+		t.Errorf("got state %s and Ready condition %+v", got.Status.State, c)
+	}
+	streams := &imagev1.ImageStreamList{}
+	if err := r.List(ctx, streams, client.InNamespace("shared")); err != nil {
+		t.Fatal(err)
+	}
+	if len(streams.Items) > 0 {
+		t.Errorf("ImageStreams created in another namespace: %+v", streams.Items)
+	}
+}
+
+// reconcileBuild reconciles the Build of key n times, failing on any error
+func reconcileBuild(t *testing.T, r *BuildReconciler, key types.NamespacedName, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		if _, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: key}); err != nil {
+			t.Fatalf("pass %d: %v", i+1, err)
+		}
+	}
+}
+
+func TestReconcileFansOutEnvironments(t *testing.T) {
+	spkg := testBuild()
+	spkg.Spec.Environment = append(spkg.Spec.Environment,
+		packagev1alpha1.SpackEnvionment{Name: strPtr("python"), Specs: []string{"python", "py-numpy"}})
+	r := newTestReconciler(t, spkg)
+	ctx := context.Background()
+	key := types.NamespacedName{Namespace: spkg.Namespace, Name: spkg.Name}
+
+	// add the finalizer, create the resources, then validate them
+	reconcileBuild(t, r, key, 3)
+
+	got := &packagev1alpha1.Build{}
+	if err := r.Get(ctx, key, got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Status.State != packagev1alpha1.ValidatedPackage {
+		t.Errorf("got state %s, want %s", got.Status.State, packagev1alpha1.ValidatedPackage)
+	}
+	if len(got.Status.Environments) != 2 {
+		t.Fatalf("got %d environments in the status, want 2: %+v", len(got.Status.Environments), got.Status.Environments)
+	}
+	images := map[string]bool{}
+	for _, env := range got.Status.Environments {
+		cm := &corev1.ConfigMap{}
+		if err := r.Get(ctx, types.NamespacedName{Namespace: spkg.Namespace, Name: env.ConfigMap}, cm); err != nil {
+			t.Errorf("environment %s: ConfigMap %s: %v", env.Name, env.ConfigMap, err)
+		}
+		bc := &buildv1.BuildConfig{}
+		if err := r.Get(ctx, types.NamespacedName{Namespace: spkg.Namespace, Name: env.BuildConfig}, bc); err != nil {
+			t.Errorf("environment %s: BuildConfig %s: %v", env.Name, env.BuildConfig, err)
+		} else if to := bc.Spec.Output.To; to == nil || to.Name != env.Image {
+			t.Errorf("environment %s: BuildConfig pushes to %+v, want %s", env.Name, to, env.Image)
+		}
+		if env.State != packagev1alpha1.ValidatedPackage {
+			t.Errorf("environment %s: got state %s", env.Name, env.State)
+		}
+		images[env.Image] = true
+	}
+	if len(images) != 2 {
+		t.Errorf("the environments share their output tag: %v", images)
+	}
+}
+
+func TestDeleteBuildRemovesResources(t *testing.T) {
+	spkg := testBuild()
+	pushed := &imagev1.ImageStreamTag{ObjectMeta: metav1.ObjectMeta{Namespace: "builds", Name: "stack:v1-mpi"}}
+	other := &imagev1.ImageStreamTag{ObjectMeta: metav1.ObjectMeta{Namespace: "builds", Name: "stack:release"}}
+	r := newTestReconciler(t, spkg, pushed, other)
+	ctx := context.Background()
+	key := types.NamespacedName{Namespace: spkg.Namespace, Name: spkg.Name}
+	reconcileBuild(t, r, key, 2)
+
+	got := &packagev1alpha1.Build{}
+	if err := r.Get(ctx, key, got); err != nil {
+		t.Fatal(err)
+	}
+	owned := client.MatchingLabels{buildLabel: spkg.Name}
+	cms := &corev1.ConfigMapList{}
+	bcs := &buildv1.BuildConfigList{}
+	for _, list := range []client.ObjectList{cms, bcs} {
+		if err := r.List(ctx, list, client.InNamespace(spkg.Namespace), owned); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(cms.Items) == 0 || len(bcs.Items) == 0 {
+		t.Fatalf("got %d ConfigMaps and %d BuildConfigs, want some", len(cms.Items), len(bcs.Items))
+	}
+	objs := []metav1.Object{}
+	for i := range cms.Items {
+		objs = append(objs, &cms.Items[i])
+	}
+	for i := range bcs.Items {
+		objs = append(objs, &bcs.Items[i])
+	}
+	for _, obj := range objs {
+		if ref := metav1.GetControllerOf(obj); ref == nil || ref.UID != got.UID || ref.Kind != "Build" {
+			t.Errorf("%s is not controlled by the Build: %+v", obj.GetName(), ref)
+		}
+	}
+
+	now := metav1.Now()
+	got.DeletionTimestamp = &now
+	if err := r.Update(ctx, got); err != nil {
+		t.Fatal(err)
+	}
+	reconcileBuild(t, r, key, 1)
+
+	for _, list := range []client.ObjectList{cms, bcs} {
+		if err := r.List(ctx, list, client.InNamespace(spkg.Namespace), owned); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(cms.Items) > 0 || len(bcs.Items) > 0 {
+		t.Errorf("%d ConfigMaps and %d BuildConfigs left after the deletion", len(cms.Items), len(bcs.Items))
+	}
+	if err := r.Get(ctx, types.NamespacedName{Namespace: "builds", Name: pushed.Name}, &imagev1.ImageStreamTag{}); err == nil {
+		t.Errorf("the ImageStreamTag %s pushed by the Build is left", pushed.Name)
+	}
+	if err := r.Get(ctx, types.NamespacedName{Namespace: "builds", Name: other.Name}, &imagev1.ImageStreamTag{}); err != nil {
+		t.Errorf("the ImageStreamTag %s not pushed by the Build was deleted: %v", other.Name, err)
+	}
+	deleted := &packagev1alpha1.Build{}
+	if err := r.Get(ctx, key, deleted); err != nil {
+		t.Fatal(err)
+	}
+	if controllerutil.ContainsFinalizer(deleted, buildFinalizer) {
+		t.Errorf("finalizer not removed: %v", deleted.Finalizers)
+	}
+}
+
+func TestReconcileRepairsDrift(t *testing.T) {
+	spkg := testBuild()
+	stale := &buildv1.BuildConfig{ObjectMeta: metav1.ObjectMeta{
+		Namespace: "builds",
+		Name:      "stack-python-buildconfig",
+		Labels:    map[string]string{buildLabel: spkg.Name},
+	}}
+	r := newTestReconciler(t, spkg, stale)
+	ctx := context.Background()
+	key := types.NamespacedName{Namespace: spkg.Namespace, Name: spkg.Name}
+	// the resources exist from the second pass on, the later ones must not
+	// fail to create them again
+	reconcileBuild(t, r, key, 2)
+
+	got := &packagev1alpha1.Build{}
+	if err := r.Get(ctx, key, got); err != nil {
+		t.Fatal(err)
+	}
+	env := got.Status.Environments[0]
+	bcKey := types.NamespacedName{Namespace: spkg.Namespace, Name: env.BuildConfig}
+	bc := &buildv1.BuildConfig{}
+	if err := r.Get(ctx, bcKey, bc); err != nil {
+		t.Fatal(err)
+	}
+	desired := bc.DeepCopy()
+
+	// edit the BuildConfig by hand and delete the ConfigMap of the environment
+	bc.Spec.Source.Dockerfile = strPtr("FROM scratch\n")
+	bc.Spec.NodeSelector = buildv1.OptionalNodeSelector{archLabel: "s390x"}
+	bc.Spec.Output.To = &corev1.ObjectReference{Kind: "DockerImage", Name: "quay.io/elsewhere/stack:v1"}
+	if err := r.Update(ctx, bc); err != nil {
+		t.Fatal(err)
+	}
+	cm := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: spkg.Namespace, Name: env.ConfigMap}}
+	if err := r.Delete(ctx, cm); err != nil {
+		t.Fatal(err)
+	}
+	reconcileBuild(t, r, key, 1)
+
+	repaired := &buildv1.BuildConfig{}
+	if err := r.Get(ctx, bcKey, repaired); err != nil {
+		t.Fatal(err)
+	}
+	if !equality.Semantic.DeepEqual(repaired.Spec, desired.Spec) {
+		t.Errorf("the BuildConfig was not repaired:\ngot  %+v\nwant %+v", repaired.Spec, desired.Spec)
+	}
+	if err := r.Get(ctx, types.NamespacedName{Namespace: spkg.Namespace, Name: env.ConfigMap}, cm); err != nil {
+		t.Errorf("the ConfigMap was not recreated: %v", err)
+	}
+	if err := r.Get(ctx, types.NamespacedName{Namespace: "builds", Name: stale.Name}, &buildv1.BuildConfig{}); err == nil {
+		t.Errorf("the BuildConfig of no environment was not pruned")
+	}
+}
+
+// fakeBuildClient serves the instantiate requests of the BuildConfigs of r,
+// creating their OpenShift Builds in its client, and returns the requests
+func fakeBuildClient(t *testing.T, r *BuildReconciler) *[]buildv1.BuildRequest {
+	requests := &[]buildv1.BuildRequest{}
+	numbers := map[string]int{}
+	r.BuildClient = &restfake.RESTClient{
+		NegotiatedSerializer: serializer.NewCodecFactory(r.Scheme).WithoutConversion(),
+		GroupVersion:         buildv1.GroupVersion,
+		Client: restfake.CreateHTTPClient(func(req *http.Request) (*http.Response, error) {
+			// /namespaces/<namespace>/buildconfigs/<name>/instantiate
+			path := s.Split(s.Trim(req.URL.Path, "/"), "/")
+			if len(path) != 5 || path[4] != "instantiate" {
+				return nil, fmt.Errorf("unexpected request %s %s", req.Method, req.URL.Path)
+			}
+			request := buildv1.BuildRequest{}
+			if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
+				return nil, err
+			}
+			*requests = append(*requests, request)
+			numbers[request.Name]++
+			n := strconv.Itoa(numbers[request.Name])
+			build := &buildv1.Build{
+				TypeMeta: metav1.TypeMeta{APIVersion: buildv1.GroupVersion.String(), Kind: "Build"},
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace:   path[1],
+					Name:        request.Name + "-" + n,
+					Labels:      map[string]string{buildv1.BuildConfigLabel: request.Name},
+					Annotations: map[string]string{buildv1.BuildNumberAnnotation: n},
+				},
+				Status: buildv1.BuildStatus{Phase: buildv1.BuildPhaseNew},
+			}
+			for k, v := range request.Annotations {
+				build.Annotations[k] = v
+			}
+			if err := r.Client.Create(req.Context(), build); err != nil {
+				return nil, err
+			}
+			body, err := json.Marshal(build)
+			if err != nil {
+				return nil, err
+			}
+			return &http.Response{
+				StatusCode: http.StatusCreated,
+				Header:     http.Header{"Content-Type": []string{"application/json"}},
+				Body:       ioutil.NopCloser(bytes.NewReader(body)),
+			}, nil
+		}),
+	}
+	return requests
+}
+
+func TestReconcileRebuildsOnSpecChange(t *testing.T) {
+	spkg := testBuild()
+	spkg.Generation = 1
+	r := newTestReconciler(t, spkg)
+	requests := fakeBuildClient(t, r)
+	ctx := context.Background()
+	key := types.NamespacedName{Namespace: spkg.Namespace, Name: spkg.Name}
+	// add the finalizer, create and validate the resources, start the builds
+	reconcileBuild(t, r, key, 4)
+	if len(*requests) != 1 {
+		t.Fatalf("got %d builds started, want 1", len(*requests))
+	}
+
+	got := &packagev1alpha1.Build{}
+	if err := r.Get(ctx, key, got); err != nil {
+		t.Fatal(err)
+	}
+	before := got.Status.Environments[0]
+
+	// an unchanged spec starts no build
+	reconcileBuild(t, r, key, 1)
+	if len(*requests) != 1 {
+		t.Fatalf("got %d builds started without a spec change, want 1", len(*requests))
+	}
+
+	if err := r.Get(ctx, key, got); err != nil {
+		t.Fatal(err)
+	}
+	got.Spec.Environment[0].Data = strPtr("spack:\n  specs:\n  - zlib\n  - bzip2\n  view: true\n")
+	got.Generation = 2
+	if err := r.Update(ctx, got); err != nil {
+		t.Fatal(err)
+	}
+	reconcileBuild(t, r, key, 1)
+
+	if len(*requests) != 2 {
+		t.Fatalf("got %d builds started after the spec change, want 2", len(*requests))
+	}
+	if g := (*requests)[1].Annotations[generationAnnotation]; g != "2" {
+		t.Errorf("the new build was started for generation %s, want 2", g)
+	}
+	if err := r.Get(ctx, key, got); err != nil {
+		t.Fatal(err)
+	}
+	after := got.Status.Environments[0]
+	if after.ConfigMap == before.ConfigMap {
+		t.Errorf("the spack.yaml change kept the ConfigMap %s", after.ConfigMap)
+	}
+	if err := r.Get(ctx, types.NamespacedName{Namespace: spkg.Namespace, Name: before.ConfigMap}, &corev1.ConfigMap{}); err == nil {
+		t.Errorf("the ConfigMap %s of the previous spec was not pruned", before.ConfigMap)
+	}
+	bc := &buildv1.BuildConfig{}
+	if err := r.Get(ctx, types.NamespacedName{Namespace: spkg.Namespace, Name: after.BuildConfig}, bc); err != nil {
+		t.Fatal(err)
+	}
+	if name := bc.Spec.Source.ConfigMaps[0].ConfigMap.Name; name != after.ConfigMap {
+		t.Errorf("the BuildConfig builds ConfigMap %s, want %s", name, after.ConfigMap)
+	}
+	if len(after.History) != 2 || after.History[0].Generation != 2 || after.History[1].Generation != 1 ||
+		after.History[0].Build != after.LatestBuild {
+		t.Errorf("got history %+v with latest build %s", after.History, after.LatestBuild)
+	}
+}
+
+func TestReconcileBuildsPerArchitecture(t *testing.T) {
+	spkg := testBuild()
+	spkg.Spec.Architectures = []packagev1alpha1.Architecture{"amd64", "arm64"}
+	r := newTestReconciler(t, spkg)
+	requests := fakeBuildClient(t, r)
+	ctx := context.Background()
+	key := types.NamespacedName{Namespace: spkg.Namespace, Name: spkg.Name}
+	reconcileBuild(t, r, key, 4)
+
+	got := &packagev1alpha1.Build{}
+	if err := r.Get(ctx, key, got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Status.Environments) != 2 {
+		t.Fatalf("got %d environments in the status, want one per architecture: %+v",
+			len(got.Status.Environments), got.Status.Environments)
+	}
+	for _, env := range got.Status.Environments {
+		arch := string(env.Architecture)
+		bc := &buildv1.BuildConfig{}
+		if err := r.Get(ctx, types.NamespacedName{Namespace: spkg.Namespace, Name: env.BuildConfig}, bc); err != nil {
+			t.Fatalf("%s: %v", arch, err)
+		}
+		if !s.Contains(bc.Name, arch) {
+			t.Errorf("%s: the BuildConfig %s is not named after its architecture", arch, bc.Name)
+		}
+		if !equality.Semantic.DeepEqual(bc.Spec.NodeSelector, buildv1.OptionalNodeSelector{archLabel: arch}) {
+			t.Errorf("%s: got node selector %v", arch, bc.Spec.NodeSelector)
+		}
+		if want := "stack:v1-mpi-" + arch; bc.Spec.Output.To == nil || bc.Spec.Output.To.Name != want || env.Image != want {
+			t.Errorf("%s: the BuildConfig pushes to %+v and the status records %s, want %s", arch, bc.Spec.Output.To, env.Image, want)
+		}
+		if env.LatestBuild == "" || env.State != packagev1alpha1.NewPackage {
+			t.Errorf("%s: got latest build %q in state %s", arch, env.LatestBuild, env.State)
+		}
+	}
+	if len(*requests) != 2 {
+		t.Errorf("got %d builds started, want one per architecture", len(*requests))
+	}
+}
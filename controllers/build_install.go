@@ -0,0 +1,573 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	s "strings"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	packagev1alpha1 "github.com/ArangoGutierrez/spack-operator/api/v1alpha1"
+)
+
+const (
+	// installTreeDir is where Spack installs the packages on the install
+	// claim
+	installTreeDir = packagev1alpha1.InstallMountPath + "/opt"
+
+	// installEnvironmentsDir holds the environments installed onto the
+	// install claim
+	installEnvironmentsDir = packagev1alpha1.InstallMountPath + "/environments"
+
+	// installViewsDir holds the views of the environments installed onto
+	// the install claim
+	installViewsDir = packagev1alpha1.InstallMountPath + "/views"
+
+	// installClaimLabel is the label holding the name of the install claim
+	// of an install Job and of its lock, the Jobs sharing a claim run one at
+	// a time
+	installClaimLabel = "multiarch.builder.io/install-claim"
+
+	// installLockSeconds is how long the lock of an install claim is held
+	// for a Job that has not been created yet
+	installLockSeconds = 60
+
+	// installedSpecsFile lists the root specs installed in the directory of
+	// an environment on the install claim
+	installedSpecsFile = "installed-specs"
+
+	// jobNameLabel is the label the Job controller sets on the Pods of a Job
+	jobNameLabel = "job-name"
+)
+
+// installScript installs an environment onto the install claim mounted in
+// the install Jobs. It copies the spack.yaml of the build context to
+// SPACK_ENV_DIR, linked where the build script expects the environment,
+// and runs the build script with bash, /bin/sh is dash on Ubuntu, holding a
+// lock on the claim taken with flock which the image must provide. The root specs of the environment and their
+// hashes are the termination message of the container.
+const installScript = `set -e
+if ! command -v flock > /dev/null; then
+  echo "flock is required to lock the install tree of the claim" >&2
+  exit 1
+fi
+exec 9> ` + packagev1alpha1.InstallMountPath + `/.lock
+flock 9
+mkdir -p "$SPACK_ENV_DIR"
+cp ` + buildContextDir + `/` + spackEnvFile + ` "$SPACK_ENV_DIR/` + spackEnvFile + `"
+rm -f "$SPACK_ENV_DIR/spack.lock"
+rm -rf /opt/spack-environment
+ln -s "$SPACK_ENV_DIR" /opt/spack-environment
+if [ -d ` + buildContextDir + `/` + signingKeyDir + ` ]; then
+  cp -rL ` + buildContextDir + `/` + signingKeyDir + ` /opt/` + signingKeyDir + `
+fi
+/bin/bash ` + buildContextDir + `/` + buildScriptFile + `
+specs="$SPACK_ENV_DIR/` + installedSpecsFile + `"
+spack -e "$SPACK_ENV_DIR" find -x --format '{name}@{version} {hash}' > "$specs"
+# the kubelet truncates the termination message to 4096 bytes
+{
+  echo "installed $(wc -l < "$specs")"
+  awk '{ n += length($0) + 1; if (n > 4000) exit; print }' "$specs"
+} > /dev/termination-log
+`
+
+// installBackend installs the environments onto the PersistentVolumeClaim
+// of a Build with Jobs created from a PodTemplate per environment and
+// platform, no image is built. The Spack base image of the Jobs is resolved
+// by the image backend of the Build.
+type installBackend struct {
+	r      *BuildReconciler
+	images buildBackend
+}
+
+// repository fails, the installs do not push any image
+func (b *installBackend) repository(ctx context.Context, spkg *packagev1alpha1.Build, namespace, stream string) (string, error) {
+	return "", fmt.Errorf("Build %s installs its environments onto a claim, it does not push images", spkg.Name)
+}
+
+// builderImage returns the image of the install Jobs
+func (b *installBackend) builderImage(ctx context.Context, spkg *packagev1alpha1.Build, base *corev1.ObjectReference) (string, error) {
+	if spkg.Spec.Install.Image != "" {
+		return spkg.Spec.Install.Image, nil
+	}
+	return b.pullSpec(ctx, spkg, base)
+}
+
+// pullSpec returns the reference of the base image in the registry of the
+// image backend
+func (b *installBackend) pullSpec(ctx context.Context, spkg *packagev1alpha1.Build, base *corev1.ObjectReference) (string, error) {
+	return b.images.pullSpec(ctx, spkg, base)
+}
+
+// setBaseImageCondition checks the base image through the image backend,
+// unless the Build sets the image of the install Jobs
+func (b *installBackend) setBaseImageCondition(ctx context.Context, spkg *packagev1alpha1.Build, base *corev1.ObjectReference) error {
+	if image := spkg.Spec.Install.Image; image != "" {
+		setCondition(spkg, packagev1alpha1.ConditionBaseImageReady, metav1.ConditionTrue,
+			"ImageNotChecked", "install image "+image+" is assumed to be available")
+		return nil
+	}
+	return b.images.setBaseImageCondition(ctx, spkg, base)
+}
+
+// sync converges the PodTemplate of the install Jobs of an environment
+func (b *installBackend) sync(ctx context.Context, spkg *packagev1alpha1.Build, eb *envBuild, keep map[string]bool) error {
+	r := b.r
+	image, err := b.builderImage(ctx, spkg, eb.Base)
+	if err != nil {
+		return err
+	}
+	if err := r.applyPodTemplate(ctx, spkg, b.envPodTemplate(spkg, eb, image)); err != nil {
+		r.Log.Error(err, "Failed to reconcile the PodTemplate", "environment", *eb.Env.Name, "platform", eb.Platform.suffix())
+		return err
+	}
+	keep["PodTemplate/"+eb.Name] = true
+	return nil
+}
+
+// envPodTemplate returns the PodTemplate of the Jobs installing a Spack
+// environment for a platform onto the install claim with image
+func (b *installBackend) envPodTemplate(spkg *packagev1alpha1.Build, eb *envBuild, image string) *corev1.PodTemplate {
+	install := spkg.Spec.Install
+	env := append([]corev1.EnvVar{{
+		Name:  "SPACK_ENV_DIR",
+		Value: installEnvironmentsDir + "/" + installEnvName(spkg.Name, *eb.Env.Name, eb.Platform),
+	}}, mirrorBuildArgs(spkg.Spec.BinaryMirror)...)
+
+	volumes := []corev1.Volume{
+		buildContextVolume(spkg, eb),
+		{
+			Name: "install",
+			VolumeSource: corev1.VolumeSource{
+				PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: install.PersistentVolumeClaim.Name},
+			},
+		},
+	}
+	mounts := []corev1.VolumeMount{
+		{Name: volumes[0].Name, MountPath: buildContextDir, ReadOnly: true},
+		{Name: volumes[1].Name, MountPath: packagev1alpha1.InstallMountPath, SubPath: install.Path},
+	}
+
+	pt := &corev1.PodTemplate{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      eb.Name,
+			Namespace: spkg.Namespace,
+			Labels:    map[string]string{buildLabel: spkg.Name},
+		},
+		Template: corev1.PodTemplateSpec{
+			ObjectMeta: metav1.ObjectMeta{
+				Labels: map[string]string{buildLabel: spkg.Name},
+			},
+			Spec: corev1.PodSpec{
+				RestartPolicy: corev1.RestartPolicyNever,
+				NodeSelector:  eb.Platform.nodeSelector(),
+				Containers: []corev1.Container{{
+					Name:         buildContainer,
+					Image:        image,
+					Command:      []string{"/bin/bash", "-c", installScript},
+					Env:          env,
+					VolumeMounts: mounts,
+					// the log of a failed install is its termination message
+					TerminationMessagePolicy: corev1.TerminationMessageFallbackToLogsOnError,
+				}},
+				Volumes: volumes,
+			},
+		},
+	}
+	pt.Annotations = map[string]string{specHashAnnotation: hashOf(pt.Template)}
+	return pt
+}
+
+// validate checks that the PodTemplate of an environment and the install
+// claim exist
+func (b *installBackend) validate(ctx context.Context, spkg *packagev1alpha1.Build, env *packagev1alpha1.EnvironmentStatus) (string, error) {
+	pt := &corev1.PodTemplate{}
+	key := types.NamespacedName{Namespace: spkg.Namespace, Name: env.BuildConfig}
+	if err := b.r.Client.Get(ctx, key, pt); err != nil {
+		if !errors.IsNotFound(err) {
+			return "", err
+		}
+		return "PodTemplate " + env.BuildConfig + " not found", nil
+	}
+	claim := spkg.Spec.Install.PersistentVolumeClaim.Name
+	pvc := &corev1.PersistentVolumeClaim{}
+	if err := b.r.Client.Get(ctx, types.NamespacedName{Namespace: spkg.Namespace, Name: claim}, pvc); err != nil {
+		if !errors.IsNotFound(err) {
+			return "", err
+		}
+		return "PersistentVolumeClaim " + claim + " not found", nil
+	}
+	return "", nil
+}
+
+// update tracks the install Jobs created from the PodTemplate of an
+// environment, creating a new one whenever the PodTemplate changes and no
+// other Job holds the install claim
+func (b *installBackend) update(ctx context.Context, spkg *packagev1alpha1.Build, env *packagev1alpha1.EnvironmentStatus) error {
+	r := b.r
+	pt := &corev1.PodTemplate{}
+	key := types.NamespacedName{Namespace: spkg.Namespace, Name: env.BuildConfig}
+	if err := r.Client.Get(ctx, key, pt); err != nil {
+		if errors.IsNotFound(err) {
+			// the PodTemplate has just been created and is not cached yet
+			return nil
+		}
+		r.Log.Error(err, "Failed to get the PodTemplate", "podTemplate", env.BuildConfig)
+		return err
+	}
+
+	jobs, err := b.installJobs(ctx, spkg, client.MatchingLabels{buildLabel: spkg.Name, podTemplateLabel: pt.Name})
+	if err != nil {
+		r.Log.Error(err, "Failed to list the install Jobs", "podTemplate", pt.Name)
+		return err
+	}
+	var latest *batchv1.Job
+	if len(jobs) > 0 {
+		latest = jobs[0]
+	}
+
+	// start a new install whenever the PodTemplate changes, unless the
+	// latest one was already started for it. The installs of every Build
+	// sharing the claim wait for the running one to finish.
+	hash := pt.Annotations[specHashAnnotation]
+	if hash != env.SpecHash && (latest == nil || latest.Annotations[specHashAnnotation] != hash) {
+		claim := spkg.Spec.Install.PersistentVolumeClaim.Name
+		number := int64(1)
+		if latest != nil {
+			number = buildNumberOf(latest) + 1
+		}
+		name := joinNonEmpty(pt.Name, strconv.FormatInt(number, 10))
+		holder, err := b.lockClaim(ctx, spkg, name)
+		if err != nil {
+			r.Log.Error(err, "Failed to lock the install claim", "persistentVolumeClaim", claim)
+			return err
+		}
+		if holder != "" {
+			r.Log.Info("Waiting for the install claim to be released", "persistentVolumeClaim", claim, "job", holder)
+			reason := fmt.Sprintf("waiting for Job %s to release PersistentVolumeClaim %s", holder, claim)
+			if env.State != packagev1alpha1.PendingPackage || env.Reason != reason {
+				env.LastUpdate = metav1.Now()
+			}
+			env.State = packagev1alpha1.PendingPackage
+			env.Reason = reason
+			return nil
+		}
+		r.Log.Info("Starting a new install", "podTemplate", pt.Name, "generation", spkg.Generation)
+		if latest, err = b.createInstallJob(ctx, spkg, pt, number); err != nil {
+			r.Log.Error(err, "Failed to start a new install", "podTemplate", pt.Name)
+			return err
+		}
+		env.History = recordBuild(env.History, packagev1alpha1.BuildRecord{
+			Generation: spkg.Generation,
+			Build:      latest.Name,
+		})
+	}
+	env.SpecHash = hash
+	if latest == nil {
+		return nil
+	}
+	if g, err := strconv.ParseInt(latest.Annotations[generationAnnotation], 10, 64); err == nil {
+		env.ObservedGeneration = g
+	}
+
+	pod, err := b.jobPod(ctx, latest)
+	if err != nil {
+		r.Log.Error(err, "Failed to get the Pod of the install Job", "job", latest.Name)
+		return err
+	}
+	state, reason := jobStatus(latest, pod)
+	completion := latest.Status.CompletionTime
+	var exitCode *int32
+	var specs []packagev1alpha1.InstalledSpec
+	var count int32
+	if pod != nil {
+		if t := buildContainerTermination(pod); t != nil {
+			completion = &t.FinishedAt
+			code := t.ExitCode
+			exitCode = &code
+			if t.ExitCode == 0 {
+				specs, count = installedSpecs(t.Message)
+			}
+		}
+	}
+	recordLatestBuild(env, latest.Name, state, reason, "", latest.Status.StartTime, completion)
+	env.ExitCode = exitCode
+	env.InstalledSpecs = specs
+	env.InstalledSpecCount = count
+	return nil
+}
+
+// lockClaim acquires the lock of the install claim of a Build for the
+// install Job named job, a Lease held by a single Job at a time. It returns
+// the name of the Job holding the lock when it is another one, still running
+// or about to be created. The lock and its holder are read from the API
+// server: the installs just started by other Builds may not be cached yet,
+// and the Lease is only updated from the version read.
+func (b *installBackend) lockClaim(ctx context.Context, spkg *packagev1alpha1.Build, job string) (string, error) {
+	claim := spkg.Spec.Install.PersistentVolumeClaim.Name
+	now := metav1.NewMicroTime(time.Now())
+	seconds := int32(installLockSeconds)
+	spec := coordinationv1.LeaseSpec{HolderIdentity: &job, LeaseDurationSeconds: &seconds, AcquireTime: &now}
+	lease := &coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      joinNonEmpty(claim, "install-lock"),
+			Namespace: spkg.Namespace,
+			Labels:    map[string]string{installClaimLabel: claim},
+		},
+		Spec: spec,
+	}
+	err := b.r.Client.Create(ctx, lease)
+	if err == nil || !errors.IsAlreadyExists(err) {
+		return "", err
+	}
+
+	current := &coordinationv1.Lease{}
+	if err := b.r.apiReader().Get(ctx, client.ObjectKeyFromObject(lease), current); err != nil {
+		return "", err
+	}
+	if holder := current.Spec.HolderIdentity; holder != nil && *holder != job {
+		held, err := b.lockHeld(ctx, current, now.Time)
+		if err != nil || held {
+			return *holder, err
+		}
+	}
+	current.Spec = spec
+	// conflicts with the Builds that have taken the lock since it was read
+	return "", b.r.Client.Update(ctx, current)
+}
+
+// lockHeld returns true while the Job holding the lock of an install claim
+// runs, or until the lock expires when the Job has not been created
+func (b *installBackend) lockHeld(ctx context.Context, lease *coordinationv1.Lease, now time.Time) (bool, error) {
+	job := &batchv1.Job{}
+	key := types.NamespacedName{Namespace: lease.Namespace, Name: *lease.Spec.HolderIdentity}
+	err := b.r.apiReader().Get(ctx, key, job)
+	if err == nil {
+		return !jobFinished(job), nil
+	}
+	if !errors.IsNotFound(err) {
+		return false, err
+	}
+	if lease.Spec.AcquireTime == nil || lease.Spec.LeaseDurationSeconds == nil {
+		return false, nil
+	}
+	expiry := lease.Spec.AcquireTime.Add(time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second)
+	return now.Before(expiry), nil
+}
+
+// createInstallJob creates the install Job number of a PodTemplate, owned
+// by the Build and annotated with the spec hash and generation it was
+// started for. It runs a single Pod, a failed install is not retried.
+func (b *installBackend) createInstallJob(ctx context.Context, spkg *packagev1alpha1.Build, pt *corev1.PodTemplate, number int64) (*batchv1.Job, error) {
+	backoffLimit := int32(0)
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      joinNonEmpty(pt.Name, strconv.FormatInt(number, 10)),
+			Namespace: pt.Namespace,
+			Labels: map[string]string{
+				buildLabel:        spkg.Name,
+				podTemplateLabel:  pt.Name,
+				installClaimLabel: spkg.Spec.Install.PersistentVolumeClaim.Name,
+			},
+			Annotations: map[string]string{
+				specHashAnnotation:    pt.Annotations[specHashAnnotation],
+				generationAnnotation:  strconv.FormatInt(spkg.Generation, 10),
+				buildNumberAnnotation: strconv.FormatInt(number, 10),
+			},
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: &backoffLimit,
+			Template:     *pt.Template.DeepCopy(),
+		},
+	}
+	if err := controllerutil.SetControllerReference(spkg, job, b.r.Scheme); err != nil {
+		return nil, err
+	}
+	if err := b.r.Client.Create(ctx, job); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// installJobs returns the install Jobs of the namespace of a Build matching
+// labels, latest first
+func (b *installBackend) installJobs(ctx context.Context, spkg *packagev1alpha1.Build, labels client.MatchingLabels) ([]*batchv1.Job, error) {
+	list := &batchv1.JobList{}
+	if err := b.r.Client.List(ctx, list, client.InNamespace(spkg.Namespace), labels); err != nil {
+		return nil, err
+	}
+	jobs := []*batchv1.Job{}
+	for i := range list.Items {
+		jobs = append(jobs, &list.Items[i])
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return buildNumberOf(jobs[i]) > buildNumberOf(jobs[j])
+	})
+	return jobs, nil
+}
+
+// jobPod returns the latest Pod of an install Job, nil before it is created
+func (b *installBackend) jobPod(ctx context.Context, job *batchv1.Job) (*corev1.Pod, error) {
+	pods := &corev1.PodList{}
+	if err := b.r.Client.List(ctx, pods, client.InNamespace(job.Namespace), client.MatchingLabels{jobNameLabel: job.Name}); err != nil {
+		return nil, err
+	}
+	var latest *corev1.Pod
+	for i := range pods.Items {
+		if latest == nil || latest.CreationTimestamp.Before(&pods.Items[i].CreationTimestamp) {
+			latest = &pods.Items[i]
+		}
+	}
+	return latest, nil
+}
+
+// prune deletes the install Jobs of a Build whose PodTemplate is not listed
+// in keep, and the finished Jobs beyond the history limits of the Build.
+// Their Pods are deleted by the garbage collector.
+func (b *installBackend) prune(ctx context.Context, spkg *packagev1alpha1.Build, keep map[string]bool) error {
+	r := b.r
+	jobs, err := b.installJobs(ctx, spkg, client.MatchingLabels{buildLabel: spkg.Name})
+	if err != nil {
+		return err
+	}
+	background := client.PropagationPolicy(metav1.DeletePropagationBackground)
+	succeeded, failed := map[string]int32{}, map[string]int32{}
+	for _, job := range jobs {
+		template := job.Labels[podTemplateLabel]
+		if !keep["PodTemplate/"+template] {
+			r.Log.Info("Deleting the install Job of a stale PodTemplate", "job", job.Name)
+			if err := r.Client.Delete(ctx, job, background); err != nil && !errors.IsNotFound(err) {
+				return err
+			}
+			continue
+		}
+
+		var limit *int32
+		switch state, _ := jobStatus(job, nil); state {
+		case packagev1alpha1.CompletedPackage:
+			succeeded[template]++
+			if spkg.Spec.SuccessfulBuildsHistoryLimit != nil && succeeded[template] > *spkg.Spec.SuccessfulBuildsHistoryLimit {
+				limit = spkg.Spec.SuccessfulBuildsHistoryLimit
+			}
+		case packagev1alpha1.FailedPackage:
+			failed[template]++
+			if spkg.Spec.FailedBuildsHistoryLimit != nil && failed[template] > *spkg.Spec.FailedBuildsHistoryLimit {
+				limit = spkg.Spec.FailedBuildsHistoryLimit
+			}
+		}
+		if limit == nil {
+			continue
+		}
+		r.Log.Info("Deleting install Job beyond the history limit", "job", job.Name, "limit", *limit)
+		if err := r.Client.Delete(ctx, job, background); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteImages leaves the software installed onto the claim, its packages
+// may be shared by the environments of other Builds
+func (b *installBackend) deleteImages(ctx context.Context, spkg *packagev1alpha1.Build) error {
+	return nil
+}
+
+// jobFinished returns true once an install Job has completed or failed
+func jobFinished(job *batchv1.Job) bool {
+	state, _ := jobStatus(job, nil)
+	return isFinished(state)
+}
+
+// jobStatus maps the conditions of an install Job, and the phase of its
+// Pod while it runs, into an InstallStatus and the reason of the state
+func jobStatus(job *batchv1.Job, pod *corev1.Pod) (packagev1alpha1.InstallStatus, string) {
+	reason := ""
+	if pod != nil {
+		reason = podReason(pod)
+	}
+	for _, c := range job.Status.Conditions {
+		if c.Status != corev1.ConditionTrue {
+			continue
+		}
+		switch c.Type {
+		case batchv1.JobComplete:
+			return packagev1alpha1.CompletedPackage, reason
+		case batchv1.JobFailed:
+			if reason == "" {
+				reason = fmt.Sprintf("%s: %s", job.Name, c.Message)
+			}
+			return packagev1alpha1.FailedPackage, reason
+		}
+	}
+	if pod != nil {
+		return podStatus(pod), reason
+	}
+	return packagev1alpha1.PendingPackage, reason
+}
+
+// installedSpecs parses the root specs reported by an install Job, one
+// "<name>@<version> <hash>" line each after an "installed <count>" line,
+// and returns them with the number of root specs installed
+func installedSpecs(message string) ([]packagev1alpha1.InstalledSpec, int32) {
+	var specs []packagev1alpha1.InstalledSpec
+	count := int32(-1)
+	for _, line := range s.Split(message, "\n") {
+		fields := s.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		if fields[0] == "installed" {
+			if n, err := strconv.ParseInt(fields[1], 10, 32); err == nil {
+				count = int32(n)
+			}
+			continue
+		}
+		specs = append(specs, packagev1alpha1.InstalledSpec{Spec: fields[0], Hash: fields[1]})
+	}
+	if count < 0 {
+		count = int32(len(specs))
+	}
+	return specs, count
+}
+
+// installEnvName returns the name of the directories of a Spack environment
+// for a platform on the install claim
+func installEnvName(build, env string, p buildPlatform) string {
+	return joinNonEmpty(build, env, p.nameSuffix())
+}
+
+// installView returns the directory of the view of a Spack environment for
+// a platform on the install claim of a Build, empty when the Build builds
+// images
+func installView(spkg *packagev1alpha1.Build, env string, p buildPlatform) string {
+	if spkg.Spec.Install == nil {
+		return ""
+	}
+	return installViewsDir + "/" + installEnvName(spkg.Name, env, p)
+}
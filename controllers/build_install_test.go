@@ -0,0 +1,171 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	s "strings"
+	"testing"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	packagev1alpha1 "github.com/ArangoGutierrez/spack-operator/api/v1alpha1"
+	"github.com/ArangoGutierrez/spack-operator/pkg/controller/multiarch-builder/components"
+)
+
+// installBuild returns a Build named name installing onto the claim spack
+func installBuild(name string) *packagev1alpha1.Build {
+	spkg := testBuild()
+	spkg.Name = name
+	spkg.Spec.ImageStream = ""
+	spkg.Spec.Install = &packagev1alpha1.InstallSpec{
+		PersistentVolumeClaim: corev1.LocalObjectReference{Name: "spack"},
+	}
+	return spkg
+}
+
+func TestLockClaim(t *testing.T) {
+	r := newTestReconciler(t)
+	b := &installBackend{r: r, images: &kubernetesBackend{r: r}}
+	ctx := context.Background()
+	lock := func(build, job, want string) {
+		t.Helper()
+		holder, err := b.lockClaim(ctx, installBuild(build), job)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if holder != want {
+			t.Fatalf("%s: got holder %q, want %q", job, holder, want)
+		}
+	}
+
+	lock("a", "a-mpi-1", "")
+	// the Job of the holder is not created yet
+	lock("b", "b-mpi-1", "a-mpi-1")
+	// the holder retries after failing to create its Job
+	lock("a", "a-mpi-1", "")
+
+	job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Namespace: "builds", Name: "a-mpi-1"}}
+	if err := r.Create(ctx, job); err != nil {
+		t.Fatal(err)
+	}
+	lock("b", "b-mpi-1", "a-mpi-1")
+
+	job.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: corev1.ConditionTrue}}
+	if err := r.Status().Update(ctx, job); err != nil {
+		t.Fatal(err)
+	}
+	lock("b", "b-mpi-1", "")
+	lock("a", "a-mpi-2", "b-mpi-1")
+
+	// the lock of a Job never created expires
+	lease := &coordinationv1.Lease{}
+	if err := r.Get(ctx, types.NamespacedName{Namespace: "builds", Name: "spack-install-lock"}, lease); err != nil {
+		t.Fatal(err)
+	}
+	acquired := metav1.NewMicroTime(time.Now().Add(-2 * installLockSeconds * time.Second))
+	lease.Spec.AcquireTime = &acquired
+	if err := r.Update(ctx, lease); err != nil {
+		t.Fatal(err)
+	}
+	lock("a", "a-mpi-2", "")
+}
+
+func TestBuildScriptInstallTree(t *testing.T) {
+	tests := []struct {
+		version string
+		line    string
+	}{
+		{"v0.16.0", "spack config add config:install_tree:/claim/opt\n"},
+		{"v0.17.2", "spack config add config:install_tree:root:/claim/opt\n"},
+		{"develop", "spack config add config:install_tree:root:/claim/opt\n"},
+	}
+	for _, tt := range tests {
+		spkg := installBuild("stack")
+		spkg.Default()
+		spkg.Spec.SpackVersion = tt.version
+		r := newTestReconciler(t, spkg)
+		tmpl, err := r.loadBuildTemplates(context.Background(), spkg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		script, err := renderTemplate(tmpl, components.BuildScriptTemplate, newTemplateData(spkg))
+		if err != nil {
+			t.Fatal(err)
+		}
+		line := s.Replace(tt.line, "/claim", packagev1alpha1.InstallMountPath, 1)
+		if !s.Contains(script, line) {
+			t.Errorf("%s: the build script misses %q:\n%s", tt.version, line, script)
+		}
+	}
+}
+
+// TestInstallScriptReportsSpecs runs the end of the install script, which
+// reports the installed specs, with a stub of spack listing many of them
+func TestInstallScriptReportsSpecs(t *testing.T) {
+	bash, err := exec.LookPath("bash")
+	if err != nil {
+		t.Skip("bash is not installed")
+	}
+	dir, err := ioutil.TempDir("", "install-script")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	const count = 500
+	spack := fmt.Sprintf("#!/bin/sh\nfor i in $(seq %d); do echo \"pkg$i@1.0 0123456789abcdef0123456789abcdef\"; done\n", count)
+	if err := ioutil.WriteFile(filepath.Join(dir, "spack"), []byte(spack), 0755); err != nil {
+		t.Fatal(err)
+	}
+	message := filepath.Join(dir, "termination-log")
+	script := installScript[s.Index(installScript, "specs="):]
+	script = s.ReplaceAll(script, "/dev/termination-log", message)
+
+	cmd := exec.Command(bash, "-e", "-c", script)
+	cmd.Env = append(os.Environ(), "SPACK_ENV_DIR="+dir, "PATH="+dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("the install script fails: %v\n%s", err, out)
+	}
+	data, err := ioutil.ReadFile(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) > 4096 {
+		t.Errorf("the termination message is %d bytes long, the kubelet truncates it to 4096", len(data))
+	}
+	specs, n := installedSpecs(string(data))
+	if n != count || len(specs) == 0 || len(specs) >= count {
+		t.Errorf("got %d specs out of %d, want some of %d", len(specs), n, count)
+	}
+	listed, err := ioutil.ReadFile(filepath.Join(dir, installedSpecsFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := s.Count(string(listed), "\n"); lines != count {
+		t.Errorf("the claim lists %d specs, want %d", lines, count)
+	}
+}
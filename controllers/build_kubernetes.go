@@ -0,0 +1,652 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	s "strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	packagev1alpha1 "github.com/ArangoGutierrez/spack-operator/api/v1alpha1"
+	"github.com/ArangoGutierrez/spack-operator/pkg/registry"
+)
+
+const (
+	// DefaultBuildahImage runs the buildah build Pods of the Kubernetes
+	// backend when the operator does not build its own buildah image
+	DefaultBuildahImage = "quay.io/buildah/stable"
+
+	// DefaultKanikoImage runs the kaniko build Pods of the Kubernetes
+	// backend, the debug image provides the shell of the build script
+	DefaultKanikoImage = "gcr.io/kaniko-project/executor:debug"
+
+	// BuildahImageTag is the tag of the base image stream the buildah image
+	// of config/manifests/buildah_base_image.yaml is pushed to
+	BuildahImageTag = "buildah"
+
+	// buildahUser is the unprivileged user of the buildah image the builds
+	// run as
+	buildahUser = 1000
+
+	// podTemplateLabel is the label holding the name of the PodTemplate a
+	// build Pod was created from
+	podTemplateLabel = "multiarch.builder.io/pod-template"
+
+	// buildNumberAnnotation holds the sequential number of a build Pod
+	// among the Pods of its PodTemplate
+	buildNumberAnnotation = "multiarch.builder.io/build-number"
+
+	// buildContextDir is where the build context is mounted in the build
+	// Pods
+	buildContextDir = "/workspace"
+
+	// pushSecretDir is where the push Secret of a Build is mounted in the
+	// build Pods, as a Docker config.json
+	pushSecretDir = "/var/run/secrets/push"
+
+	// buildContainer is the name of the container running the build
+	buildContainer = "build"
+)
+
+// buildArgsScript adds the build arguments named by BUILD_ARGS, read from
+// the environment, to the arguments of the build scripts and writes the
+// Dockerfile of the DOCKERFILE variable to DOCKERFILE_PATH
+const buildArgsScript = `set -e
+for arg in $BUILD_ARGS; do
+  set -- "$@" --build-arg "$arg=$(printenv "$arg")"
+done
+printf '%s' "$DOCKERFILE" > "$DOCKERFILE_PATH"
+`
+
+// buildahScript builds the Dockerfile of the DOCKERFILE variable from the
+// context mounted in the Pod with rootless buildah and pushes the image to
+// IMAGE. The digest of the pushed image is the termination message of the
+// container, the extra arguments of the script are passed to buildah bud.
+const buildahScript = buildArgsScript + `buildah bud --storage-driver vfs --isolation chroot -f "$DOCKERFILE_PATH" -t "$IMAGE" "$@" ` + buildContextDir + `
+buildah push --storage-driver vfs --digestfile /dev/termination-log "$IMAGE"
+`
+
+// kanikoScript builds the Dockerfile of the DOCKERFILE variable with the
+// kaniko executor, which pushes the image to IMAGE and writes its digest
+// to the termination message of the container
+const kanikoScript = buildArgsScript + `exec /kaniko/executor --dockerfile "$DOCKERFILE_PATH" --context dir://` + buildContextDir + ` \
+  --destination "$IMAGE" --digest-file /dev/termination-log "$@"
+`
+
+// kubernetesBackend builds the images of the environments in Pods created
+// from a PodTemplate per environment and platform, pushing them to an OCI
+// registry
+type kubernetesBackend struct {
+	r *BuildReconciler
+}
+
+// repository returns the repository of an image stream, under the
+// registry of the spec of the Build or in the image registry of the operator
+func (b *kubernetesBackend) repository(ctx context.Context, spkg *packagev1alpha1.Build, namespace, stream string) (string, error) {
+	if k := spkg.Spec.Kubernetes; k != nil && k.Registry != "" {
+		return s.TrimSuffix(k.Registry, "/") + "/" + stream, nil
+	}
+	return b.registryRepository(namespace, stream)
+}
+
+// registryRepository returns the repository of an image stream in the image
+// registry of the operator, namespaced as the OpenShift registry does
+func (b *kubernetesBackend) registryRepository(namespace, stream string) (string, error) {
+	if b.r.ImageRegistry == "" {
+		return "", fmt.Errorf("no image registry is configured for the %s backend", packagev1alpha1.BackendKubernetes)
+	}
+	return s.TrimSuffix(b.r.ImageRegistry, "/") + "/" + namespace + "/" + stream, nil
+}
+
+// withTag returns the reference of a tag in a repository, the tag of an
+// ImageStreamTag without one being latest
+func withTag(repository, tag string) string {
+	if tag == "" {
+		tag = "latest"
+	}
+	return repository + ":" + tag
+}
+
+// builderImage returns the registry reference of the base image, every
+// stage of the Dockerfile is pulled by the build tool
+func (b *kubernetesBackend) builderImage(ctx context.Context, spkg *packagev1alpha1.Build, base *corev1.ObjectReference) (string, error) {
+	return b.pullSpec(ctx, spkg, base)
+}
+
+// pullSpec returns the reference of the base image in the image registry
+// of the operator
+func (b *kubernetesBackend) pullSpec(ctx context.Context, spkg *packagev1alpha1.Build, base *corev1.ObjectReference) (string, error) {
+	namespace := base.Namespace
+	if namespace == "" {
+		namespace = spkg.Namespace
+	}
+	stream, tag := splitImageStreamTag(base.Name)
+	repository, err := b.registryRepository(namespace, stream)
+	if err != nil {
+		return "", err
+	}
+	return withTag(repository, tag), nil
+}
+
+// setBaseImageCondition checks whether the base image has been pushed to
+// the image registry
+func (b *kubernetesBackend) setBaseImageCondition(ctx context.Context, spkg *packagev1alpha1.Build, base *corev1.ObjectReference) error {
+	image, err := b.builderImage(ctx, spkg, base)
+	if err != nil {
+		setCondition(spkg, packagev1alpha1.ConditionBaseImageReady, metav1.ConditionFalse,
+			"RegistryNotConfigured", err.Error())
+		return nil
+	}
+	if b.r.Registry == nil {
+		setCondition(spkg, packagev1alpha1.ConditionBaseImageReady, metav1.ConditionTrue,
+			"ImageNotChecked", "base image "+image+" is assumed to be available")
+		return nil
+	}
+	ref, err := registry.ParseReference(image)
+	if err != nil {
+		return err
+	}
+	if _, err := b.r.Registry.Resolve(ctx, ref); err != nil {
+		setCondition(spkg, packagev1alpha1.ConditionBaseImageReady, metav1.ConditionFalse,
+			"ImageNotFound", "base image "+image+" cannot be pulled: "+err.Error())
+		return nil
+	}
+	setCondition(spkg, packagev1alpha1.ConditionBaseImageReady, metav1.ConditionTrue,
+		"ImageAvailable", "base image "+image+" is available")
+	return nil
+}
+
+// sync converges the PodTemplate of the build Pods of an environment
+func (b *kubernetesBackend) sync(ctx context.Context, spkg *packagev1alpha1.Build, eb *envBuild, keep map[string]bool) error {
+	r := b.r
+	repository, err := r.outputRepository(ctx, spkg, eb.Output, b)
+	if err != nil {
+		return err
+	}
+	image := withTag(repository, eb.Tag)
+	if err := r.applyPodTemplate(ctx, spkg, b.envPodTemplate(spkg, eb, image)); err != nil {
+		r.Log.Error(err, "Failed to reconcile the PodTemplate", "environment", *eb.Env.Name, "platform", eb.Platform.suffix())
+		return err
+	}
+	keep["PodTemplate/"+eb.Name] = true
+	return nil
+}
+
+// applyPodTemplate creates or updates a PodTemplate of a Build with its
+// template and spec hash
+func (r *BuildReconciler) applyPodTemplate(ctx context.Context, spkg *packagev1alpha1.Build, desired *corev1.PodTemplate) error {
+	pt := &corev1.PodTemplate{ObjectMeta: envObjectMeta(spkg, desired.Name)}
+	op, err := controllerutil.CreateOrUpdate(ctx, r.Client, pt, func() error {
+		pt.Labels = mergeLabels(pt.Labels, desired.Labels)
+		if pt.Annotations == nil {
+			pt.Annotations = map[string]string{}
+		}
+		pt.Annotations[specHashAnnotation] = desired.Annotations[specHashAnnotation]
+		pt.Template = desired.Template
+		return controllerutil.SetControllerReference(spkg, pt, r.Scheme)
+	})
+	if err != nil {
+		return err
+	}
+	if op != controllerutil.OperationResultNone {
+		r.Log.Info("PodTemplate reconciled", "podTemplate", pt.Name, "operation", op)
+	}
+	return nil
+}
+
+// envPodTemplate returns the PodTemplate of the Pods building the image of
+// a Spack environment for a platform and pushing it to image
+func (b *kubernetesBackend) envPodTemplate(spkg *packagev1alpha1.Build, eb *envBuild, image string) *corev1.PodTemplate {
+	k := spkg.Spec.Kubernetes
+	if k == nil {
+		k = &packagev1alpha1.KubernetesBuildSpec{Tool: packagev1alpha1.ToolBuildah}
+	}
+
+	env := []corev1.EnvVar{
+		{Name: "DOCKERFILE", Value: eb.Dockerfile},
+		{Name: "IMAGE", Value: image},
+	}
+	args := mirrorBuildArgs(spkg.Spec.BinaryMirror)
+	if len(args) > 0 {
+		names := []string{}
+		for _, arg := range args {
+			names = append(names, arg.Name)
+		}
+		env = append(env, corev1.EnvVar{Name: "BUILD_ARGS", Value: s.Join(names, " ")})
+		env = append(env, args...)
+	}
+
+	// the push Secret of the output authenticates against its registry
+	pushSecret := outputPushSecret(spkg, eb.Output, b)
+
+	volumes := []corev1.Volume{buildContextVolume(spkg, eb)}
+	mounts := []corev1.VolumeMount{{
+		Name:      volumes[0].Name,
+		MountPath: buildContextDir,
+		ReadOnly:  true,
+	}}
+	if pushSecret != nil {
+		volumes = append(volumes, corev1.Volume{
+			Name: "push-secret",
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{
+					SecretName: pushSecret.Name,
+					Items:      []corev1.KeyToPath{{Key: corev1.DockerConfigJsonKey, Path: "config.json"}},
+				},
+			},
+		})
+		mounts = append(mounts, corev1.VolumeMount{
+			Name:      "push-secret",
+			MountPath: pushSecretDir,
+			ReadOnly:  true,
+		})
+	}
+
+	container := corev1.Container{
+		Name:         buildContainer,
+		Env:          env,
+		VolumeMounts: mounts,
+		// the log of a failed build is its termination message
+		TerminationMessagePolicy: corev1.TerminationMessageFallbackToLogsOnError,
+	}
+	shell, script := "/bin/sh", buildahScript
+	switch k.Tool {
+	case packagev1alpha1.ToolKaniko:
+		// kaniko unpacks the images on the root of its container, which it
+		// owns without any further privilege
+		shell, script = "/busybox/sh", kanikoScript
+		container.Image = b.r.KanikoImage
+		container.Env = append(container.Env, corev1.EnvVar{Name: "DOCKERFILE_PATH", Value: "/kaniko/Dockerfile"})
+		if pushSecret != nil {
+			container.Env = append(container.Env, corev1.EnvVar{Name: "DOCKER_CONFIG", Value: pushSecretDir})
+		}
+	default:
+		// rootless buildah runs the RUN instructions in a chroot of a user
+		// namespace, which the default seccomp profile denies
+		container.Image = b.r.BuildahImage
+		container.Env = append(container.Env,
+			corev1.EnvVar{Name: "DOCKERFILE_PATH", Value: "/tmp/Dockerfile"},
+			corev1.EnvVar{Name: "BUILDAH_ISOLATION", Value: "chroot"})
+		if pushSecret != nil {
+			container.Env = append(container.Env, corev1.EnvVar{Name: "REGISTRY_AUTH_FILE", Value: pushSecretDir + "/config.json"})
+		}
+		user, nonRoot, privileged := int64(buildahUser), true, false
+		container.SecurityContext = &corev1.SecurityContext{
+			RunAsUser:      &user,
+			RunAsNonRoot:   &nonRoot,
+			Privileged:     &privileged,
+			SeccompProfile: &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeUnconfined},
+		}
+	}
+	if k.Image != "" {
+		container.Image = k.Image
+	}
+	container.Command = []string{shell, "-c", script, "build"}
+	for _, label := range envImageLabels(spkg, eb.Env, eb.Platform) {
+		container.Command = append(container.Command, "--label", label.Name+"="+label.Value)
+	}
+
+	pt := &corev1.PodTemplate{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      eb.Name,
+			Namespace: spkg.Namespace,
+			Labels:    map[string]string{buildLabel: spkg.Name},
+		},
+		Template: corev1.PodTemplateSpec{
+			ObjectMeta: metav1.ObjectMeta{
+				Labels: map[string]string{buildLabel: spkg.Name, podTemplateLabel: eb.Name},
+			},
+			Spec: corev1.PodSpec{
+				RestartPolicy: corev1.RestartPolicyNever,
+				NodeSelector:  eb.Platform.nodeSelector(),
+				Containers:    []corev1.Container{container},
+				Volumes:       volumes,
+			},
+		},
+	}
+	pt.Annotations = map[string]string{specHashAnnotation: hashOf(pt.Template)}
+	return pt
+}
+
+// buildContextVolume returns the volume projecting the spack.yaml of an
+// environment, the build script and the signing key of a Build into the
+// build context of the Pods
+func buildContextVolume(spkg *packagev1alpha1.Build, eb *envBuild) corev1.Volume {
+	sources := []corev1.VolumeProjection{
+		{ConfigMap: &corev1.ConfigMapProjection{LocalObjectReference: corev1.LocalObjectReference{Name: eb.ConfigMap}}},
+		{ConfigMap: &corev1.ConfigMapProjection{LocalObjectReference: corev1.LocalObjectReference{Name: eb.BuildLogic}}},
+	}
+	for _, secret := range signingKeySources(spkg.Spec.BinaryMirror) {
+		sources = append(sources, corev1.VolumeProjection{Secret: &corev1.SecretProjection{
+			LocalObjectReference: secret.Secret,
+			Items: []corev1.KeyToPath{{
+				Key:  packagev1alpha1.MirrorSigningKey,
+				Path: secret.DestinationDir + "/" + packagev1alpha1.MirrorSigningKey,
+			}},
+		}})
+	}
+	return corev1.Volume{
+		Name: "context",
+		VolumeSource: corev1.VolumeSource{
+			Projected: &corev1.ProjectedVolumeSource{Sources: sources},
+		},
+	}
+}
+
+// validate checks that the PodTemplate of an environment exists
+func (b *kubernetesBackend) validate(ctx context.Context, spkg *packagev1alpha1.Build, env *packagev1alpha1.EnvironmentStatus) (string, error) {
+	pt := &corev1.PodTemplate{}
+	key := types.NamespacedName{Namespace: spkg.Namespace, Name: env.BuildConfig}
+	if err := b.r.Client.Get(ctx, key, pt); err != nil {
+		if !errors.IsNotFound(err) {
+			return "", err
+		}
+		return "PodTemplate " + env.BuildConfig + " not found", nil
+	}
+	return "", nil
+}
+
+// update tracks the build Pods created from the PodTemplate of an
+// environment, creating a new one whenever the PodTemplate changes
+func (b *kubernetesBackend) update(ctx context.Context, spkg *packagev1alpha1.Build, env *packagev1alpha1.EnvironmentStatus) error {
+	r := b.r
+	pt := &corev1.PodTemplate{}
+	key := types.NamespacedName{Namespace: spkg.Namespace, Name: env.BuildConfig}
+	if err := r.Client.Get(ctx, key, pt); err != nil {
+		if errors.IsNotFound(err) {
+			// the PodTemplate has just been created and is not cached yet
+			return nil
+		}
+		r.Log.Error(err, "Failed to get the PodTemplate", "podTemplate", env.BuildConfig)
+		return err
+	}
+
+	pods, err := b.buildPods(ctx, spkg, pt.Name)
+	if err != nil {
+		r.Log.Error(err, "Failed to list the build Pods", "podTemplate", pt.Name)
+		return err
+	}
+	var latest *corev1.Pod
+	if len(pods) > 0 {
+		latest = pods[0]
+	}
+
+	// start a new build whenever the PodTemplate changes, unless the latest
+	// build was already started for it. The serial run policies wait for
+	// the latest build to finish.
+	hash := pt.Annotations[specHashAnnotation]
+	if hash != env.SpecHash && (latest == nil || latest.Annotations[specHashAnnotation] != hash) {
+		if latest != nil && !isFinished(podStatus(latest)) && spkg.Spec.RunPolicy != packagev1alpha1.RunPolicyParallel {
+			r.Log.Info("Waiting for the latest build to finish", "pod", latest.Name)
+			hash = env.SpecHash
+		} else {
+			r.Log.Info("Starting a new build", "podTemplate", pt.Name, "generation", spkg.Generation)
+			if latest, err = b.createBuildPod(ctx, spkg, pt, latest); err != nil {
+				r.Log.Error(err, "Failed to start a new build", "podTemplate", pt.Name)
+				return err
+			}
+			env.History = recordBuild(env.History, packagev1alpha1.BuildRecord{
+				Generation: spkg.Generation,
+				Build:      latest.Name,
+			})
+		}
+	}
+	env.SpecHash = hash
+	if latest == nil {
+		return nil
+	}
+	if g, err := strconv.ParseInt(latest.Annotations[generationAnnotation], 10, 64); err == nil {
+		env.ObservedGeneration = g
+	}
+
+	var completion *metav1.Time
+	var exitCode *int32
+	digest := ""
+	if t := buildContainerTermination(latest); t != nil {
+		completion = &t.FinishedAt
+		code := t.ExitCode
+		exitCode = &code
+		if t.ExitCode == 0 {
+			digest = s.TrimSpace(t.Message)
+		}
+	}
+	recordLatestBuild(env, latest.Name, podStatus(latest), podReason(latest), digest,
+		latest.Status.StartTime, completion)
+	env.ExitCode = exitCode
+	return nil
+}
+
+// createBuildPod creates the next build Pod of a PodTemplate, owned by the
+// Build and annotated with the spec hash and generation it was started for
+func (b *kubernetesBackend) createBuildPod(ctx context.Context, spkg *packagev1alpha1.Build, pt *corev1.PodTemplate, latest *corev1.Pod) (*corev1.Pod, error) {
+	number := int64(1)
+	if latest != nil {
+		number = buildNumberOf(latest) + 1
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: *pt.Template.ObjectMeta.DeepCopy(),
+		Spec:       *pt.Template.Spec.DeepCopy(),
+	}
+	pod.Name = joinNonEmpty(pt.Name, strconv.FormatInt(number, 10))
+	pod.Namespace = pt.Namespace
+	if pod.Annotations == nil {
+		pod.Annotations = map[string]string{}
+	}
+	pod.Annotations[specHashAnnotation] = pt.Annotations[specHashAnnotation]
+	pod.Annotations[generationAnnotation] = strconv.FormatInt(spkg.Generation, 10)
+	pod.Annotations[buildNumberAnnotation] = strconv.FormatInt(number, 10)
+	if err := controllerutil.SetControllerReference(spkg, pod, b.r.Scheme); err != nil {
+		return nil, err
+	}
+	if err := b.r.Client.Create(ctx, pod); err != nil {
+		return nil, err
+	}
+	return pod, nil
+}
+
+// buildPods returns the build Pods of a PodTemplate, latest first
+func (b *kubernetesBackend) buildPods(ctx context.Context, spkg *packagev1alpha1.Build, podTemplate string) ([]*corev1.Pod, error) {
+	list := &corev1.PodList{}
+	opts := []client.ListOption{
+		client.InNamespace(spkg.Namespace),
+		client.MatchingLabels{buildLabel: spkg.Name, podTemplateLabel: podTemplate},
+	}
+	if err := b.r.Client.List(ctx, list, opts...); err != nil {
+		return nil, err
+	}
+	pods := []*corev1.Pod{}
+	for i := range list.Items {
+		pods = append(pods, &list.Items[i])
+	}
+	sort.Slice(pods, func(i, j int) bool {
+		return buildNumberOf(pods[i]) > buildNumberOf(pods[j])
+	})
+	return pods, nil
+}
+
+// prune deletes the PodTemplates of a Build not listed in keep with their
+// Pods, and the finished Pods beyond the history limits of the Build
+func (b *kubernetesBackend) prune(ctx context.Context, spkg *packagev1alpha1.Build, keep map[string]bool) error {
+	r := b.r
+	opts := []client.ListOption{
+		client.InNamespace(spkg.Namespace),
+		client.MatchingLabels{buildLabel: spkg.Name},
+	}
+
+	pts := &corev1.PodTemplateList{}
+	if err := r.Client.List(ctx, pts, opts...); err != nil {
+		return err
+	}
+	for i := range pts.Items {
+		if keep["PodTemplate/"+pts.Items[i].Name] {
+			continue
+		}
+		r.Log.Info("Deleting stale PodTemplate", "podTemplate", pts.Items[i].Name)
+		if err := r.Client.Delete(ctx, &pts.Items[i]); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+
+	pods := &corev1.PodList{}
+	if err := r.Client.List(ctx, pods, append(opts, client.HasLabels{podTemplateLabel})...); err != nil {
+		return err
+	}
+	byTemplate := map[string][]*corev1.Pod{}
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		template := pod.Labels[podTemplateLabel]
+		if !keep["PodTemplate/"+template] {
+			r.Log.Info("Deleting the build Pod of a stale PodTemplate", "pod", pod.Name)
+			if err := r.Client.Delete(ctx, pod); err != nil && !errors.IsNotFound(err) {
+				return err
+			}
+			continue
+		}
+		byTemplate[template] = append(byTemplate[template], pod)
+	}
+
+	for _, pods := range byTemplate {
+		sort.Slice(pods, func(i, j int) bool {
+			return buildNumberOf(pods[i]) > buildNumberOf(pods[j])
+		})
+		succeeded, failed := int32(0), int32(0)
+		for _, pod := range pods {
+			var limit *int32
+			switch pod.Status.Phase {
+			case corev1.PodSucceeded:
+				succeeded++
+				if spkg.Spec.SuccessfulBuildsHistoryLimit != nil && succeeded > *spkg.Spec.SuccessfulBuildsHistoryLimit {
+					limit = spkg.Spec.SuccessfulBuildsHistoryLimit
+				}
+			case corev1.PodFailed:
+				failed++
+				if spkg.Spec.FailedBuildsHistoryLimit != nil && failed > *spkg.Spec.FailedBuildsHistoryLimit {
+					limit = spkg.Spec.FailedBuildsHistoryLimit
+				}
+			}
+			if limit == nil {
+				continue
+			}
+			r.Log.Info("Deleting build Pod beyond the history limit", "pod", pod.Name, "limit", *limit)
+			if err := r.Client.Delete(ctx, pod); err != nil && !errors.IsNotFound(err) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// deleteImages leaves the images pushed to the registry, the registry API
+// does not let the operator remove tags
+func (b *kubernetesBackend) deleteImages(ctx context.Context, spkg *packagev1alpha1.Build) error {
+	return nil
+}
+
+// buildNumberOf returns the sequential number of a build Pod or install
+// Job, falling back to the creation time for the ones without one
+func buildNumberOf(obj metav1.Object) int64 {
+	if n, err := strconv.ParseInt(obj.GetAnnotations()[buildNumberAnnotation], 10, 64); err == nil {
+		return n
+	}
+	return obj.GetCreationTimestamp().Unix()
+}
+
+// waitingErrors are the reasons a build container waits for that need a
+// change of the Build or of the cluster, the build is errored then
+var waitingErrors = map[string]bool{
+	"ErrImagePull":               true,
+	"ImagePullBackOff":           true,
+	"InvalidImageName":           true,
+	"CreateContainerConfigError": true,
+	"CreateContainerError":       true,
+}
+
+// podStatus maps the phase of a build Pod, and the state of its build
+// container while it is pending, into an InstallStatus
+func podStatus(pod *corev1.Pod) packagev1alpha1.InstallStatus {
+	switch pod.Status.Phase {
+	case corev1.PodPending:
+		if w := buildContainerWaiting(pod); w != nil && waitingErrors[w.Reason] {
+			return packagev1alpha1.ErroredPackage
+		}
+		return packagev1alpha1.PendingPackage
+	case corev1.PodRunning:
+		return packagev1alpha1.RunningPackage
+	case corev1.PodSucceeded:
+		return packagev1alpha1.CompletedPackage
+	case corev1.PodFailed:
+		return packagev1alpha1.FailedPackage
+	case corev1.PodUnknown:
+		return packagev1alpha1.ErroredPackage
+	}
+	return packagev1alpha1.NewPackage
+}
+
+// buildContainerState returns the state of the build container of a Pod
+func buildContainerState(pod *corev1.Pod) corev1.ContainerState {
+	for _, cs := range pod.Status.ContainerStatuses {
+		if cs.Name == buildContainer {
+			return cs.State
+		}
+	}
+	return corev1.ContainerState{}
+}
+
+// buildContainerTermination returns the state of the terminated build
+// container of a Pod, nil while it has not terminated
+func buildContainerTermination(pod *corev1.Pod) *corev1.ContainerStateTerminated {
+	return buildContainerState(pod).Terminated
+}
+
+// buildContainerWaiting returns the state of the build container of a Pod
+// waiting to run, nil once it has started
+func buildContainerWaiting(pod *corev1.Pod) *corev1.ContainerStateWaiting {
+	return buildContainerState(pod).Waiting
+}
+
+// podReason describes why a build Pod is in its current phase
+func podReason(pod *corev1.Pod) string {
+	if t := buildContainerTermination(pod); t != nil && t.ExitCode != 0 {
+		// the build script reports the source archives missing from the mirror
+		if line := sourceMissingLine(t.Message); line != "" {
+			return fmt.Sprintf("%s: %s", pod.Name, line)
+		}
+		if t.Reason != "" && t.Reason != "Error" {
+			return fmt.Sprintf("%s: build exited with code %d (%s)", pod.Name, t.ExitCode, t.Reason)
+		}
+		return fmt.Sprintf("%s: build exited with code %d", pod.Name, t.ExitCode)
+	}
+	if w := buildContainerWaiting(pod); w != nil && waitingErrors[w.Reason] {
+		return fmt.Sprintf("%s: %s: %s", pod.Name, w.Reason, w.Message)
+	}
+	switch {
+	case pod.Status.Message != "":
+		return fmt.Sprintf("%s: %s", pod.Name, pod.Status.Message)
+	case pod.Status.Reason != "":
+		return fmt.Sprintf("%s: %s", pod.Name, pod.Status.Reason)
+	}
+	return ""
+}
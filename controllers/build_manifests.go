@@ -0,0 +1,159 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	s "strings"
+
+	packagev1alpha1 "github.com/ArangoGutierrez/spack-operator/api/v1alpha1"
+	"github.com/ArangoGutierrez/spack-operator/pkg/registry"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// assembleManifestLists pushes, for every environment whose platform
+// builds have all completed, a manifest list referencing the image of each
+// platform to the environment tag. The outcome is recorded in the
+// ManifestLists status of spkg, the returned error reports the environments
+// that could not be assembled.
+func (r *BuildReconciler) assembleManifestLists(ctx context.Context, spkg *packagev1alpha1.Build) error {
+	if !isMultiPlatform(spkg) || r.Registry == nil || spkg.Spec.Install != nil {
+		spkg.Status.ManifestLists = nil
+		return nil
+	}
+
+	current := map[string]packagev1alpha1.ManifestListStatus{}
+	for _, ml := range spkg.Status.ManifestLists {
+		current[ml.Name] = ml
+	}
+
+	o := buildOutput(spkg)
+	var failed []string
+	lists := []packagev1alpha1.ManifestListStatus{}
+	for _, env := range spkg.Spec.Environment {
+		ml, ok := current[*env.Name]
+		if !ok {
+			ml = packagev1alpha1.ManifestListStatus{Name: *env.Name}
+		}
+		tag := o.envTags(*env.Name, "")[0]
+		ml.Image = o.image(tag)
+
+		platforms, digests, ready := envPlatformImages(spkg, *env.Name)
+		if ready && (ml.Digest == "" || !equalStrings(ml.Manifests, digests)) {
+			digest, err := r.pushManifestList(ctx, spkg, o, tag, platforms, digests)
+			ml.LastUpdate = metav1.Now()
+			if err != nil {
+				r.Log.Error(err, "Failed to push the manifest list", "environment", *env.Name)
+				ml.Reason = err.Error()
+				failed = append(failed, *env.Name)
+			} else {
+				r.Log.Info("Manifest list pushed", "image", ml.Image, "digest", digest)
+				ml.Digest = digest
+				ml.Manifests = digests
+				ml.Reason = ""
+			}
+		}
+		lists = append(lists, ml)
+	}
+	spkg.Status.ManifestLists = lists
+
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to push the manifest lists of environments %s", s.Join(failed, ", "))
+	}
+	return nil
+}
+
+// pushManifestList pushes a manifest list referencing the images of every
+// platform to a tag of the output of spkg, in its registry repository
+func (r *BuildReconciler) pushManifestList(ctx context.Context, spkg *packagev1alpha1.Build, o *imageOutput, tag string,
+	platforms []buildPlatform, digests []string) (string, error) {
+
+	backend, err := r.backend(spkg)
+	if err != nil {
+		return "", err
+	}
+	repository, err := r.outputRepository(ctx, spkg, o, backend)
+	if err != nil {
+		return "", err
+	}
+	if repository == "" {
+		return "", fmt.Errorf("ImageStream %s/%s has no registry repository", o.Namespace, o.Name)
+	}
+	target, err := registry.ParseReference(withTag(repository, tag))
+	if err != nil {
+		return "", err
+	}
+	c, err := r.outputRegistry(ctx, spkg, o, backend, repository, target.Registry)
+	if err != nil {
+		return "", err
+	}
+
+	manifests := []registry.Descriptor{}
+	for i, digest := range digests {
+		ref, err := registry.ParseReference(repository + "@" + digest)
+		if err != nil {
+			return "", err
+		}
+		desc, err := c.Resolve(ctx, ref)
+		if err != nil {
+			return "", err
+		}
+		desc.Platform = &registry.Platform{
+			Architecture: string(platforms[i].Arch),
+			OS:           "linux",
+			Variant:      platforms[i].variant(),
+		}
+		manifests = append(manifests, desc)
+	}
+
+	index, err := c.PushIndex(ctx, target, manifests)
+	if err != nil {
+		return "", err
+	}
+	return index.Digest, nil
+}
+
+// envPlatformImages returns the platforms of an environment with the
+// digests of their images, and whether every platform has been built
+func envPlatformImages(spkg *packagev1alpha1.Build, name string) ([]buildPlatform, []string, bool) {
+	platforms := []buildPlatform{}
+	digests := []string{}
+	for _, env := range spkg.Status.Environments {
+		if env.Name != name {
+			continue
+		}
+		if env.State != packagev1alpha1.CompletedPackage || env.ImageDigest == "" {
+			return nil, nil, false
+		}
+		platforms = append(platforms, buildPlatform{Arch: env.Architecture, Target: env.Target})
+		digests = append(digests, env.ImageDigest)
+	}
+	return platforms, digests, len(digests) > 0
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
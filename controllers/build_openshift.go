@@ -0,0 +1,487 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	buildv1 "github.com/openshift/api/build/v1"
+	imagev1 "github.com/openshift/api/image/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	packagev1alpha1 "github.com/ArangoGutierrez/spack-operator/api/v1alpha1"
+)
+
+// openShiftBackend builds the images of the environments with OpenShift
+// BuildConfigs pushing to ImageStreams
+type openShiftBackend struct {
+	r *BuildReconciler
+}
+
+// repository returns the registry repository exposing an ImageStream
+func (b *openShiftBackend) repository(ctx context.Context, spkg *packagev1alpha1.Build, namespace, stream string) (string, error) {
+	return b.imageStreamRepository(ctx, namespace, stream)
+}
+
+// imageStreamRepository returns the registry repository exposing an
+// ImageStream, empty when the ImageStream does not exist or is not exposed
+// by a registry yet
+func (b *openShiftBackend) imageStreamRepository(ctx context.Context, namespace, stream string) (string, error) {
+	is := &imagev1.ImageStream{}
+	if err := b.r.Client.Get(ctx, types.NamespacedName{Namespace: namespace, Name: stream}, is); err != nil {
+		if errors.IsNotFound(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	return is.Status.DockerImageRepository, nil
+}
+
+// builderImage returns the ImageStreamTag of the base image, replaced by
+// the image of the Docker strategy. OpenShift only replaces the FROM of the
+// last stage, with a runtime stage the builder stage pulls the base image
+// from the registry.
+func (b *openShiftBackend) builderImage(ctx context.Context, spkg *packagev1alpha1.Build, base *corev1.ObjectReference) (string, error) {
+	if spkg.Spec.Runtime == nil {
+		return base.Name, nil
+	}
+	return b.pullSpec(ctx, spkg, base)
+}
+
+// pullSpec returns the reference of the base image in the registry
+// exposing its ImageStream, pinned to the digest its ImageStreamTag
+// resolves to. It fails until the tag is resolved: the bare ImageStreamTag
+// name would be pulled from another registry.
+func (b *openShiftBackend) pullSpec(ctx context.Context, spkg *packagev1alpha1.Build, base *corev1.ObjectReference) (string, error) {
+	namespace := base.Namespace
+	if namespace == "" {
+		namespace = spkg.Namespace
+	}
+	stream, tag := splitImageStreamTag(base.Name)
+	is := &imagev1.ImageStream{}
+	if err := b.r.Client.Get(ctx, types.NamespacedName{Namespace: namespace, Name: stream}, is); err != nil {
+		if errors.IsNotFound(err) {
+			return "", fmt.Errorf("ImageStream %s/%s not found", namespace, stream)
+		}
+		return "", err
+	}
+	if is.Status.DockerImageRepository == "" {
+		return "", fmt.Errorf("ImageStream %s/%s is not exposed by a registry yet", namespace, stream)
+	}
+	for _, t := range is.Status.Tags {
+		if t.Tag == tag && len(t.Items) > 0 && t.Items[0].Image != "" {
+			return is.Status.DockerImageRepository + "@" + t.Items[0].Image, nil
+		}
+	}
+	return "", fmt.Errorf("ImageStreamTag %s/%s does not resolve to an image yet", namespace, base.Name)
+}
+
+// syncImageStream ensures the ImageStream the images of a Build are pushed
+// to exists, with the policy of the Build. The ImageStream may be shared by
+// several Builds of its namespace, it is not owned by any of them: only the
+// tags produced by a Build are deleted along with it.
+func (b *openShiftBackend) syncImageStream(ctx context.Context, spkg *packagev1alpha1.Build) error {
+	o := buildOutput(spkg)
+	if o.Kind != packagev1alpha1.OutputImageStreamTag {
+		return nil
+	}
+	is := &imagev1.ImageStream{ObjectMeta: metav1.ObjectMeta{Name: o.Name, Namespace: o.Namespace}}
+	op, err := controllerutil.CreateOrUpdate(ctx, b.r.Client, is, func() error {
+		is.Labels = mergeLabels(is.Labels, map[string]string{"app": "spack-operator"})
+		if p := spkg.Spec.ImageStreamPolicy; p != nil {
+			applyImageStreamPolicy(is, p, producedTags(spkg, o))
+		}
+		return nil
+	})
+	if err != nil {
+		b.r.Log.Error(err, "Failed to reconcile the ImageStream", "imageStream", o.Namespace+"/"+o.Name)
+		return err
+	}
+	if op != controllerutil.OperationResultNone {
+		b.r.Log.Info("ImageStream reconciled", "imageStream", o.Namespace+"/"+o.Name, "operation", op)
+	}
+	return nil
+}
+
+// applyImageStreamPolicy sets the lookup policy of an ImageStream and the
+// reference and import policies of its tags. The tags pushed by the builds
+// are only in its status while the policies apply to the tags of its spec:
+// every tag produced by the Build gets a spec tag, following the image last
+// pushed to it, once pushed.
+func applyImageStreamPolicy(is *imagev1.ImageStream, p *packagev1alpha1.ImageStreamPolicy, produced []string) {
+	is.Spec.LookupPolicy.Local = p.LookupLocal
+	if p.ReferencePolicy != "" {
+		for _, name := range produced {
+			image := latestTagImage(is, name)
+			if image == "" {
+				continue
+			}
+			from := &corev1.ObjectReference{Kind: "ImageStreamImage", Name: is.Name + "@" + image}
+			found := false
+			for i := range is.Spec.Tags {
+				if tag := &is.Spec.Tags[i]; tag.Name == name {
+					found = true
+					if tag.From == nil || tag.From.Kind == "ImageStreamImage" {
+						tag.From = from
+					}
+				}
+			}
+			if !found {
+				is.Spec.Tags = append(is.Spec.Tags, imagev1.TagReference{Name: name, From: from})
+			}
+		}
+	}
+	for i := range is.Spec.Tags {
+		tag := &is.Spec.Tags[i]
+		if p.ReferencePolicy != "" {
+			tag.ReferencePolicy.Type = imagev1.TagReferencePolicyType(p.ReferencePolicy)
+		}
+		if tag.From != nil && tag.From.Kind == "DockerImage" {
+			tag.ImportPolicy.Scheduled = p.ScheduledImport
+		}
+	}
+}
+
+// latestTagImage returns the digest of the image last pushed to a tag of an
+// ImageStream, empty when none was
+func latestTagImage(is *imagev1.ImageStream, name string) string {
+	for _, tag := range is.Status.Tags {
+		if tag.Tag == name && len(tag.Items) > 0 {
+			return tag.Items[0].Image
+		}
+	}
+	return ""
+}
+
+// producedTags returns the tags of the output of a Build its builds and the
+// operator push to: the images of every environment and platform, and the
+// manifest lists and additional tags of every environment
+func producedTags(spkg *packagev1alpha1.Build, o *imageOutput) []string {
+	tags := []string{}
+	seen := map[string]bool{}
+	add := func(tag string) {
+		if !seen[tag] {
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+	for _, env := range spkg.Spec.Environment {
+		for _, p := range buildPlatforms(spkg) {
+			add(joinNonEmpty(o.Tags[0], *env.Name, p.suffix()))
+		}
+		for _, tag := range o.envTags(*env.Name, "") {
+			add(tag)
+		}
+	}
+	return tags
+}
+
+// setBaseImageCondition checks whether the Spack base image the environments
+// are built from has been pushed to its ImageStream
+func (b *openShiftBackend) setBaseImageCondition(ctx context.Context, spkg *packagev1alpha1.Build, base *corev1.ObjectReference) error {
+	namespace := base.Namespace
+	if namespace == "" {
+		namespace = spkg.Namespace
+	}
+	stream, baseTag := splitImageStreamTag(base.Name)
+	is := &imagev1.ImageStream{}
+	key := types.NamespacedName{Namespace: namespace, Name: stream}
+	if err := b.r.Client.Get(ctx, key, is); err != nil {
+		if !errors.IsNotFound(err) {
+			return err
+		}
+		setCondition(spkg, packagev1alpha1.ConditionBaseImageReady, metav1.ConditionFalse,
+			"ImageStreamNotFound", "ImageStream "+namespace+"/"+stream+" not found")
+		return nil
+	}
+
+	for _, tag := range is.Status.Tags {
+		if tag.Tag == baseTag && len(tag.Items) > 0 {
+			setCondition(spkg, packagev1alpha1.ConditionBaseImageReady, metav1.ConditionTrue,
+				"ImageAvailable", "base image "+namespace+"/"+base.Name+" is available")
+			return nil
+		}
+	}
+	setCondition(spkg, packagev1alpha1.ConditionBaseImageReady, metav1.ConditionFalse,
+		"TagNotFound", "base image "+namespace+"/"+base.Name+" has not been pushed")
+	return nil
+}
+
+// sync converges the BuildConfig of an environment
+func (b *openShiftBackend) sync(ctx context.Context, spkg *packagev1alpha1.Build, eb *envBuild, keep map[string]bool) error {
+	r := b.r
+	bc := &buildv1.BuildConfig{ObjectMeta: envObjectMeta(spkg, eb.Name)}
+	desired := envBuildConfig(spkg, eb)
+	op, err := controllerutil.CreateOrUpdate(ctx, r.Client, bc, func() error {
+		mutateBuildConfig(bc, desired)
+		return controllerutil.SetControllerReference(spkg, bc, r.Scheme)
+	})
+	if err != nil {
+		r.Log.Error(err, "Failed to reconcile the BuildConfig", "environment", *eb.Env.Name, "platform", eb.Platform.suffix())
+		return err
+	}
+	if op != controllerutil.OperationResultNone {
+		r.Log.Info("BuildConfig reconciled", "buildConfig", bc.Name, "operation", op)
+	}
+	keep["BuildConfig/"+bc.Name] = true
+	return nil
+}
+
+// validate checks that the BuildConfig of an environment exists
+func (b *openShiftBackend) validate(ctx context.Context, spkg *packagev1alpha1.Build, env *packagev1alpha1.EnvironmentStatus) (string, error) {
+	bc := &buildv1.BuildConfig{}
+	key := types.NamespacedName{Namespace: spkg.Namespace, Name: env.BuildConfig}
+	if err := b.r.Client.Get(ctx, key, bc); err != nil {
+		if !errors.IsNotFound(err) {
+			return "", err
+		}
+		return "BuildConfig " + env.BuildConfig + " not found", nil
+	}
+	return "", nil
+}
+
+// update tracks the OpenShift Builds spawned by the BuildConfig of an
+// environment, starting a new one whenever the BuildConfig spec changes
+func (b *openShiftBackend) update(ctx context.Context, spkg *packagev1alpha1.Build, env *packagev1alpha1.EnvironmentStatus) error {
+	r := b.r
+	bc := &buildv1.BuildConfig{}
+	key := types.NamespacedName{Namespace: spkg.Namespace, Name: env.BuildConfig}
+	if err := r.Client.Get(ctx, key, bc); err != nil {
+		if errors.IsNotFound(err) {
+			// the BuildConfig has just been created and is not cached yet
+			return nil
+		}
+		r.Log.Error(err, "Failed to get the BuildConfig", "buildConfig", env.BuildConfig)
+		return err
+	}
+
+	latest, err := latestBuild(ctx, r.Client, spkg.Namespace, env.BuildConfig)
+	if err != nil {
+		r.Log.Error(err, "Failed to list the builds", "buildConfig", env.BuildConfig)
+		return err
+	}
+
+	// start a new build whenever the BuildConfig spec changes, unless the
+	// latest build was already started for it
+	hash := bc.Annotations[specHashAnnotation]
+	if hash != env.SpecHash && (latest == nil || latest.Annotations[specHashAnnotation] != hash) {
+		r.Log.Info("Starting a new build", "buildConfig", bc.Name, "generation", spkg.Generation)
+		latest, err = b.instantiateBuild(ctx, bc, spkg.Generation)
+		if err != nil {
+			r.Log.Error(err, "Failed to start a new build", "buildConfig", bc.Name)
+			return err
+		}
+		env.History = recordBuild(env.History, packagev1alpha1.BuildRecord{
+			Generation: spkg.Generation,
+			Build:      latest.Name,
+		})
+	}
+	env.SpecHash = hash
+	if latest == nil {
+		return nil
+	}
+	if g, err := strconv.ParseInt(latest.Annotations[generationAnnotation], 10, 64); err == nil {
+		env.ObservedGeneration = g
+	}
+
+	digest := ""
+	if latest.Status.Output.To != nil {
+		digest = latest.Status.Output.To.ImageDigest
+	}
+	recordLatestBuild(env, latest.Name, buildPhaseStatus(latest.Status.Phase), buildReason(latest), digest,
+		latest.Status.StartTimestamp, latest.Status.CompletionTimestamp)
+	return nil
+}
+
+// prune deletes the BuildConfigs of a Build not listed in keep, along with
+// the OpenShift Builds they own
+func (b *openShiftBackend) prune(ctx context.Context, spkg *packagev1alpha1.Build, keep map[string]bool) error {
+	bcs := &buildv1.BuildConfigList{}
+	opts := []client.ListOption{
+		client.InNamespace(spkg.Namespace),
+		client.MatchingLabels{buildLabel: spkg.Name},
+	}
+	if err := b.r.Client.List(ctx, bcs, opts...); err != nil {
+		return err
+	}
+	for i := range bcs.Items {
+		if keep["BuildConfig/"+bcs.Items[i].Name] {
+			continue
+		}
+		b.r.Log.Info("Deleting stale BuildConfig", "buildConfig", bcs.Items[i].Name)
+		if err := b.r.Client.Delete(ctx, &bcs.Items[i], client.PropagationPolicy(metav1.DeletePropagationBackground)); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteImages removes the tags pushed for every environment, ImageStreams
+// are not owned by the Build so their tags are not garbage collected. The
+// images pushed to a registry are left there.
+func (b *openShiftBackend) deleteImages(ctx context.Context, spkg *packagev1alpha1.Build) error {
+	o := buildOutput(spkg)
+	if o.Kind != packagev1alpha1.OutputImageStreamTag || spkg.Spec.Install != nil {
+		return nil
+	}
+	if p := spkg.Spec.ImageStreamPolicy; p != nil && p.TagRetention == packagev1alpha1.TagRetentionRetain {
+		b.r.Log.Info("Retaining the ImageStreamTags of the Build", "imageStream", o.Namespace+"/"+o.Name)
+		return nil
+	}
+	for _, tag := range producedTags(spkg, o) {
+		ist := &imagev1.ImageStreamTag{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      o.Name + ":" + tag,
+				Namespace: o.Namespace,
+			},
+		}
+		if err := b.r.Client.Delete(ctx, ist); err != nil && !errors.IsNotFound(err) {
+			b.r.Log.Error(err, "Failed to delete the ImageStreamTag", "imageStreamTag", o.image(tag))
+			return err
+		}
+	}
+	return nil
+}
+
+// instantiateBuild starts a new OpenShift Build from a BuildConfig, annotated
+// with the spec hash and the Build generation it was started for
+func (b *openShiftBackend) instantiateBuild(ctx context.Context, bc *buildv1.BuildConfig, generation int64) (*buildv1.Build, error) {
+	req := &buildv1.BuildRequest{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: bc.Name,
+			Annotations: map[string]string{
+				specHashAnnotation:   bc.Annotations[specHashAnnotation],
+				generationAnnotation: strconv.FormatInt(generation, 10),
+			},
+		},
+		TriggeredBy: []buildv1.BuildTriggerCause{{
+			Message: "Build spec changed",
+		}},
+	}
+
+	build := &buildv1.Build{}
+	err := b.r.BuildClient.Post().
+		Namespace(bc.Namespace).
+		Resource("buildconfigs").
+		Name(bc.Name).
+		SubResource("instantiate").
+		Body(req).
+		Do(ctx).
+		Into(build)
+	return build, err
+}
+
+// envBuildConfig returns the buildConfig producing the image of a Spack
+// environment for a platform
+func envBuildConfig(spkg *packagev1alpha1.Build, eb *envBuild) *buildv1.BuildConfig {
+	baseBuildRecipe := new(string)
+	*baseBuildRecipe = eb.Dockerfile
+
+	// the image of the strategy replaces the FROM of the last stage
+	from := eb.Base
+	if spkg.Spec.Runtime != nil {
+		from = &corev1.ObjectReference{Kind: "DockerImage", Name: runtimeImage(spkg)}
+	}
+
+	// configMapBuildSource
+	// DestinationDir set to default (same context as the Dockerfile)
+	cmbs := buildv1.ConfigMapBuildSource{
+		ConfigMap: corev1.LocalObjectReference{
+			Name: eb.ConfigMap,
+		},
+	}
+
+	logic := buildv1.ConfigMapBuildSource{
+		ConfigMap: corev1.LocalObjectReference{
+			Name: eb.BuildLogic,
+		},
+	}
+
+	bc := &buildv1.BuildConfig{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      eb.Name,
+			Namespace: spkg.Namespace,
+			Labels:    map[string]string{buildLabel: spkg.Name},
+		},
+		Spec: buildv1.BuildConfigSpec{
+			RunPolicy:                    buildv1.BuildRunPolicy(spkg.Spec.RunPolicy),
+			SuccessfulBuildsHistoryLimit: spkg.Spec.SuccessfulBuildsHistoryLimit,
+			FailedBuildsHistoryLimit:     spkg.Spec.FailedBuildsHistoryLimit,
+			CommonSpec: buildv1.CommonSpec{
+				Strategy: buildv1.BuildStrategy{
+					Type: "Docker",
+					DockerStrategy: &buildv1.DockerBuildStrategy{
+						From:      from,
+						BuildArgs: mirrorBuildArgs(spkg.Spec.BinaryMirror),
+					},
+				},
+				Source: buildv1.BuildSource{
+					Type:       "Dockerfile",
+					Dockerfile: baseBuildRecipe,
+					ConfigMaps: []buildv1.ConfigMapBuildSource{cmbs, logic},
+					Secrets:    signingKeySources(spkg.Spec.BinaryMirror),
+				},
+				Output: buildv1.BuildOutput{
+					To:          eb.Output.objectReference(eb.Tag),
+					PushSecret:  eb.Output.PushSecret,
+					ImageLabels: envImageLabels(spkg, eb.Env, eb.Platform),
+				},
+				NodeSelector: eb.Platform.nodeSelector(),
+			},
+		},
+	}
+	bc.Annotations = map[string]string{specHashAnnotation: hashOf(bc.Spec.CommonSpec)}
+	return bc
+}
+
+// mutateBuildConfig copies the fields managed by the operator from the desired
+// buildConfig, leaving the ones defaulted by OpenShift untouched
+func mutateBuildConfig(bc, desired *buildv1.BuildConfig) {
+	bc.Labels = mergeLabels(bc.Labels, desired.Labels)
+	bc.Spec.RunPolicy = desired.Spec.RunPolicy
+	bc.Spec.SuccessfulBuildsHistoryLimit = desired.Spec.SuccessfulBuildsHistoryLimit
+	bc.Spec.FailedBuildsHistoryLimit = desired.Spec.FailedBuildsHistoryLimit
+	// builds are started by the operator whenever the spec hash changes
+	bc.Spec.Triggers = desired.Spec.Triggers
+	if bc.Annotations == nil {
+		bc.Annotations = map[string]string{}
+	}
+	bc.Annotations[specHashAnnotation] = desired.Annotations[specHashAnnotation]
+
+	bc.Spec.Strategy.Type = desired.Spec.Strategy.Type
+	if bc.Spec.Strategy.DockerStrategy == nil {
+		bc.Spec.Strategy.DockerStrategy = &buildv1.DockerBuildStrategy{}
+	}
+	bc.Spec.Strategy.DockerStrategy.From = desired.Spec.Strategy.DockerStrategy.From
+	bc.Spec.Strategy.DockerStrategy.BuildArgs = desired.Spec.Strategy.DockerStrategy.BuildArgs
+
+	bc.Spec.Source.Type = desired.Spec.Source.Type
+	bc.Spec.Source.Dockerfile = desired.Spec.Source.Dockerfile
+	bc.Spec.Source.ConfigMaps = desired.Spec.Source.ConfigMaps
+	bc.Spec.Source.Secrets = desired.Spec.Source.Secrets
+
+	bc.Spec.Output.To = desired.Spec.Output.To
+	bc.Spec.Output.ImageLabels = desired.Spec.Output.ImageLabels
+	bc.Spec.NodeSelector = desired.Spec.NodeSelector
+}
@@ -0,0 +1,120 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	imagev1 "github.com/openshift/api/image/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	packagev1alpha1 "github.com/ArangoGutierrez/spack-operator/api/v1alpha1"
+)
+
+func TestOpenShiftPullSpec(t *testing.T) {
+	const digest = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	base := &corev1.ObjectReference{Kind: "ImageStreamTag", Namespace: "spack-operator", Name: "spack:v0.16.0-fedora"}
+	stream := func(repository string, tags ...imagev1.NamedTagEventList) *imagev1.ImageStream {
+		return &imagev1.ImageStream{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "spack-operator", Name: "spack"},
+			Status:     imagev1.ImageStreamStatus{DockerImageRepository: repository, Tags: tags},
+		}
+	}
+	resolved := imagev1.NamedTagEventList{Tag: "v0.16.0-fedora", Items: []imagev1.TagEvent{{Image: digest}}}
+
+	tests := []struct {
+		name  string
+		is    *imagev1.ImageStream
+		image string
+	}{
+		{"no ImageStream", nil, ""},
+		{"not exposed", stream("", resolved), ""},
+		{"tag not resolved", stream("registry/spack-operator/spack", imagev1.NamedTagEventList{Tag: "v0.16.0-fedora"}), ""},
+		{"resolved", stream("registry/spack-operator/spack", resolved), "registry/spack-operator/spack@" + digest},
+	}
+	for _, tt := range tests {
+		r := newTestReconciler(t)
+		if tt.is != nil {
+			r = newTestReconciler(t, tt.is)
+		}
+		image, err := (&openShiftBackend{r: r}).pullSpec(context.Background(), testBuild(), base)
+		if tt.image == "" && err == nil {
+			t.Errorf("%s: got image %s, want an error", tt.name, image)
+		}
+		if tt.image != "" && (err != nil || image != tt.image) {
+			t.Errorf("%s: got image %s (%v), want %s", tt.name, image, err, tt.image)
+		}
+	}
+}
+
+func TestApplyImageStreamPolicy(t *testing.T) {
+	const (
+		first  = "sha256:1111111111111111111111111111111111111111111111111111111111111111"
+		second = "sha256:2222222222222222222222222222222222222222222222222222222222222222"
+	)
+	spkg := testBuild()
+	spkg.Default()
+	spkg.Spec.ImageStreamPolicy = &packagev1alpha1.ImageStreamPolicy{
+		LookupLocal:     true,
+		ReferencePolicy: packagev1alpha1.TagReferenceLocal,
+		ScheduledImport: true,
+	}
+	produced := producedTags(spkg, buildOutput(spkg))
+	if len(produced) != 1 || produced[0] != "v1-mpi" {
+		t.Fatalf("got produced tags %v", produced)
+	}
+
+	is := &imagev1.ImageStream{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "builds", Name: "stack"},
+		Spec: imagev1.ImageStreamSpec{Tags: []imagev1.TagReference{{
+			Name: "upstream",
+			From: &corev1.ObjectReference{Kind: "DockerImage", Name: "quay.io/spack/stack:latest"},
+		}}},
+	}
+	// nothing has been pushed yet
+	applyImageStreamPolicy(is, spkg.Spec.ImageStreamPolicy, produced)
+	if !is.Spec.LookupPolicy.Local || len(is.Spec.Tags) != 1 {
+		t.Fatalf("got spec %+v", is.Spec)
+	}
+	if !is.Spec.Tags[0].ImportPolicy.Scheduled || is.Spec.Tags[0].ReferencePolicy.Type != imagev1.LocalTagReferencePolicy {
+		t.Errorf("the policy was not applied to the imported tag: %+v", is.Spec.Tags[0])
+	}
+
+	pushed := func(images ...string) {
+		items := []imagev1.TagEvent{}
+		for _, image := range images {
+			items = append(items, imagev1.TagEvent{Image: image})
+		}
+		is.Status.Tags = []imagev1.NamedTagEventList{{Tag: "v1-mpi", Items: items}}
+		applyImageStreamPolicy(is, spkg.Spec.ImageStreamPolicy, produced)
+	}
+	for _, images := range [][]string{{first}, {second, first}} {
+		pushed(images...)
+		if len(is.Spec.Tags) != 2 {
+			t.Fatalf("got spec tags %+v", is.Spec.Tags)
+		}
+		tag := is.Spec.Tags[1]
+		if tag.Name != "v1-mpi" || tag.From == nil || tag.From.Kind != "ImageStreamImage" || tag.From.Name != "stack@"+images[0] {
+			t.Errorf("the pushed tag does not follow its last image %s: %+v", images[0], tag)
+		}
+		if tag.ReferencePolicy.Type != imagev1.LocalTagReferencePolicy {
+			t.Errorf("the reference policy was not applied to the pushed tag: %+v", tag)
+		}
+	}
+}
@@ -0,0 +1,305 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	s "strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	packagev1alpha1 "github.com/ArangoGutierrez/spack-operator/api/v1alpha1"
+	"github.com/ArangoGutierrez/spack-operator/pkg/registry"
+)
+
+// imageOutput is where the images of the environments of a Build are
+// pushed, given by its Output or by its ImageStream
+type imageOutput struct {
+	// Kind is ImageStreamTag or DockerImage
+	Kind string
+	// Namespace of the ImageStream, empty for a DockerImage
+	Namespace string
+	// Name of the ImageStream, the registry repository of a DockerImage
+	Name string
+	// Tags the images are pushed to, the builds push to the first one and
+	// the operator tags the images with the others
+	Tags []string
+	// PushSecret authenticates against the registry of the output, the
+	// one of the backend is used when nil
+	PushSecret *corev1.LocalObjectReference
+}
+
+// buildOutput returns the output of the images of spkg. The ImageStreams
+// are always the ones of the namespace of the Build, see outputNamespaceError.
+func buildOutput(spkg *packagev1alpha1.Build) *imageOutput {
+	o := &imageOutput{
+		Kind:      packagev1alpha1.OutputImageStreamTag,
+		Namespace: spkg.Namespace,
+	}
+	to, additional := spkg.Spec.ImageStream, []string(nil)
+	if out := spkg.Spec.Output; out != nil {
+		to, additional = out.To.Name, out.AdditionalTags
+		o.PushSecret = out.PushSecret
+		if out.To.Kind == packagev1alpha1.OutputDockerImage {
+			o.Kind, o.Namespace = out.To.Kind, ""
+		}
+	}
+	name, tag := splitImageStreamTag(to)
+	o.Name = name
+	o.Tags = append([]string{tag}, additional...)
+	return o
+}
+
+// envTags returns the tags of the image of a Spack environment for a
+// platform, the platform is empty for the tags of its manifest list. Every
+// environment gets its own tags, derived from the tags of the output
+// suffixed by the environment name and the platform (e.g. "stack:v1"
+// becomes "stack:v1-mpi-arm64"), or without the tag of the output when it
+// does not have one.
+func (o *imageOutput) envTags(env, platform string) []string {
+	tags := []string{}
+	for _, tag := range o.Tags {
+		tags = append(tags, joinNonEmpty(tag, env, platform))
+	}
+	return tags
+}
+
+// image returns the reference of a tag of the output
+func (o *imageOutput) image(tag string) string {
+	return o.Name + ":" + tag
+}
+
+// objectReference returns the reference of a tag of the output in the
+// output of a BuildConfig
+func (o *imageOutput) objectReference(tag string) *corev1.ObjectReference {
+	return &corev1.ObjectReference{Kind: o.Kind, Name: o.image(tag)}
+}
+
+// outputNamespaceError returns the error of a Build pushing to the
+// ImageStreams of another namespace, which the operator does not manage on
+// behalf of the authors of the Builds. They are rejected by the validating
+// webhook, unless it was not deployed.
+func outputNamespaceError(spkg *packagev1alpha1.Build) error {
+	if out := spkg.Spec.Output; out != nil && out.To.Kind == packagev1alpha1.OutputImageStreamTag &&
+		out.To.Namespace != "" && out.To.Namespace != spkg.Namespace {
+		return fmt.Errorf("the images can only be pushed to the namespace of the Build, not to %s", out.To.Namespace)
+	}
+	return nil
+}
+
+// outputRepository returns the registry repository of an output, empty when
+// its ImageStream is not exposed by a registry yet
+func (r *BuildReconciler) outputRepository(ctx context.Context, spkg *packagev1alpha1.Build, o *imageOutput, backend buildBackend) (string, error) {
+	if o.Kind == packagev1alpha1.OutputDockerImage {
+		return o.Name, nil
+	}
+	return backend.repository(ctx, spkg, o.Namespace, o.Name)
+}
+
+// clusterRepository returns true when repository, the registry repository
+// of an output, is the one of its ImageStream in the image registry of the
+// cluster. The operator token only authenticates against those, never
+// against a registry named by the Build.
+func (r *BuildReconciler) clusterRepository(o *imageOutput, backend buildBackend, repository string) bool {
+	if o.Kind != packagev1alpha1.OutputImageStreamTag {
+		return false
+	}
+	if b, ok := backend.(*kubernetesBackend); ok {
+		internal, err := b.registryRepository(o.Namespace, o.Name)
+		return err == nil && repository == internal
+	}
+	// the OpenShift backend pushes to the repository of the ImageStream
+	return true
+}
+
+// outputPushSecret returns the Secret authenticating the pushes to an output,
+// the push Secret of the Kubernetes backend when the output has none
+func outputPushSecret(spkg *packagev1alpha1.Build, o *imageOutput, backend buildBackend) *corev1.LocalObjectReference {
+	if o.PushSecret != nil {
+		return o.PushSecret
+	}
+	if _, ok := backend.(*kubernetesBackend); ok && spkg.Spec.Kubernetes != nil {
+		return spkg.Spec.Kubernetes.PushSecret
+	}
+	return nil
+}
+
+// outputRegistry returns the registry client pushing to repository, the
+// registry repository of an output on host. The operator token is only kept
+// for the repositories of the image registry of the cluster: the other
+// registries are authenticated with the push Secret of the Build,
+// anonymously without one.
+func (r *BuildReconciler) outputRegistry(ctx context.Context, spkg *packagev1alpha1.Build, o *imageOutput, backend buildBackend,
+	repository, host string) (*registry.Client, error) {
+
+	if r.Registry == nil {
+		return nil, fmt.Errorf("no registry client is configured")
+	}
+	c := *r.Registry
+	if !r.clusterRepository(o, backend, repository) {
+		c.Credentials = nil
+	}
+	ref := outputPushSecret(spkg, o, backend)
+	if ref == nil {
+		return &c, nil
+	}
+	secret := &corev1.Secret{}
+	key := types.NamespacedName{Namespace: spkg.Namespace, Name: ref.Name}
+	if err := r.Client.Get(ctx, key, secret); err != nil {
+		return nil, err
+	}
+	user, pass, err := registry.DockerConfigCredentials(secret.Data[corev1.DockerConfigJsonKey], host)
+	if err != nil {
+		return nil, fmt.Errorf("push Secret %s: %v", ref.Name, err)
+	}
+	if user != "" || pass != "" {
+		c.Credentials = func() (string, string, error) { return user, pass, nil }
+	}
+	return &c, nil
+}
+
+// pushOutputs tags the final image of every environment whose images have
+// all been pushed, the manifest list when the Build lists platforms, with
+// the additional tags of the output. The outcome is recorded in the Outputs
+// status of spkg, the returned error reports the environments that could
+// not be tagged.
+func (r *BuildReconciler) pushOutputs(ctx context.Context, spkg *packagev1alpha1.Build) error {
+	// the installs onto a claim do not push any image
+	if spkg.Spec.Install != nil {
+		spkg.Status.Outputs = nil
+		return nil
+	}
+	o := buildOutput(spkg)
+
+	current := map[string]packagev1alpha1.OutputStatus{}
+	for _, out := range spkg.Status.Outputs {
+		current[out.Name] = out
+	}
+
+	var failed []string
+	outputs := []packagev1alpha1.OutputStatus{}
+	for _, env := range spkg.Spec.Environment {
+		out, ok := current[*env.Name]
+		if !ok {
+			out = packagev1alpha1.OutputStatus{Name: *env.Name}
+		}
+		tags := o.envTags(*env.Name, "")
+		images := []string{}
+		for _, tag := range tags {
+			images = append(images, o.image(tag))
+		}
+
+		digest := envImageDigest(spkg, *env.Name)
+		if digest != "" && (out.Digest != digest || !equalStrings(out.Images, images)) {
+			err := r.tagOutput(ctx, spkg, o, tags, digest)
+			out.LastUpdate = metav1.Now()
+			if err != nil {
+				r.Log.Error(err, "Failed to tag the output image", "environment", *env.Name)
+				out.Reason = err.Error()
+				failed = append(failed, *env.Name)
+			} else {
+				r.Log.Info("Output image tagged", "images", images, "digest", digest)
+				out.Digest = digest
+				out.Reason = ""
+			}
+		}
+		out.Images = images
+		outputs = append(outputs, out)
+	}
+	spkg.Status.Outputs = outputs
+
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to tag the output images of environments %s", s.Join(failed, ", "))
+	}
+	return nil
+}
+
+// tagOutput pushes the image digest, already pushed to the first tag, to
+// the other tags of an output
+func (r *BuildReconciler) tagOutput(ctx context.Context, spkg *packagev1alpha1.Build, o *imageOutput, tags []string, digest string) error {
+	if len(tags) < 2 {
+		return nil
+	}
+	if r.Registry == nil {
+		return fmt.Errorf("no registry client is configured to push the additional tags")
+	}
+	backend, err := r.backend(spkg)
+	if err != nil {
+		return err
+	}
+	repository, err := r.outputRepository(ctx, spkg, o, backend)
+	if err != nil {
+		return err
+	}
+	if repository == "" {
+		return fmt.Errorf("ImageStream %s/%s has no registry repository", o.Namespace, o.Name)
+	}
+	src, err := registry.ParseReference(repository + "@" + digest)
+	if err != nil {
+		return err
+	}
+	c, err := r.outputRegistry(ctx, spkg, o, backend, repository, src.Registry)
+	if err != nil {
+		return err
+	}
+	for _, tag := range tags[1:] {
+		if _, err := c.Tag(ctx, src, tag); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// envImageDigest returns the digest of the final image of an environment,
+// its manifest list when the Build lists platforms, empty until every
+// image it references has been pushed
+func envImageDigest(spkg *packagev1alpha1.Build, name string) string {
+	_, digests, ready := envPlatformImages(spkg, name)
+	if !ready {
+		return ""
+	}
+	if !isMultiPlatform(spkg) {
+		return digests[0]
+	}
+	for _, ml := range spkg.Status.ManifestLists {
+		if ml.Name == name && ml.Digest != "" && equalStrings(ml.Manifests, digests) {
+			return ml.Digest
+		}
+	}
+	return ""
+}
+
+// outputsPushed returns true when every tag of the output of every
+// environment points to its final image
+func outputsPushed(spkg *packagev1alpha1.Build) bool {
+	for _, env := range spkg.Spec.Environment {
+		digest := envImageDigest(spkg, *env.Name)
+		found := false
+		for _, out := range spkg.Status.Outputs {
+			if out.Name == *env.Name && digest != "" && out.Digest == digest {
+				found = true
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
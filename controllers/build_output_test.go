@@ -0,0 +1,89 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	packagev1alpha1 "github.com/ArangoGutierrez/spack-operator/api/v1alpha1"
+	"github.com/ArangoGutierrez/spack-operator/pkg/registry"
+)
+
+func TestOutputRegistryCredentials(t *testing.T) {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "builds", Name: "push"},
+		Type:       corev1.SecretTypeDockerConfigJson,
+		Data: map[string][]byte{
+			corev1.DockerConfigJsonKey: []byte(`{"auths":{"quay.io":{"username":"robot","password":"secret"}}}`),
+		},
+	}
+	r := newTestReconciler(t, secret)
+	r.ImageRegistry = "registry.cluster.local"
+	r.Registry = &registry.Client{Credentials: func() (string, string, error) { return "serviceaccount", "token", nil }}
+	ctx := context.Background()
+
+	stream := &imageOutput{Kind: packagev1alpha1.OutputImageStreamTag, Namespace: "builds", Name: "stack"}
+	docker := &imageOutput{Kind: packagev1alpha1.OutputDockerImage, Name: "quay.io/org/stack"}
+	withSecret := &imageOutput{Kind: packagev1alpha1.OutputDockerImage, Name: "quay.io/org/stack",
+		PushSecret: &corev1.LocalObjectReference{Name: "push"}}
+	kubernetes := &kubernetesBackend{r: r}
+	spkg := testBuild()
+	spkg.Spec.Backend = packagev1alpha1.BackendKubernetes
+	spkg.Spec.Kubernetes = &packagev1alpha1.KubernetesBuildSpec{
+		Registry:   "quay.io/org",
+		PushSecret: &corev1.LocalObjectReference{Name: "push"},
+	}
+
+	for _, tc := range []struct {
+		name       string
+		o          *imageOutput
+		backend    buildBackend
+		repository string
+		user       string
+	}{
+		{"OpenShift ImageStream", stream, &openShiftBackend{r: r}, "image-registry.svc:5000/builds/stack", "serviceaccount"},
+		{"registry of the operator", stream, kubernetes, "registry.cluster.local/builds/stack", "serviceaccount"},
+		{"registry of the Build", stream, kubernetes, "attacker.example.com/stack", ""},
+		{"registry of the Build with push Secret", stream, kubernetes, "quay.io/org/stack", "robot"},
+		{"DockerImage", docker, &openShiftBackend{r: r}, "quay.io/org/stack", ""},
+		{"DockerImage with push Secret", withSecret, kubernetes, "quay.io/org/stack", "robot"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ref, err := registry.ParseReference(tc.repository + ":latest")
+			if err != nil {
+				t.Fatal(err)
+			}
+			c, err := r.outputRegistry(ctx, spkg, tc.o, tc.backend, tc.repository, ref.Registry)
+			if err != nil {
+				t.Fatal(err)
+			}
+			user := ""
+			if c.Credentials != nil {
+				if user, _, err = c.Credentials(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if user != tc.user {
+				t.Errorf("pushing to %s authenticates as %q, want %q", tc.repository, user, tc.user)
+			}
+		})
+	}
+}
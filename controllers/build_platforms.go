@@ -0,0 +1,118 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	s "strings"
+
+	packagev1alpha1 "github.com/ArangoGutierrez/spack-operator/api/v1alpha1"
+	"github.com/ArangoGutierrez/spack-operator/pkg/archspec"
+	buildv1 "github.com/openshift/api/build/v1"
+)
+
+// buildPlatform is the architecture, and optionally the archspec
+// microarchitecture, an environment is built for
+type buildPlatform struct {
+	Arch   packagev1alpha1.Architecture
+	Target string
+}
+
+// buildPlatforms returns the platforms the environments are built for: one
+// per target when the BuildSpec lists targets, one per architecture otherwise,
+// or a single empty platform building on any node when it lists neither
+func buildPlatforms(spkg *packagev1alpha1.Build) []buildPlatform {
+	platforms := []buildPlatform{}
+	switch {
+	case len(spkg.Spec.Targets) > 0:
+		for _, target := range spkg.Spec.Targets {
+			m, _ := archspec.Lookup(target)
+			platforms = append(platforms, buildPlatform{
+				Arch:   packagev1alpha1.Architecture(m.Architecture),
+				Target: target,
+			})
+		}
+	case len(spkg.Spec.Architectures) > 0:
+		for _, arch := range spkg.Spec.Architectures {
+			platforms = append(platforms, buildPlatform{Arch: arch})
+		}
+	default:
+		platforms = append(platforms, buildPlatform{})
+	}
+	return platforms
+}
+
+// isMultiPlatform returns true when the environments are built for explicit
+// platforms, whose images are then gathered in manifest lists
+func isMultiPlatform(spkg *packagev1alpha1.Build) bool {
+	return len(spkg.Spec.Architectures) > 0 || len(spkg.Spec.Targets) > 0
+}
+
+// suffix identifies the platform in image tags
+func (p buildPlatform) suffix() string {
+	if p.Target != "" {
+		return p.Target
+	}
+	return string(p.Arch)
+}
+
+// nameSuffix identifies the platform in resource names
+func (p buildPlatform) nameSuffix() string {
+	if p.Target != "" {
+		return dnsTarget(p.Target)
+	}
+	return string(p.Arch)
+}
+
+// dnsTarget returns a microarchitecture name usable in resource names,
+// which do not allow the underscores found in microarchitecture names
+func dnsTarget(target string) string {
+	return s.ToLower(s.ReplaceAll(target, "_", "-"))
+}
+
+// nodeSelector pins the builds of the platform to nodes of its architecture
+// able to run binaries built for its microarchitecture
+func (p buildPlatform) nodeSelector() buildv1.OptionalNodeSelector {
+	if p.Arch == "" && p.Target == "" {
+		return nil
+	}
+	selector := buildv1.OptionalNodeSelector{}
+	if p.Arch != "" {
+		selector[archLabel] = string(p.Arch)
+	}
+	if p.Target != "" {
+		selector[archspec.CompatibleLabel(p.Target)] = "true"
+	}
+	return selector
+}
+
+// imageLabels describe the platform of the images built for it
+func (p buildPlatform) imageLabels() []buildv1.ImageLabel {
+	labels := []buildv1.ImageLabel{}
+	if p.Arch != "" {
+		labels = append(labels, buildv1.ImageLabel{Name: "architecture", Value: string(p.Arch)})
+	}
+	if p.Target != "" {
+		labels = append(labels, buildv1.ImageLabel{Name: archspec.TargetLabel, Value: p.Target})
+	}
+	return labels
+}
+
+// variant returns the OCI platform variant of the images built for the
+// platform, see archspec.Variant
+func (p buildPlatform) variant() string {
+	return archspec.Variant(p.Target)
+}
@@ -18,142 +18,541 @@ package controllers
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"time"
 
 	s "strings"
 
 	packagev1alpha1 "github.com/ArangoGutierrez/spack-operator/api/v1alpha1"
+	"github.com/ArangoGutierrez/spack-operator/pkg/controller/multiarch-builder/components"
 	buildv1 "github.com/openshift/api/build/v1"
+	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	// spackEnvFile is the key under which the spack.yaml of an
+	// environment is stored in its ConfigMap
+	spackEnvFile = "spack.yaml"
+
+	// buildLabel is the label holding the name of the Build CR that
+	// a resource belongs to. OpenShift Builds inherit it from their BuildConfig.
+	buildLabel = "multiarch.builder.io/build"
+
+	// buildFinalizer lets the operator remove the resources of a Build
+	// that cannot be garbage collected through owner references, such as
+	// the tags pushed to ImageStreams
+	buildFinalizer = "multiarch.builder.io/finalizer"
+
+	// specHashAnnotation holds the hash of the BuildConfig spec, a new
+	// build is started whenever it changes
+	specHashAnnotation = "multiarch.builder.io/spec-hash"
+
+	// generationAnnotation holds the generation of the Build CR an
+	// OpenShift Build was started for
+	generationAnnotation = "multiarch.builder.io/generation"
+
+	// buildHistoryLimit is the number of builds recorded in the status of
+	// every environment
+	buildHistoryLimit = 3
+
+	// archLabel is the node label holding the CPU architecture of a node
+	archLabel = "kubernetes.io/arch"
+
+	// spackInstallTree is where Spack installs the packages in the base
+	// images, the view of the environments links into it
+	spackInstallTree = "/opt/spack/opt/spack"
+
+	// runtimeProfileScript activates the environment in the login shells
+	// of the runtime images
+	runtimeProfileScript = "/etc/profile.d/z10_spack_environment.sh"
 )
 
 func (r *BuildReconciler) createBuild(ctx context.Context, spkg *packagev1alpha1.Build) (ctrl.Result, error) {
 
 	r.Log.Info("Creating package build", "package", spkg.Name)
 	tmp := spkg.DeepCopy()
-	// Create a configMap from the Spack environment on the CR
+	tmp.Status.Environments = syncEnvironments(tmp)
 
-	baseBuildRecipe := new(string)
-	*baseBuildRecipe = `
-FROM spack-operator-base:spackv0.16.0 as builder
+	//update the build status
+	opts := []client.UpdateOption{}
+	tmp.Status.State = packagev1alpha1.InitializedStatus
+	setCondition(tmp, packagev1alpha1.ConditionEnvironmentValid, metav1.ConditionTrue,
+		"ResourcesCreated", "the resources of all the environments have been created")
+	setCondition(tmp, packagev1alpha1.ConditionReady, metav1.ConditionFalse,
+		"Initializing", "the environments have not been built yet")
+	if err := r.Client.Status().Update(ctx, tmp, opts...); err != nil {
+		r.Log.Error(err, "status update failed")
+		return ctrl.Result{}, err
+	}
+
+	objKey := types.NamespacedName{
+		Namespace: tmp.Namespace,
+		Name:      tmp.Name,
+	}
+	if err := r.Client.Get(ctx, objKey, tmp); err != nil {
+		r.Log.Error(err, "status update failed to refresh object")
+		return ctrl.Result{}, err
+	}
+
+	return ctrl.Result{Requeue: true, RequeueAfter: 3 * time.Second}, nil
+}
+
+// recordSyncFailure records in the status of spkg, through report, why its
+// resources could not be converged and returns err
+func (r *BuildReconciler) recordSyncFailure(ctx context.Context, spkg *packagev1alpha1.Build, err error,
+	report func(*packagev1alpha1.Build)) error {
+	tmp := spkg.DeepCopy()
+	report(tmp)
+	if equality.Semantic.DeepEqual(tmp.Status, spkg.Status) {
+		return err
+	}
+	tmp.Status.LastUpdate = metav1.Now()
+	if uerr := r.Client.Status().Update(ctx, tmp); uerr != nil {
+		r.Log.Error(uerr, "status update failed")
+	}
+	return err
+}
+
+// erroredBuild reports a Build that cannot progress until its spec is
+// changed, for recordSyncFailure
+func erroredBuild(reason string, err error) func(*packagev1alpha1.Build) {
+	return func(tmp *packagev1alpha1.Build) {
+		tmp.Status.State = packagev1alpha1.ErroredPackage
+		tmp.Status.Reason = err.Error()
+		setCondition(tmp, packagev1alpha1.ConditionEnvironmentValid, metav1.ConditionFalse, reason, err.Error())
+		setCondition(tmp, packagev1alpha1.ConditionReady, metav1.ConditionFalse, reason, err.Error())
+	}
+}
 
-COPY ./spack.yaml /opt/spack-environment
-COPY ./build.sh /usr/bin
-RUN chmod a+x /usr/bin/build.sh
-RUN mkdir -p /opt/view
+// syncResources converges the configMap and the build resources of every Spack
+// environment on the CR to their desired state, creating the missing ones,
+// repairing any drift and removing the ones of environments no longer listed
+func (r *BuildReconciler) syncResources(ctx context.Context, spkg *packagev1alpha1.Build) error {
 
-RUN /usr/bin/build.sh
-`
+	if err := outputNamespaceError(spkg); err != nil {
+		r.Log.Error(err, "Invalid output", "namespace", spkg.Spec.Output.To.Namespace)
+		return r.recordSyncFailure(ctx, spkg, err, erroredBuild("OutputForbidden", err))
+	}
+	backend, err := r.backend(spkg)
+	if err != nil {
+		r.Log.Error(err, "Failed to select the build backend")
+		// the Build cannot progress until its backend is changed
+		return r.recordSyncFailure(ctx, spkg, err, erroredBuild("BackendNotAvailable", err))
+	}
+	base, err := r.baseImage(ctx, spkg)
+	if err != nil {
+		r.Log.Error(err, "Failed to resolve the base image", "spackVersion", spkg.Spec.SpackVersion)
+		return err
+	}
+	builder, err := backend.builderImage(ctx, spkg, base)
+	if err != nil {
+		r.Log.Error(err, "Failed to resolve the builder image", "baseImage", base.Name)
+		return r.recordSyncFailure(ctx, spkg, err, func(tmp *packagev1alpha1.Build) {
+			setCondition(tmp, packagev1alpha1.ConditionBaseImageReady, metav1.ConditionFalse,
+				"ImageNotResolved", err.Error())
+		})
+	}
+	// the OpenShift builds fail to push to a missing ImageStream
+	if b, ok := backend.(*openShiftBackend); ok {
+		if err := b.syncImageStream(ctx, spkg); err != nil {
+			return err
+		}
+	}
+
+	tmpl, err := r.loadBuildTemplates(ctx, spkg)
+	if err != nil {
+		r.Log.Error(err, "Failed to load the build templates")
+		return err
+	}
+	data := newTemplateData(spkg)
+	data.Builder = builder
+	if data.SigningKey, err = r.signingKeyFingerprint(ctx, spkg); err != nil {
+		r.Log.Error(err, "Failed to read the signing key")
+		return err
+	}
+
+	keep := map[string]bool{}
+	if m := spkg.Spec.SourceMirror; m != nil {
+		if m.PersistentVolumeClaim != nil {
+			server, err := r.syncSourceMirrorServer(ctx, spkg)
+			if err != nil {
+				r.Log.Error(err, "Failed to serve the source mirror")
+				return err
+			}
+			keep["Deployment/"+server] = true
+			keep["Service/"+server] = true
+		}
+		data.SourceMirrorURL = sourceMirrorURL(spkg)
+		data.NoProxy = s.Join(mirrorHosts(spkg), ",")
+	}
+
+	logic, err := buildLogicConfigMap(spkg, tmpl, data)
+	if err != nil {
+		r.Log.Error(err, "Failed to render the build logic")
+		return err
+	}
+	if err := r.applyConfigMap(ctx, spkg, logic); err != nil {
+		return err
+	}
+	keep["ConfigMap/"+logic.Name] = true
+
+	for _, env := range spkg.Spec.Environment {
+		// one configMap and build per platform the environment is built for
+		for _, p := range buildPlatforms(spkg) {
+			desiredCM, err := envConfigMap(spkg, env, p)
+			if err != nil {
+				r.Log.Error(err, "Failed to render the spack.yaml", "environment", *env.Name, "target", p.Target)
+				return err
+			}
+			if !keep["ConfigMap/"+desiredCM.Name] {
+				if err := r.applyConfigMap(ctx, spkg, desiredCM); err != nil {
+					return err
+				}
+				keep["ConfigMap/"+desiredCM.Name] = true
+			}
+
+			eb := &envBuild{
+				Name:       envBuildConfigName(spkg.Name, *env.Name, p),
+				Env:        env,
+				Platform:   p,
+				Base:       base,
+				ConfigMap:  desiredCM.Name,
+				BuildLogic: logic.Name,
+			}
+			// the installs onto a claim do not build any image
+			if spkg.Spec.Install == nil {
+				envData := data
+				envData.Environment = *env.Name
+				envData.Target = p.Target
+				envData.View = envView(env)
+				if eb.Dockerfile, err = renderTemplate(tmpl, components.DockerfileTemplate, envData); err != nil {
+					r.Log.Error(err, "Failed to render the Dockerfile", "environment", *env.Name, "platform", p.suffix())
+					return err
+				}
+				eb.Output = buildOutput(spkg)
+				eb.Tag = eb.Output.envTags(*env.Name, p.suffix())[0]
+			}
+			if err := backend.sync(ctx, spkg, eb, keep); err != nil {
+				return err
+			}
+		}
+	}
+
+	return r.pruneResources(ctx, spkg, keep)
+}
+
+// applyConfigMap creates an immutable configMap of a Build, or repairs the
+// labels and owner of the existing one
+func (r *BuildReconciler) applyConfigMap(ctx context.Context, spkg *packagev1alpha1.Build, desired *corev1.ConfigMap) error {
+	cm := &corev1.ConfigMap{ObjectMeta: envObjectMeta(spkg, desired.Name)}
+	op, err := controllerutil.CreateOrUpdate(ctx, r.Client, cm, func() error {
+		cm.Labels = mergeLabels(cm.Labels, desired.Labels)
+		// the data of an immutable configMap can only be set on creation
+		if cm.CreationTimestamp.IsZero() {
+			cm.Immutable = desired.Immutable
+			cm.Data = desired.Data
+		}
+		return controllerutil.SetControllerReference(spkg, cm, r.Scheme)
+	})
+	if err != nil {
+		r.Log.Error(err, "Failed to reconcile the configMap", "configMap", desired.Name)
+		return err
+	}
+	if op != controllerutil.OperationResultNone {
+		r.Log.Info("configMap reconciled", "configMap", cm.Name, "operation", op)
+	}
+	return nil
+}
+
+// pruneResources deletes the configMaps, build resources and source mirror
+// servers of a Build that are not listed in keep, keyed by "<Kind>/<name>"
+func (r *BuildReconciler) pruneResources(ctx context.Context, spkg *packagev1alpha1.Build, keep map[string]bool) error {
+	opts := []client.ListOption{
+		client.InNamespace(spkg.Namespace),
+		client.MatchingLabels{buildLabel: spkg.Name},
+	}
+
+	for _, backend := range r.backends() {
+		if err := backend.prune(ctx, spkg, keep); err != nil {
+			return err
+		}
+	}
+
+	cms := &corev1.ConfigMapList{}
+	if err := r.Client.List(ctx, cms, opts...); err != nil {
+		return err
+	}
+	for i := range cms.Items {
+		if keep["ConfigMap/"+cms.Items[i].Name] {
+			continue
+		}
+		r.Log.Info("Deleting stale configMap", "configMap", cms.Items[i].Name)
+		if err := r.Client.Delete(ctx, &cms.Items[i]); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+
+	deploys := &appsv1.DeploymentList{}
+	if err := r.Client.List(ctx, deploys, opts...); err != nil {
+		return err
+	}
+	for i := range deploys.Items {
+		if keep["Deployment/"+deploys.Items[i].Name] {
+			continue
+		}
+		r.Log.Info("Deleting stale Deployment", "deployment", deploys.Items[i].Name)
+		if err := r.Client.Delete(ctx, &deploys.Items[i], client.PropagationPolicy(metav1.DeletePropagationBackground)); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+
+	svcs := &corev1.ServiceList{}
+	if err := r.Client.List(ctx, svcs, opts...); err != nil {
+		return err
+	}
+	for i := range svcs.Items {
+		if keep["Service/"+svcs.Items[i].Name] {
+			continue
+		}
+		r.Log.Info("Deleting stale Service", "service", svcs.Items[i].Name)
+		if err := r.Client.Delete(ctx, &svcs.Items[i]); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// mergeLabels returns the labels of an object with the desired ones set
+func mergeLabels(labels, desired map[string]string) map[string]string {
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	for k, v := range desired {
+		labels[k] = v
+	}
+	return labels
+}
+
+// envObjectMeta returns the metadata identifying a resource of a Build
+func envObjectMeta(spkg *packagev1alpha1.Build, name string) metav1.ObjectMeta {
+	return metav1.ObjectMeta{
+		Name:      name,
+		Namespace: spkg.Namespace,
+	}
+}
+
+// syncEnvironments returns the status of every Spack environment and
+// platform on the CR, keeping the tracked state of the ones already in
+// the status
+func syncEnvironments(spkg *packagev1alpha1.Build) []packagev1alpha1.EnvironmentStatus {
+	current := map[string]packagev1alpha1.EnvironmentStatus{}
+	for _, env := range spkg.Status.Environments {
+		current[env.Name+"/"+string(env.Architecture)+"/"+env.Target] = env
+	}
+
+	envs := []packagev1alpha1.EnvironmentStatus{}
+	for _, env := range spkg.Spec.Environment {
+		for _, p := range buildPlatforms(spkg) {
+			status, ok := current[*env.Name+"/"+string(p.Arch)+"/"+p.Target]
+			if !ok {
+				status = packagev1alpha1.EnvironmentStatus{
+					Name:         *env.Name,
+					Architecture: p.Arch,
+					Target:       p.Target,
+					State:        packagev1alpha1.InitializedStatus,
+				}
+			}
+			status.View = installView(spkg, *env.Name, p)
+			status.ConfigMap = envConfigMapName(spkg.Name, env, p, status.View)
+			status.BuildConfig = envBuildConfigName(spkg.Name, *env.Name, p)
+			status.Image = ""
+			if spkg.Spec.Install == nil {
+				o := buildOutput(spkg)
+				status.Image = o.image(o.envTags(*env.Name, p.suffix())[0])
+			}
+			envs = append(envs, status)
+		}
+	}
+	return envs
+}
+
+// envConfigMap returns the configMap holding the spack.yaml of a Spack
+// environment for a platform, viewed on the install claim of the Build
+// when it installs the environments
+func envConfigMap(spkg *packagev1alpha1.Build, env packagev1alpha1.SpackEnvionment, p buildPlatform) (*corev1.ConfigMap, error) {
+	view := installView(spkg, *env.Name, p)
+	data, err := envSpackYAML(env, p.Target, view)
+	if err != nil {
+		return nil, err
+	}
 
 	// ensures that data stored in the ConfigMap cannot
 	// be updated (only object metadata can be modified).
 	immutable := new(bool)
 	*immutable = true
-	// configMap
-	cm := corev1.ConfigMap{
-		metav1.TypeMeta{
+
+	return &corev1.ConfigMap{
+		TypeMeta: metav1.TypeMeta{
 			Kind:       "ConfigMap",
 			APIVersion: "v1",
 		},
-		metav1.ObjectMeta{
-			Name:      s.Join([]string{tmp.Name, "env"}, "-"),
-			Namespace: "spack-operator-system",
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      envConfigMapName(spkg.Name, env, p, view),
+			Namespace: spkg.Namespace,
+			Labels:    map[string]string{buildLabel: spkg.Name},
 		},
-		immutable,
-		// TODO: create a config map for each environment in the BuildSpec CR
-		map[string]string{*tmp.Spec.Environment[0].Name: *tmp.Spec.Environment[0].Data},
-		map[string][]byte{},
-	}
-	if err := r.Client.Create(ctx, &cm); err != nil {
-		r.Log.Error(err, "Failed to create the configMap")
-		return ctrl.Result{Requeue: true, RequeueAfter: 1 * time.Second}, err
-	}
+		Immutable: immutable,
+		Data:      map[string]string{spackEnvFile: data},
+	}, nil
+}
 
-	// configMapBuildSource
-	// DestinationDir set to default (same context as the Dockerfile)
-	cmbs := buildv1.ConfigMapBuildSource{
-		ConfigMap: corev1.LocalObjectReference{
-			Name: s.Join([]string{tmp.Name, "env"}, "-"),
-		},
+// baseImage returns the ImageStreamTag the environments of spkg are built
+// on: the BaseImage of its spec, else the base image of the SpackRelease of
+// its SpackVersion on its OS, else the operator base image of that version
+// and OS in the namespace of spkg
+func (r *BuildReconciler) baseImage(ctx context.Context, spkg *packagev1alpha1.Build) (*corev1.ObjectReference, error) {
+	if spkg.Spec.BaseImage != "" {
+		return &corev1.ObjectReference{Kind: "ImageStreamTag", Name: spkg.Spec.BaseImage}, nil
 	}
 
-	buildLogic := buildv1.ConfigMapBuildSource{
-		ConfigMap: corev1.LocalObjectReference{
-			Name: "spack-build-logic",
-		},
+	releases := &packagev1alpha1.SpackReleaseList{}
+	if err := r.Client.List(ctx, releases); err != nil {
+		return nil, err
+	}
+	for _, release := range releases.Items {
+		if release.Spec.Version != spkg.Spec.SpackVersion {
+			continue
+		}
+		for _, image := range release.Status.Images {
+			if image.OS == spkg.Spec.OS && image.Image != "" {
+				return &corev1.ObjectReference{
+					Kind:      "ImageStreamTag",
+					Name:      image.Image,
+					Namespace: release.Status.Namespace,
+				}, nil
+			}
+		}
 	}
 
-	// TODO: take this value from the CR and default to 3 if not given
-	l := int32(3)
-	// Create the buildConfig
-	bc := &buildv1.BuildConfig{
-		metav1.TypeMeta{},
-		metav1.ObjectMeta{
-			Name:      s.Join([]string{tmp.Name, "buildconfig"}, "-"),
-			Namespace: tmp.Namespace,
-		},
-		buildv1.BuildConfigSpec{
-			RunPolicy:                    buildv1.BuildRunPolicyParallel,
-			SuccessfulBuildsHistoryLimit: &l,
-			FailedBuildsHistoryLimit:     &l,
-			Triggers:                     []buildv1.BuildTriggerPolicy{{Type: "ConfigChange"}},
-			CommonSpec: buildv1.CommonSpec{
-				Strategy: buildv1.BuildStrategy{
-					Type: "Docker",
-					DockerStrategy: &buildv1.DockerBuildStrategy{
-						From: &corev1.ObjectReference{
-							Kind: "ImageStreamTag",
-							Name: "spack-operator-base:spackv0.16.0",
-						},
-					},
-				},
-				Source: buildv1.BuildSource{
-					Type:       "Dockerfile",
-					Dockerfile: baseBuildRecipe,
-					ConfigMaps: []buildv1.ConfigMapBuildSource{cmbs, buildLogic},
-				},
-				Output: buildv1.BuildOutput{
-					To: &corev1.ObjectReference{
-						Kind: "ImageStreamTag",
-						Name: tmp.Spec.ImageStream,
-					},
-					ImageLabels: []buildv1.ImageLabel{
-						// TODO: labels about the arch and other interesting build aspects
-						{"built-by", "multiarch-operator"},
-					},
+	return &corev1.ObjectReference{
+		Kind: "ImageStreamTag",
+		Name: packagev1alpha1.CurrentBuildDefaults().BaseImageTag(spkg.Spec.SpackVersion, spkg.Spec.OS),
+	}, nil
+}
+
+// mirrorBuildArgs passes the credentials of the binary mirror to the
+// builder stage, the keys missing from the Secret are left empty
+func mirrorBuildArgs(mirror *packagev1alpha1.BinaryMirror) []corev1.EnvVar {
+	if mirror == nil || mirror.CredentialsSecret == nil {
+		return nil
+	}
+	args := []corev1.EnvVar{}
+	for _, key := range []string{
+		packagev1alpha1.MirrorAccessKeyID,
+		packagev1alpha1.MirrorSecretAccessKey,
+		packagev1alpha1.MirrorOCIUsername,
+		packagev1alpha1.MirrorOCIPassword,
+	} {
+		optional := true
+		args = append(args, corev1.EnvVar{
+			Name: key,
+			ValueFrom: &corev1.EnvVarSource{
+				SecretKeyRef: &corev1.SecretKeySelector{
+					LocalObjectReference: *mirror.CredentialsSecret,
+					Key:                  key,
+					Optional:             &optional,
 				},
-				NodeSelector: nil,
 			},
-		},
-		buildv1.BuildConfigStatus{},
+		})
 	}
-	if err := r.Client.Create(ctx, bc); err != nil {
-		r.Log.Error(err, "Failed to create the BuildConfig")
-		return ctrl.Result{}, err
+	return args
+}
+
+// envImageLabels returns the labels of the image of a Spack environment
+// for a platform
+func envImageLabels(spkg *packagev1alpha1.Build, env packagev1alpha1.SpackEnvionment, p buildPlatform) []buildv1.ImageLabel {
+	return append([]buildv1.ImageLabel{
+		{Name: "built-by", Value: "multiarch-operator"},
+		{Name: "spack.io/environment", Value: *env.Name},
+		{Name: "spack.io/version", Value: spkg.Spec.SpackVersion},
+		{Name: "spack.io/os", Value: string(spkg.Spec.OS)},
+	}, p.imageLabels()...)
+}
+
+// runtimeImage returns the image the runtime stage of the environment
+// images is based on
+func runtimeImage(spkg *packagev1alpha1.Build) string {
+	if spkg.Spec.Runtime.Image != "" {
+		return spkg.Spec.Runtime.Image
 	}
+	return osProfiles[spkg.Spec.OS].Image
+}
 
-	//update the build status
-	opts := []client.UpdateOption{}
-	tmp.Status.State = packagev1alpha1.InitializedStatus
-	if err := r.Client.Status().Update(ctx, tmp, opts...); err != nil {
-		r.Log.Error(err, "status update failed")
-		return ctrl.Result{}, err
+// envConfigMapName returns the name of the configMap of a Spack environment
+// for a platform. The name is addressed by the content of the environment,
+// the targeted microarchitecture and the view on the install claim, a new
+// configMap is created whenever they change since configMaps are immutable.
+func envConfigMapName(build string, env packagev1alpha1.SpackEnvionment, p buildPlatform, view string) string {
+	var content interface{} = env
+	if env.Data != nil {
+		content = *env.Data
+	}
+	hash := hashOf(content)
+	switch {
+	case view != "":
+		hash = hashOf([]interface{}{content, p.Target, view})
+	case p.Target != "":
+		hash = hashOf([]interface{}{content, p.Target})
 	}
+	return joinNonEmpty(build, *env.Name, dnsTarget(p.Target), "env", hash)
+}
 
-	objKey := types.NamespacedName{
-		Namespace: tmp.Namespace,
-		Name:      tmp.Name,
+// hashOf returns a short hash of the JSON representation of obj
+func hashOf(obj interface{}) string {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		// every object hashed by the operator can be marshaled
+		panic(err)
 	}
-	if err := r.Client.Get(ctx, objKey, tmp); err != nil {
-		r.Log.Error(err, "status update failed to refresh object")
-		return ctrl.Result{}, err
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])[:10]
+}
+
+// envBuildConfigName returns the name of the buildConfig of a Spack
+// environment for a platform
+func envBuildConfigName(build, env string, p buildPlatform) string {
+	return joinNonEmpty(build, env, p.nameSuffix(), "buildconfig")
+}
+
+// splitImageStreamTag returns the ImageStream and the tag of an
+// ImageStreamTag, or the repository and the tag of an image. The tag is
+// empty when it has none, the port of a registry host is not one.
+func splitImageStreamTag(imageStreamTag string) (string, string) {
+	if i := s.LastIndex(imageStreamTag, ":"); i > s.LastIndex(imageStreamTag, "/") {
+		return imageStreamTag[:i], imageStreamTag[i+1:]
 	}
+	return imageStreamTag, ""
+}
 
-	return ctrl.Result{Requeue: true, RequeueAfter: 3 * time.Second}, nil
+// joinNonEmpty joins the non empty parts of a name with dashes
+func joinNonEmpty(parts ...string) string {
+	nonEmpty := []string{}
+	for _, p := range parts {
+		if p != "" {
+			nonEmpty = append(nonEmpty, p)
+		}
+	}
+	return s.Join(nonEmpty, "-")
 }
 
 func (r *BuildReconciler) validateBuild(ctx context.Context, spkg *packagev1alpha1.Build) (ctrl.Result, error) {
@@ -162,6 +561,42 @@ func (r *BuildReconciler) validateBuild(ctx context.Context, spkg *packagev1alph
 	tmp := spkg.DeepCopy()
 	opts := []client.UpdateOption{}
 	tmp.Status.State = packagev1alpha1.ValidatedPackage
+	tmp.Status.Reason = ""
+	backend, err := r.backend(tmp)
+	if err != nil {
+		r.Log.Error(err, "Failed to select the build backend")
+		return ctrl.Result{}, err
+	}
+	for i := range tmp.Status.Environments {
+		env := &tmp.Status.Environments[i]
+		// the build resources of every environment must exist before its builds can be tracked
+		reason, err := backend.validate(ctx, tmp, env)
+		if err != nil {
+			r.Log.Error(err, "Failed to get the build resources", "environment", env.Name, "architecture", env.Architecture)
+			return ctrl.Result{}, err
+		}
+		if reason != "" {
+			env.State = packagev1alpha1.ErroredPackage
+			env.Reason = reason
+			tmp.Status.State = packagev1alpha1.ErroredPackage
+			tmp.Status.Reason = "environment " + envDisplayName(*env) + ": " + env.Reason
+			continue
+		}
+		env.State = packagev1alpha1.ValidatedPackage
+	}
+	if tmp.Status.State == packagev1alpha1.ErroredPackage {
+		setCondition(tmp, packagev1alpha1.ConditionEnvironmentValid, metav1.ConditionFalse,
+			"BuildResourcesNotFound", tmp.Status.Reason)
+	} else {
+		setCondition(tmp, packagev1alpha1.ConditionEnvironmentValid, metav1.ConditionTrue,
+			"BuildResourcesFound", "the build resources of all the environments exist")
+	}
+	if err := r.setBaseImageCondition(ctx, tmp, backend); err != nil {
+		r.Log.Error(err, "Failed to check the base image")
+		return ctrl.Result{}, err
+	}
+	setBuildConditions(tmp)
+	tmp.Status.LastUpdate = metav1.Now()
 	if err := r.Client.Status().Update(ctx, tmp, opts...); err != nil {
 		r.Log.Error(err, "status update failed")
 		return ctrl.Result{}, err
@@ -179,9 +614,44 @@ func (r *BuildReconciler) validateBuild(ctx context.Context, spkg *packagev1alph
 	return ctrl.Result{Requeue: true, RequeueAfter: 3 * time.Second}, nil
 }
 
-func (r *BuildReconciler) deleteBuild(ctx context.Context, spkg *packagev1alpha1.Build) (ctrl.Result, error) {
+// deleteBuild removes the resources of the defaulted spkg, then the
+// finalizer of the stored Build
+func (r *BuildReconciler) deleteBuild(ctx context.Context, stored, spkg *packagev1alpha1.Build) (ctrl.Result, error) {
 
 	r.Log.Info("Deleting package buildConfig", "package", spkg.Name)
 
+	// remove the images pushed for every environment, they are not owned
+	// by the Build so they are not garbage collected
+	for _, backend := range r.backends() {
+		if err := backend.deleteImages(ctx, spkg); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	// the build resources and configMaps are owned by the Build, deleting
+	// them here releases them (and the builds they own) without waiting
+	// for the garbage collector
+	if err := r.pruneResources(ctx, spkg, map[string]bool{}); err != nil {
+		r.Log.Error(err, "Failed to delete the environment resources")
+		return ctrl.Result{}, err
+	}
+
+	if err := r.patchFinalizer(ctx, stored, false); err != nil {
+		r.Log.Error(err, "Failed to remove the finalizer")
+		return ctrl.Result{}, err
+	}
+
 	return ctrl.Result{Requeue: false}, nil
 }
+
+// patchFinalizer adds or removes the finalizer of spkg with a merge patch,
+// which leaves its spec as stored
+func (r *BuildReconciler) patchFinalizer(ctx context.Context, spkg *packagev1alpha1.Build, add bool) error {
+	patch := client.MergeFromWithOptions(spkg.DeepCopy(), client.MergeFromWithOptimisticLock{})
+	if add {
+		controllerutil.AddFinalizer(spkg, buildFinalizer)
+	} else {
+		controllerutil.RemoveFinalizer(spkg, buildFinalizer)
+	}
+	return r.Client.Patch(ctx, spkg, patch)
+}
@@ -0,0 +1,85 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"golang.org/x/crypto/openpgp"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	packagev1alpha1 "github.com/ArangoGutierrez/spack-operator/api/v1alpha1"
+	buildv1 "github.com/openshift/api/build/v1"
+)
+
+// signingKeyDir is the directory of the build context the Secret holding
+// the signing key of the binary mirror is copied to
+const signingKeyDir = "signing-key"
+
+// signingKeyFingerprint returns the fingerprint of the GPG key signing the
+// packages pushed to the binary mirror of a Build, empty when the Build
+// does not reference a signing key
+func (r *BuildReconciler) signingKeyFingerprint(ctx context.Context, spkg *packagev1alpha1.Build) (string, error) {
+	mirror := spkg.Spec.BinaryMirror
+	if mirror == nil || mirror.SigningKeySecret == nil {
+		return "", nil
+	}
+	secret := &corev1.Secret{}
+	key := types.NamespacedName{Namespace: spkg.Namespace, Name: mirror.SigningKeySecret.Name}
+	if err := r.Client.Get(ctx, key, secret); err != nil {
+		return "", fmt.Errorf("failed to get the signing key Secret %s: %v", key.Name, err)
+	}
+	fingerprint, err := gpgFingerprint(secret.Data[packagev1alpha1.MirrorSigningKey])
+	if err != nil {
+		return "", fmt.Errorf("invalid signing key Secret %s: %v", key.Name, err)
+	}
+	return fingerprint, nil
+}
+
+// gpgFingerprint returns the fingerprint of an ASCII-armored GPG private
+// key, Spack signs with the secret keys it trusts
+func gpgFingerprint(armored []byte) (string, error) {
+	if len(armored) == 0 {
+		return "", fmt.Errorf("%s not found", packagev1alpha1.MirrorSigningKey)
+	}
+	entities, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(armored))
+	if err != nil {
+		return "", err
+	}
+	if len(entities) != 1 {
+		return "", fmt.Errorf("%s holds %d keys, expected a single one", packagev1alpha1.MirrorSigningKey, len(entities))
+	}
+	if entities[0].PrivateKey == nil {
+		return "", fmt.Errorf("%s is not a private key", packagev1alpha1.MirrorSigningKey)
+	}
+	return fmt.Sprintf("%X", entities[0].PrimaryKey.Fingerprint), nil
+}
+
+// signingKeySources copies the Secret holding the signing key of the binary
+// mirror into the build context, from where the builder stage picks it up
+func signingKeySources(mirror *packagev1alpha1.BinaryMirror) []buildv1.SecretBuildSource {
+	if mirror == nil || mirror.SigningKeySecret == nil {
+		return nil
+	}
+	return []buildv1.SecretBuildSource{{
+		Secret:         *mirror.SigningKeySecret,
+		DestinationDir: signingKeyDir,
+	}}
+}
@@ -0,0 +1,176 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	s "strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	packagev1alpha1 "github.com/ArangoGutierrez/spack-operator/api/v1alpha1"
+)
+
+const (
+	// sourceMirrorServerImage serves the source mirrors held by
+	// PersistentVolumeClaims to the builds
+	sourceMirrorServerImage = "registry.access.redhat.com/ubi8/httpd-24"
+
+	// sourceMirrorServerRoot is the document root of the server image
+	sourceMirrorServerRoot = "/var/www/html"
+
+	// sourceMirrorPort is the port the source mirrors are served on
+	sourceMirrorPort = 8080
+
+	// sourceMissingReason prefixes the line the build script prints when a
+	// source archive is missing from the mirror of an air-gapped Build
+	sourceMissingReason = "SourceArchiveMissing"
+)
+
+// sourceMirrorServerName returns the name of the Deployment and Service
+// serving the source mirror of a Build
+func sourceMirrorServerName(build string) string {
+	return joinNonEmpty(build, "source-mirror")
+}
+
+// sourceMirrorURL returns the URL the builds fetch the source archives
+// from, the Service of the operator for a PersistentVolumeClaim
+func sourceMirrorURL(spkg *packagev1alpha1.Build) string {
+	mirror := spkg.Spec.SourceMirror
+	if mirror.URL != "" {
+		return mirror.URL
+	}
+	u := fmt.Sprintf("http://%s.%s.svc:%d", sourceMirrorServerName(spkg.Name), spkg.Namespace, sourceMirrorPort)
+	if mirror.Path != "" {
+		u += "/" + s.Trim(mirror.Path, "/")
+	}
+	return u
+}
+
+// mirrorHosts returns the hosts of the mirrors of a Build, the only ones
+// an air-gapped build reaches
+func mirrorHosts(spkg *packagev1alpha1.Build) []string {
+	hosts := []string{"localhost", "127.0.0.1"}
+	urls := []string{}
+	if spkg.Spec.SourceMirror != nil {
+		urls = append(urls, sourceMirrorURL(spkg))
+	}
+	if m := spkg.Spec.BinaryMirror; m != nil {
+		switch {
+		case m.Endpoint != "":
+			urls = append(urls, m.Endpoint)
+		case s.HasPrefix(m.URL, "s3://"):
+			// the host of an s3 URL is its bucket
+			hosts = append(hosts, ".amazonaws.com")
+		default:
+			urls = append(urls, m.URL)
+		}
+	}
+	for _, raw := range urls {
+		if u, err := url.Parse(raw); err == nil && u.Hostname() != "" {
+			hosts = append(hosts, u.Hostname())
+		}
+	}
+	return hosts
+}
+
+// syncSourceMirrorServer serves the source mirror held by the
+// PersistentVolumeClaim of a Build over HTTP, returning the name of the
+// Deployment and Service
+func (r *BuildReconciler) syncSourceMirrorServer(ctx context.Context, spkg *packagev1alpha1.Build) (string, error) {
+	claim := spkg.Spec.SourceMirror.PersistentVolumeClaim.Name
+	pvc := &corev1.PersistentVolumeClaim{}
+	if err := r.Client.Get(ctx, types.NamespacedName{Namespace: spkg.Namespace, Name: claim}, pvc); err != nil {
+		return "", fmt.Errorf("failed to get the source mirror persistentVolumeClaim %s: %v", claim, err)
+	}
+
+	name := sourceMirrorServerName(spkg.Name)
+	labels := map[string]string{buildLabel: spkg.Name, "app": name}
+
+	deploy := &appsv1.Deployment{ObjectMeta: envObjectMeta(spkg, name)}
+	op, err := controllerutil.CreateOrUpdate(ctx, r.Client, deploy, func() error {
+		deploy.Labels = mergeLabels(deploy.Labels, labels)
+		// the selector of a Deployment is immutable
+		if deploy.CreationTimestamp.IsZero() {
+			deploy.Spec.Selector = &metav1.LabelSelector{MatchLabels: labels}
+		}
+		deploy.Spec.Template.Labels = labels
+		deploy.Spec.Template.Spec.Containers = []corev1.Container{{
+			Name:  "server",
+			Image: sourceMirrorServerImage,
+			Ports: []corev1.ContainerPort{{ContainerPort: sourceMirrorPort, Protocol: corev1.ProtocolTCP}},
+			VolumeMounts: []corev1.VolumeMount{{
+				Name:      "mirror",
+				MountPath: sourceMirrorServerRoot,
+				ReadOnly:  true,
+			}},
+		}}
+		deploy.Spec.Template.Spec.Volumes = []corev1.Volume{{
+			Name: "mirror",
+			VolumeSource: corev1.VolumeSource{
+				PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: claim, ReadOnly: true},
+			},
+		}}
+		return controllerutil.SetControllerReference(spkg, deploy, r.Scheme)
+	})
+	if err != nil {
+		r.Log.Error(err, "Failed to reconcile the source mirror Deployment", "deployment", name)
+		return "", err
+	}
+	if op != controllerutil.OperationResultNone {
+		r.Log.Info("Deployment reconciled", "deployment", name, "operation", op)
+	}
+
+	svc := &corev1.Service{ObjectMeta: envObjectMeta(spkg, name)}
+	op, err = controllerutil.CreateOrUpdate(ctx, r.Client, svc, func() error {
+		svc.Labels = mergeLabels(svc.Labels, labels)
+		svc.Spec.Selector = labels
+		svc.Spec.Ports = []corev1.ServicePort{{
+			Name:       "http",
+			Port:       sourceMirrorPort,
+			TargetPort: intstr.FromInt(sourceMirrorPort),
+			Protocol:   corev1.ProtocolTCP,
+		}}
+		return controllerutil.SetControllerReference(spkg, svc, r.Scheme)
+	})
+	if err != nil {
+		r.Log.Error(err, "Failed to reconcile the source mirror Service", "service", name)
+		return "", err
+	}
+	if op != controllerutil.OperationResultNone {
+		r.Log.Info("Service reconciled", "service", name, "operation", op)
+	}
+	return name, nil
+}
+
+// sourceMissingLine returns the line of the log of a build reporting a
+// source archive missing from the source mirror, empty when there is none
+func sourceMissingLine(log string) string {
+	for _, line := range s.Split(log, "\n") {
+		if i := s.Index(line, sourceMissingReason+":"); i >= 0 {
+			return s.TrimSpace(line[i:])
+		}
+	}
+	return ""
+}
@@ -0,0 +1,227 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+
+	packagev1alpha1 "github.com/ArangoGutierrez/spack-operator/api/v1alpha1"
+	"sigs.k8s.io/yaml"
+)
+
+// defaultSpackView is the directory the build logic expects the view of
+// the environments in
+const defaultSpackView = "/opt/view"
+
+// envSpackYAML returns the spack.yaml of an environment built for a
+// microarchitecture, with its view in the view directory when one is given.
+// A raw spack.yaml is kept verbatim unless a target is set as the one of
+// all the packages or its view is moved, the structured environments are
+// rendered into a canonical spack.yaml.
+func envSpackYAML(env packagev1alpha1.SpackEnvionment, target, view string) (string, error) {
+	if env.Data != nil && target == "" && view == "" {
+		return *env.Data, nil
+	}
+
+	manifest, err := envManifest(env)
+	if err != nil {
+		return "", err
+	}
+
+	if target != "" || view != "" {
+		// older Spack releases name the root of the environment "env"
+		root := "spack"
+		if _, ok := manifest[root]; !ok {
+			if _, ok := manifest["env"]; ok {
+				root = "env"
+			}
+		}
+		spack, err := yamlSection(manifest, root)
+		if err != nil {
+			return "", err
+		}
+		if view != "" {
+			spack["view"] = view
+		}
+		if target != "" {
+			packages, err := yamlSection(spack, "packages")
+			if err != nil {
+				return "", err
+			}
+			all, err := yamlSection(packages, "all")
+			if err != nil {
+				return "", err
+			}
+			all["target"] = []string{target}
+		}
+	}
+
+	out, err := yaml.Marshal(manifest)
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+// envView returns the directory of the view of an environment built into an
+// image, the default one unless its spack.yaml links the view elsewhere
+func envView(env packagev1alpha1.SpackEnvionment) string {
+	if env.View != "" {
+		return env.View
+	}
+	if env.Data != nil {
+		manifest := map[string]interface{}{}
+		if err := yaml.Unmarshal([]byte(*env.Data), &manifest); err == nil {
+			// older Spack releases name the root of the environment "env"
+			for _, root := range []string{"spack", "env"} {
+				section, _ := manifest[root].(map[string]interface{})
+				if view, ok := section["view"].(string); ok && path.IsAbs(view) {
+					return view
+				}
+			}
+		}
+	}
+	return defaultSpackView
+}
+
+// envManifest returns the spack.yaml of an environment as a YAML mapping
+func envManifest(env packagev1alpha1.SpackEnvionment) (map[string]interface{}, error) {
+	manifest := map[string]interface{}{}
+	if env.Data != nil {
+		if err := yaml.Unmarshal([]byte(*env.Data), &manifest); err != nil {
+			return nil, fmt.Errorf("invalid spack.yaml: %v", err)
+		}
+		return manifest, nil
+	}
+
+	spack := map[string]interface{}{
+		"specs": env.Specs,
+		"view":  defaultSpackView,
+	}
+	if env.View != "" {
+		spack["view"] = env.View
+	}
+	if env.Concretization != "" {
+		spack["concretization"] = string(env.Concretization)
+	}
+	if len(env.Packages) > 0 {
+		packages := map[string]interface{}{}
+		for name, pkg := range env.Packages {
+			packages[name] = spackPackage(pkg)
+		}
+		spack["packages"] = packages
+	}
+	if len(env.Compilers) > 0 {
+		compilers := []interface{}{}
+		for _, c := range env.Compilers {
+			compilers = append(compilers, map[string]interface{}{"compiler": spackCompiler(c)})
+		}
+		spack["compilers"] = compilers
+	}
+	if env.Config != nil && len(env.Config.Raw) > 0 {
+		var config interface{}
+		if err := json.Unmarshal(env.Config.Raw, &config); err != nil {
+			return nil, fmt.Errorf("invalid config: %v", err)
+		}
+		spack["config"] = config
+	}
+	manifest["spack"] = spack
+	return manifest, nil
+}
+
+// spackPackage returns the packages section of a package in a spack.yaml
+func spackPackage(pkg packagev1alpha1.SpackPackage) map[string]interface{} {
+	section := map[string]interface{}{}
+	if len(pkg.Version) > 0 {
+		section["version"] = pkg.Version
+	}
+	if pkg.Variants != "" {
+		section["variants"] = pkg.Variants
+	}
+	if len(pkg.Compiler) > 0 {
+		section["compiler"] = pkg.Compiler
+	}
+	if len(pkg.Target) > 0 {
+		section["target"] = pkg.Target
+	}
+	if len(pkg.Providers) > 0 {
+		section["providers"] = pkg.Providers
+	}
+	if pkg.Buildable != nil {
+		section["buildable"] = *pkg.Buildable
+	}
+	if len(pkg.Externals) > 0 {
+		externals := []interface{}{}
+		for _, e := range pkg.Externals {
+			external := map[string]interface{}{"spec": e.Spec}
+			if e.Prefix != "" {
+				external["prefix"] = e.Prefix
+			}
+			if len(e.Modules) > 0 {
+				external["modules"] = e.Modules
+			}
+			externals = append(externals, external)
+		}
+		section["externals"] = externals
+	}
+	return section
+}
+
+// spackCompiler returns the compilers section of a compiler in a spack.yaml
+func spackCompiler(c packagev1alpha1.SpackCompiler) map[string]interface{} {
+	section := map[string]interface{}{
+		"spec": c.Spec,
+		"paths": map[string]interface{}{
+			"cc":  nullable(c.Paths.CC),
+			"cxx": nullable(c.Paths.CXX),
+			"f77": nullable(c.Paths.F77),
+			"fc":  nullable(c.Paths.FC),
+		},
+		"operating_system": c.OperatingSystem,
+		"target":           c.Target,
+		"modules":          []string{},
+	}
+	if len(c.Modules) > 0 {
+		section["modules"] = c.Modules
+	}
+	return section
+}
+
+// nullable returns nil for the empty values Spack expects as null
+func nullable(v string) interface{} {
+	if v == "" {
+		return nil
+	}
+	return v
+}
+
+// yamlSection returns the mapping under key in parent, creating it if missing
+func yamlSection(parent map[string]interface{}, key string) (map[string]interface{}, error) {
+	v, ok := parent[key]
+	if !ok || v == nil {
+		section := map[string]interface{}{}
+		parent[key] = section
+		return section, nil
+	}
+	section, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid spack.yaml: %s is not a mapping", key)
+	}
+	return section, nil
+}
@@ -16,6 +16,339 @@ limitations under the License.
 
 package controllers
 
-func (r *BuildReconciler) updateStatus() error {
-	return nil
+import (
+	"context"
+	"fmt"
+	"strconv"
+	s "strings"
+	"time"
+
+	packagev1alpha1 "github.com/ArangoGutierrez/spack-operator/api/v1alpha1"
+	buildv1 "github.com/openshift/api/build/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// statusPriority orders the states of the environments, the first state
+// found among the environments becomes the state of the whole Build
+var statusPriority = []packagev1alpha1.InstallStatus{
+	packagev1alpha1.ErroredPackage,
+	packagev1alpha1.FailedPackage,
+	packagev1alpha1.CancelledPackage,
+	packagev1alpha1.RunningPackage,
+	packagev1alpha1.PendingPackage,
+	packagev1alpha1.NewPackage,
+	packagev1alpha1.InitializedStatus,
+	packagev1alpha1.ValidatedPackage,
+	packagev1alpha1.CompletedPackage,
+}
+
+// updateStatus tracks the builds of every environment through the backend
+// of the Build and maps their phases into the Build status
+func (r *BuildReconciler) updateStatus(ctx context.Context, spkg *packagev1alpha1.Build) (ctrl.Result, error) {
+
+	tmp := spkg.DeepCopy()
+	tmp.Status.Environments = syncEnvironments(tmp)
+	now := metav1.Now()
+	backend, err := r.backend(tmp)
+	if err != nil {
+		r.Log.Error(err, "Failed to select the build backend")
+		return ctrl.Result{}, err
+	}
+	for i := range tmp.Status.Environments {
+		if err := backend.update(ctx, tmp, &tmp.Status.Environments[i]); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	state, reason := aggregateStatus(tmp.Status.Environments)
+	if tmp.Status.State != state || tmp.Status.Reason != reason {
+		tmp.Status.LastUpdate = now
+	}
+	tmp.Status.State = state
+	tmp.Status.Reason = reason
+	tmp.Status.StartTimestamp, tmp.Status.CompletionTimestamp = aggregateTimestamps(tmp.Status.Environments)
+
+	fingerprint, err := r.signingKeyFingerprint(ctx, tmp)
+	if err != nil {
+		r.Log.Error(err, "Failed to read the signing key")
+		return ctrl.Result{}, err
+	}
+	tmp.Status.SigningKeyFingerprint = fingerprint
+
+	// the failures are recorded in the status, which is saved before retrying
+	manifestErr := r.assembleManifestLists(ctx, tmp)
+	outputErr := r.pushOutputs(ctx, tmp)
+
+	if err := r.setBaseImageCondition(ctx, tmp, backend); err != nil {
+		r.Log.Error(err, "Failed to check the base image")
+		return ctrl.Result{}, err
+	}
+	setBuildConditions(tmp)
+
+	result := ctrl.Result{RequeueAfter: 30 * time.Second}
+	if isFinished(state) {
+		result = ctrl.Result{}
+	}
+
+	if !equality.Semantic.DeepEqual(spkg.Status, tmp.Status) {
+		if err := r.Client.Status().Update(ctx, tmp); err != nil {
+			r.Log.Error(err, "status update failed")
+			return ctrl.Result{}, err
+		}
+	}
+
+	if manifestErr != nil {
+		return ctrl.Result{}, manifestErr
+	}
+	if outputErr != nil {
+		return ctrl.Result{}, outputErr
+	}
+	return result, nil
+}
+
+// setCondition records a condition of the Build for its current generation
+func setCondition(spkg *packagev1alpha1.Build, condType string, status metav1.ConditionStatus, reason, message string) {
+	spkg.Status.ObservedGeneration = spkg.Generation
+	meta.SetStatusCondition(&spkg.Status.Conditions, metav1.Condition{
+		Type:               condType,
+		Status:             status,
+		ObservedGeneration: spkg.Generation,
+		Reason:             reason,
+		Message:            message,
+	})
+}
+
+// setBuildConditions derives the BuildSucceeded, ImagePushed and Ready
+// conditions from the state of the environments, without ImagePushed when
+// they are installed onto a claim
+func setBuildConditions(spkg *packagev1alpha1.Build) {
+	state, reason := aggregateStatus(spkg.Status.Environments)
+	switch state {
+	case packagev1alpha1.CompletedPackage:
+		setCondition(spkg, packagev1alpha1.ConditionBuildSucceeded, metav1.ConditionTrue,
+			"BuildsComplete", "the builds of all the environments have succeeded")
+	case packagev1alpha1.FailedPackage:
+		condReason := "BuildFailed"
+		if s.Contains(reason, sourceMissingReason+":") {
+			condReason = sourceMissingReason
+		}
+		setCondition(spkg, packagev1alpha1.ConditionBuildSucceeded, metav1.ConditionFalse, condReason, reason)
+	case packagev1alpha1.CancelledPackage:
+		setCondition(spkg, packagev1alpha1.ConditionBuildSucceeded, metav1.ConditionFalse, "BuildCancelled", reason)
+	case packagev1alpha1.ErroredPackage:
+		setCondition(spkg, packagev1alpha1.ConditionBuildSucceeded, metav1.ConditionFalse, "BuildErrored", reason)
+	default:
+		setCondition(spkg, packagev1alpha1.ConditionBuildSucceeded, metav1.ConditionUnknown,
+			"BuildsInProgress", "the builds of the environments are "+string(state))
+	}
+
+	required := []string{
+		packagev1alpha1.ConditionEnvironmentValid,
+		packagev1alpha1.ConditionBaseImageReady,
+		packagev1alpha1.ConditionBuildSucceeded,
+	}
+	ready := "all the environments have been installed"
+	// the installs onto a claim do not push any image
+	if spkg.Spec.Install != nil {
+		meta.RemoveStatusCondition(&spkg.Status.Conditions, packagev1alpha1.ConditionImagePushed)
+	} else {
+		pushed := len(spkg.Status.Environments) > 0 && outputsPushed(spkg)
+		for _, env := range spkg.Status.Environments {
+			if env.State != packagev1alpha1.CompletedPackage || env.ImageDigest == "" {
+				pushed = false
+				break
+			}
+		}
+		if pushed {
+			setCondition(spkg, packagev1alpha1.ConditionImagePushed, metav1.ConditionTrue,
+				"ImagesPushed", "the images of all the environments have been pushed")
+		} else {
+			setCondition(spkg, packagev1alpha1.ConditionImagePushed, metav1.ConditionFalse,
+				"ImagesPending", "some environment images have not been pushed yet")
+		}
+		required = append(required, packagev1alpha1.ConditionImagePushed)
+		ready = "all the environment images have been built and pushed"
+	}
+
+	for _, condType := range required {
+		if cond := meta.FindStatusCondition(spkg.Status.Conditions, condType); cond == nil || cond.Status != metav1.ConditionTrue {
+			setCondition(spkg, packagev1alpha1.ConditionReady, metav1.ConditionFalse,
+				"Not"+condType, "condition "+condType+" is not true")
+			return
+		}
+	}
+	setCondition(spkg, packagev1alpha1.ConditionReady, metav1.ConditionTrue, "Ready", ready)
+}
+
+// setBaseImageCondition checks whether the Spack base image the environments
+// are built from can be pulled by the builds of the backend
+func (r *BuildReconciler) setBaseImageCondition(ctx context.Context, spkg *packagev1alpha1.Build, backend buildBackend) error {
+	base, err := r.baseImage(ctx, spkg)
+	if err != nil {
+		return err
+	}
+	return backend.setBaseImageCondition(ctx, spkg, base)
+}
+
+// recordLatestBuild records the latest build of an environment in its
+// status, along with the digest of the image it pushed
+func recordLatestBuild(env *packagev1alpha1.EnvironmentStatus, build string, state packagev1alpha1.InstallStatus,
+	reason, digest string, start, completion *metav1.Time) {
+	if env.State != state || env.Reason != reason || env.LatestBuild != build {
+		env.LastUpdate = metav1.Now()
+	}
+	env.State = state
+	env.Reason = reason
+	env.LatestBuild = build
+	env.ImageDigest = digest
+	env.ExitCode = nil
+	env.StartTimestamp = start
+	env.CompletionTimestamp = completion
+	for j := range env.History {
+		if env.History[j].Build == build {
+			env.History[j].ImageDigest = digest
+		}
+	}
+}
+
+// recordBuild adds a build to the history of an environment, keeping the
+// same number of records as the BuildConfig keeps builds
+func recordBuild(history []packagev1alpha1.BuildRecord, record packagev1alpha1.BuildRecord) []packagev1alpha1.BuildRecord {
+	history = append([]packagev1alpha1.BuildRecord{record}, history...)
+	if len(history) > buildHistoryLimit {
+		history = history[:buildHistoryLimit]
+	}
+	return history
+}
+
+// latestBuild returns the most recent OpenShift Build spawned by a
+// BuildConfig, nil when the BuildConfig has no builds
+func latestBuild(ctx context.Context, c client.Client, namespace, buildConfig string) (*buildv1.Build, error) {
+	builds := &buildv1.BuildList{}
+	opts := []client.ListOption{
+		client.InNamespace(namespace),
+		client.MatchingLabels{buildv1.BuildConfigLabel: buildConfig},
+	}
+	if err := c.List(ctx, builds, opts...); err != nil {
+		return nil, err
+	}
+
+	var latest *buildv1.Build
+	for i := range builds.Items {
+		b := &builds.Items[i]
+		if latest == nil || buildNumber(b) > buildNumber(latest) {
+			latest = b
+		}
+	}
+	return latest, nil
+}
+
+// buildNumber returns the sequential number OpenShift gives to the builds of
+// a BuildConfig, falling back to the creation time for builds without one
+func buildNumber(b *buildv1.Build) int64 {
+	if n, err := strconv.ParseInt(b.Annotations[buildv1.BuildNumberAnnotation], 10, 64); err == nil {
+		return n
+	}
+	return b.CreationTimestamp.Unix()
+}
+
+// buildPhaseStatus maps the phase of an OpenShift Build into an InstallStatus
+func buildPhaseStatus(phase buildv1.BuildPhase) packagev1alpha1.InstallStatus {
+	switch phase {
+	case buildv1.BuildPhaseNew:
+		return packagev1alpha1.NewPackage
+	case buildv1.BuildPhasePending:
+		return packagev1alpha1.PendingPackage
+	case buildv1.BuildPhaseRunning:
+		return packagev1alpha1.RunningPackage
+	case buildv1.BuildPhaseComplete:
+		return packagev1alpha1.CompletedPackage
+	case buildv1.BuildPhaseFailed:
+		return packagev1alpha1.FailedPackage
+	case buildv1.BuildPhaseCancelled:
+		return packagev1alpha1.CancelledPackage
+	case buildv1.BuildPhaseError:
+		return packagev1alpha1.ErroredPackage
+	}
+	return packagev1alpha1.NewPackage
+}
+
+// buildReason describes why an OpenShift Build is in its current phase
+func buildReason(b *buildv1.Build) string {
+	// the build script reports the source archives missing from the mirror
+	if line := sourceMissingLine(b.Status.LogSnippet); line != "" {
+		return fmt.Sprintf("%s: %s", b.Name, line)
+	}
+	switch {
+	case b.Status.Message != "":
+		return fmt.Sprintf("%s: %s", b.Name, b.Status.Message)
+	case b.Status.Reason != "":
+		return fmt.Sprintf("%s: %s", b.Name, b.Status.Reason)
+	}
+	return ""
+}
+
+// aggregateStatus returns the state of a Build from the state of its
+// environments, along with the reason of the environment driving it
+func aggregateStatus(envs []packagev1alpha1.EnvironmentStatus) (packagev1alpha1.InstallStatus, string) {
+	for _, state := range statusPriority {
+		for _, env := range envs {
+			if env.State != state {
+				continue
+			}
+			if env.Reason == "" {
+				return state, ""
+			}
+			return state, fmt.Sprintf("environment %s: %s", envDisplayName(env), env.Reason)
+		}
+	}
+	return packagev1alpha1.ValidatedPackage, ""
+}
+
+// envDisplayName identifies an environment and its platform in messages
+func envDisplayName(env packagev1alpha1.EnvironmentStatus) string {
+	p := buildPlatform{Arch: env.Architecture, Target: env.Target}
+	if p.suffix() == "" {
+		return env.Name
+	}
+	return env.Name + " (" + p.suffix() + ")"
+}
+
+// aggregateTimestamps returns the earliest start of the environment builds
+// and, once they have all finished, the latest completion
+func aggregateTimestamps(envs []packagev1alpha1.EnvironmentStatus) (*metav1.Time, *metav1.Time) {
+	var start, completion *metav1.Time
+	finished := len(envs) > 0
+	for _, env := range envs {
+		if env.StartTimestamp != nil && (start == nil || env.StartTimestamp.Before(start)) {
+			start = env.StartTimestamp
+		}
+		if env.CompletionTimestamp == nil {
+			finished = false
+			continue
+		}
+		if completion == nil || completion.Before(env.CompletionTimestamp) {
+			completion = env.CompletionTimestamp
+		}
+	}
+	if !finished {
+		completion = nil
+	}
+	return start, completion
+}
+
+// isFinished returns true when no further build phase change is expected
+func isFinished(state packagev1alpha1.InstallStatus) bool {
+	switch state {
+	case packagev1alpha1.CompletedPackage,
+		packagev1alpha1.FailedPackage,
+		packagev1alpha1.CancelledPackage,
+		packagev1alpha1.ErroredPackage:
+		return true
+	}
+	return false
 }
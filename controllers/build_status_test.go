@@ -0,0 +1,187 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	buildv1 "github.com/openshift/api/build/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	packagev1alpha1 "github.com/ArangoGutierrez/spack-operator/api/v1alpha1"
+)
+
+func TestBuildPhaseStatus(t *testing.T) {
+	tests := []struct {
+		phase buildv1.BuildPhase
+		state packagev1alpha1.InstallStatus
+	}{
+		{buildv1.BuildPhaseNew, packagev1alpha1.NewPackage},
+		{buildv1.BuildPhasePending, packagev1alpha1.PendingPackage},
+		{buildv1.BuildPhaseRunning, packagev1alpha1.RunningPackage},
+		{buildv1.BuildPhaseComplete, packagev1alpha1.CompletedPackage},
+		{buildv1.BuildPhaseFailed, packagev1alpha1.FailedPackage},
+		{buildv1.BuildPhaseCancelled, packagev1alpha1.CancelledPackage},
+		{buildv1.BuildPhaseError, packagev1alpha1.ErroredPackage},
+		{"", packagev1alpha1.NewPackage},
+	}
+	for _, tt := range tests {
+		if state := buildPhaseStatus(tt.phase); state != tt.state {
+			t.Errorf("phase %q: got state %s, want %s", tt.phase, state, tt.state)
+		}
+	}
+}
+
+// openShiftBuild returns the OpenShift Build number n of a BuildConfig
+func openShiftBuild(bc string, n string, phase buildv1.BuildPhase) *buildv1.Build {
+	return &buildv1.Build{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "builds",
+			Name:      bc + "-" + n,
+			Labels:    map[string]string{buildv1.BuildConfigLabel: bc},
+			Annotations: map[string]string{
+				buildv1.BuildNumberAnnotation: n,
+				specHashAnnotation:            "h1",
+				generationAnnotation:          "4",
+			},
+		},
+		Status: buildv1.BuildStatus{Phase: phase},
+	}
+}
+
+func TestOpenShiftUpdateTracksLatestBuild(t *testing.T) {
+	const name = "stack-mpi-buildconfig"
+	bc := &buildv1.BuildConfig{ObjectMeta: metav1.ObjectMeta{
+		Namespace:   "builds",
+		Name:        name,
+		Annotations: map[string]string{specHashAnnotation: "h1"},
+	}}
+	start := metav1.NewTime(time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC))
+	completion := metav1.NewTime(start.Add(time.Hour))
+	failed := openShiftBuild(name, "2", buildv1.BuildPhaseFailed)
+	failed.Status.Message = "spack install failed"
+	failed.Status.StartTimestamp, failed.Status.CompletionTimestamp = &start, &completion
+	r := newTestReconciler(t, bc, openShiftBuild(name, "1", buildv1.BuildPhaseComplete), failed)
+
+	env := &packagev1alpha1.EnvironmentStatus{Name: "mpi", BuildConfig: name, SpecHash: "h1"}
+	b := &openShiftBackend{r: r}
+	if err := b.update(context.Background(), testBuild(), env); err != nil {
+		t.Fatal(err)
+	}
+	if env.State != packagev1alpha1.FailedPackage || env.LatestBuild != failed.Name ||
+		env.Reason != failed.Name+": spack install failed" {
+		t.Errorf("got state %s, latest build %s and reason %q", env.State, env.LatestBuild, env.Reason)
+	}
+	if env.StartTimestamp == nil || !env.StartTimestamp.Equal(&start) ||
+		env.CompletionTimestamp == nil || !env.CompletionTimestamp.Equal(&completion) {
+		t.Errorf("got timestamps %v and %v", env.StartTimestamp, env.CompletionTimestamp)
+	}
+	if env.ObservedGeneration != 4 {
+		t.Errorf("got observed generation %d, want 4", env.ObservedGeneration)
+	}
+}
+
+func TestAggregateStatus(t *testing.T) {
+	envs := []packagev1alpha1.EnvironmentStatus{
+		{Name: "mpi", Architecture: "amd64", State: packagev1alpha1.CompletedPackage},
+		{Name: "mpi", Architecture: "arm64", State: packagev1alpha1.FailedPackage, Reason: "stack-mpi-arm64-buildconfig-1: OOMKilled"},
+		{Name: "python", State: packagev1alpha1.RunningPackage},
+	}
+	state, reason := aggregateStatus(envs)
+	if state != packagev1alpha1.FailedPackage || reason != "environment mpi (arm64): stack-mpi-arm64-buildconfig-1: OOMKilled" {
+		t.Errorf("got state %s and reason %q", state, reason)
+	}
+	if state, _ := aggregateStatus(envs[:1]); state != packagev1alpha1.CompletedPackage {
+		t.Errorf("got state %s for a completed environment", state)
+	}
+}
+
+func TestSetBuildConditions(t *testing.T) {
+	const digest = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	// built returns a Build of generation 3 whose environment is in state
+	built := func(state packagev1alpha1.InstallStatus) *packagev1alpha1.Build {
+		spkg := testBuild()
+		spkg.Default()
+		spkg.Generation = 3
+		setCondition(spkg, packagev1alpha1.ConditionEnvironmentValid, metav1.ConditionTrue, "BuildResourcesFound", "")
+		setCondition(spkg, packagev1alpha1.ConditionBaseImageReady, metav1.ConditionTrue, "TagFound", "")
+		spkg.Status.Environments = []packagev1alpha1.EnvironmentStatus{{Name: "mpi", State: state, ImageDigest: digest}}
+		spkg.Status.Outputs = []packagev1alpha1.OutputStatus{{Name: "mpi", Digest: digest}}
+		return spkg
+	}
+
+	tests := []struct {
+		name       string
+		spkg       *packagev1alpha1.Build
+		conditions map[string]metav1.ConditionStatus
+		reason     string
+	}{
+		{"completed", built(packagev1alpha1.CompletedPackage), map[string]metav1.ConditionStatus{
+			packagev1alpha1.ConditionBuildSucceeded: metav1.ConditionTrue,
+			packagev1alpha1.ConditionImagePushed:    metav1.ConditionTrue,
+			packagev1alpha1.ConditionReady:          metav1.ConditionTrue,
+		}, "Ready"},
+		{"running", built(packagev1alpha1.RunningPackage), map[string]metav1.ConditionStatus{
+			packagev1alpha1.ConditionBuildSucceeded: metav1.ConditionUnknown,
+			packagev1alpha1.ConditionImagePushed:    metav1.ConditionFalse,
+			packagev1alpha1.ConditionReady:          metav1.ConditionFalse,
+		}, "Not" + packagev1alpha1.ConditionBuildSucceeded},
+		{"failed", built(packagev1alpha1.FailedPackage), map[string]metav1.ConditionStatus{
+			packagev1alpha1.ConditionBuildSucceeded: metav1.ConditionFalse,
+			packagev1alpha1.ConditionReady:          metav1.ConditionFalse,
+		}, "Not" + packagev1alpha1.ConditionBuildSucceeded},
+		{"not pushed", func() *packagev1alpha1.Build {
+			spkg := built(packagev1alpha1.CompletedPackage)
+			spkg.Status.Outputs = nil
+			return spkg
+		}(), map[string]metav1.ConditionStatus{
+			packagev1alpha1.ConditionBuildSucceeded: metav1.ConditionTrue,
+			packagev1alpha1.ConditionImagePushed:    metav1.ConditionFalse,
+			packagev1alpha1.ConditionReady:          metav1.ConditionFalse,
+		}, "Not" + packagev1alpha1.ConditionImagePushed},
+	}
+	for _, tt := range tests {
+		setBuildConditions(tt.spkg)
+		for condType, status := range tt.conditions {
+			c := meta.FindStatusCondition(tt.spkg.Status.Conditions, condType)
+			if c == nil || c.Status != status {
+				t.Errorf("%s: got %s condition %+v, want %s", tt.name, condType, c, status)
+			} else if c.ObservedGeneration != 3 {
+				t.Errorf("%s: %s condition observed generation %d, want 3", tt.name, condType, c.ObservedGeneration)
+			}
+		}
+		if c := meta.FindStatusCondition(tt.spkg.Status.Conditions, packagev1alpha1.ConditionReady); c != nil && c.Reason != tt.reason {
+			t.Errorf("%s: got Ready reason %s, want %s", tt.name, c.Reason, tt.reason)
+		}
+		if tt.spkg.Status.ObservedGeneration != 3 {
+			t.Errorf("%s: got observed generation %d, want 3", tt.name, tt.spkg.Status.ObservedGeneration)
+		}
+	}
+
+	install := built(packagev1alpha1.CompletedPackage)
+	install.Spec.Install = &packagev1alpha1.InstallSpec{}
+	setBuildConditions(install)
+	if meta.FindStatusCondition(install.Status.Conditions, packagev1alpha1.ConditionImagePushed) != nil {
+		t.Errorf("install: ImagePushed condition set without images")
+	}
+	if !meta.IsStatusConditionTrue(install.Status.Conditions, packagev1alpha1.ConditionReady) {
+		t.Errorf("install: not Ready: %+v", install.Status.Conditions)
+	}
+}
@@ -0,0 +1,167 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	s "strings"
+	"text/template"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	packagev1alpha1 "github.com/ArangoGutierrez/spack-operator/api/v1alpha1"
+	"github.com/ArangoGutierrez/spack-operator/pkg/controller/multiarch-builder/components"
+)
+
+// buildScriptFile is the key under which the build script is stored in the
+// build-logic configMap of a Build
+const buildScriptFile = "build.sh"
+
+// templateData are the parameters of the build templates
+type templateData struct {
+	// Spec is the spec of the Build
+	Spec packagev1alpha1.BuildSpec
+	// Environment is the name of the Spack environment the Dockerfile is
+	// rendered for, empty when rendering the build script
+	Environment string
+	// Target is the microarchitecture the Dockerfile is rendered for
+	Target string
+	// Builder is the image of the builder stage
+	Builder string
+	// RuntimeImage is the image of the runtime stage, when requested
+	RuntimeImage string
+	// OS is the bootstrap profile of the distribution of the Build
+	OS osProfile
+	// InstallTree is where Spack installs the packages, on the install
+	// claim when the Build installs the environments
+	InstallTree string
+	// LegacyInstallTree is true for the Spack releases before v0.17, whose
+	// install_tree setting is the path instead of a section
+	LegacyInstallTree bool
+	// View is the directory the environment view is linked to, the one of
+	// the environment the Dockerfile is rendered for
+	View string
+	// ProfileScript activates the environment in the runtime images
+	ProfileScript string
+	// SigningKey is the fingerprint of the GPG key signing the packages
+	// pushed to the binary mirror, empty when they are not signed
+	SigningKey string
+	// SigningKeyDir is the directory of the build context holding the
+	// signing key
+	SigningKeyDir string
+	// SourceMirrorURL is the URL the source archives are fetched from
+	SourceMirrorURL string
+	// NoProxy lists the hosts an air-gapped build reaches
+	NoProxy string
+	// SourceMissingReason prefixes the line reporting a source archive
+	// missing from the source mirror
+	SourceMissingReason string
+}
+
+// newTemplateData returns the parameters of the build templates common to
+// every environment of a Build
+func newTemplateData(spkg *packagev1alpha1.Build) templateData {
+	data := templateData{
+		Spec:                spkg.Spec,
+		OS:                  osProfiles[spkg.Spec.OS],
+		InstallTree:         spackInstallTree,
+		View:                defaultSpackView,
+		ProfileScript:       runtimeProfileScript,
+		SigningKeyDir:       signingKeyDir,
+		SourceMissingReason: sourceMissingReason,
+	}
+	if spkg.Spec.Runtime != nil {
+		data.RuntimeImage = runtimeImage(spkg)
+	}
+	if spkg.Spec.Install != nil {
+		data.InstallTree = installTreeDir
+		data.LegacyInstallTree = packagev1alpha1.SpackVersionBefore(spkg.Spec.SpackVersion, "v0.17")
+	}
+	return data
+}
+
+// loadBuildTemplates parses the build templates shipped in the assets
+// directory, overridden by the keys of the configMap the Build references
+func (r *BuildReconciler) loadBuildTemplates(ctx context.Context, spkg *packagev1alpha1.Build) (*template.Template, error) {
+	sources, err := components.LoadTemplates(r.AssetsDir, components.BuildLogic)
+	if err != nil {
+		return nil, err
+	}
+	if ref := spkg.Spec.Templates; ref != nil {
+		cm := &corev1.ConfigMap{}
+		if err := r.Client.Get(ctx, types.NamespacedName{Namespace: spkg.Namespace, Name: ref.Name}, cm); err != nil {
+			return nil, fmt.Errorf("failed to get the templates configMap %s: %v", ref.Name, err)
+		}
+		for name, src := range cm.Data {
+			sources[name] = src
+		}
+	}
+
+	tmpl := template.New(components.BuildLogic).Funcs(template.FuncMap{"join": s.Join, "hasPrefix": s.HasPrefix})
+	for name, src := range sources {
+		if _, err := tmpl.New(name).Parse(src); err != nil {
+			return nil, fmt.Errorf("failed to parse the template %s: %v", name, err)
+		}
+	}
+	for _, name := range []string{components.DockerfileTemplate, components.BuildScriptTemplate} {
+		if tmpl.Lookup(name) == nil {
+			return nil, fmt.Errorf("template %s not found", name)
+		}
+	}
+	return tmpl, nil
+}
+
+// renderTemplate executes the named build template
+func renderTemplate(tmpl *template.Template, name string, data templateData) (string, error) {
+	var buf bytes.Buffer
+	if err := tmpl.ExecuteTemplate(&buf, name, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// buildLogicConfigMap returns the configMap holding the build script of
+// the environments of a Build. It is addressed by its content, a new
+// configMap is created whenever the script changes since configMaps are
+// immutable.
+func buildLogicConfigMap(spkg *packagev1alpha1.Build, tmpl *template.Template, data templateData) (*corev1.ConfigMap, error) {
+	script, err := renderTemplate(tmpl, components.BuildScriptTemplate, data)
+	if err != nil {
+		return nil, err
+	}
+
+	immutable := new(bool)
+	*immutable = true
+
+	return &corev1.ConfigMap{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "ConfigMap",
+			APIVersion: "v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      joinNonEmpty(spkg.Name, "build-logic", hashOf(script)),
+			Namespace: spkg.Namespace,
+			Labels:    map[string]string{buildLabel: spkg.Name},
+		},
+		Immutable: immutable,
+		Data:      map[string]string{buildScriptFile: script},
+	}, nil
+}
@@ -0,0 +1,128 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	s "strings"
+	"testing"
+
+	packagev1alpha1 "github.com/ArangoGutierrez/spack-operator/api/v1alpha1"
+	"github.com/ArangoGutierrez/spack-operator/pkg/controller/multiarch-builder/components"
+)
+
+func TestEnvView(t *testing.T) {
+	tests := []struct {
+		name string
+		env  packagev1alpha1.SpackEnvionment
+		view string
+	}{
+		{"raw", packagev1alpha1.SpackEnvionment{Data: strPtr(testSpackYAML)}, defaultSpackView},
+		{"raw with view", packagev1alpha1.SpackEnvionment{Data: strPtr("spack:\n  specs: [zlib]\n  view: /opt/software\n")}, "/opt/software"},
+		{"legacy raw with view", packagev1alpha1.SpackEnvionment{Data: strPtr("env:\n  specs: [zlib]\n  view: /opt/software\n")}, "/opt/software"},
+		{"structured", packagev1alpha1.SpackEnvionment{Specs: []string{"zlib"}}, defaultSpackView},
+		{"structured with view", packagev1alpha1.SpackEnvionment{Specs: []string{"zlib"}, View: "/opt/software"}, "/opt/software"},
+	}
+	for _, tt := range tests {
+		if view := envView(tt.env); view != tt.view {
+			t.Errorf("%s: got view %s, want %s", tt.name, view, tt.view)
+		}
+	}
+}
+
+func TestDockerfileCopiesEnvView(t *testing.T) {
+	spkg := testBuild()
+	spkg.Default()
+	spkg.Spec.Runtime = &packagev1alpha1.RuntimeSpec{}
+	env := packagev1alpha1.SpackEnvionment{Name: strPtr("mpi"), Specs: []string{"zlib"}, View: "/opt/software"}
+	spkg.Spec.Environment = []packagev1alpha1.SpackEnvionment{env}
+
+	r := newTestReconciler(t, spkg)
+	tmpl, err := r.loadBuildTemplates(context.Background(), spkg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := newTemplateData(spkg)
+	data.View = envView(env)
+	dockerfile, err := renderTemplate(tmpl, components.DockerfileTemplate, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"RUN mkdir -p /opt/software",
+		"RUN /usr/bin/build.sh /opt/software",
+		"COPY --from=builder /opt/software /opt/software",
+	} {
+		if !s.Contains(dockerfile, line+"\n") {
+			t.Errorf("the Dockerfile misses %q:\n%s", line, dockerfile)
+		}
+	}
+}
+
+// TestBuildScriptRunsFromDash runs the build script from dash, the /bin/sh
+// of the Ubuntu images, with stubs of the commands of the base image
+func TestBuildScriptRunsFromDash(t *testing.T) {
+	dash, err := exec.LookPath("dash")
+	if err != nil {
+		t.Skip("dash is not installed")
+	}
+	spkg := testBuild()
+	spkg.Default()
+	r := newTestReconciler(t, spkg)
+	tmpl, err := r.loadBuildTemplates(context.Background(), spkg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	script, err := renderTemplate(tmpl, components.BuildScriptTemplate, newTemplateData(spkg))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "build-script")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	bin, view := filepath.Join(dir, "bin"), filepath.Join(dir, "view")
+	files := map[string]string{
+		"setup-env.sh":   "",
+		"build.sh":       s.NewReplacer("/opt/spack/share/spack/setup-env.sh", filepath.Join(dir, "setup-env.sh"), "cd /opt/spack-environment", "cd "+dir).Replace(script),
+		"bin/spack":      "#!/bin/sh\n",
+		"bin/strip":      "#!/bin/sh\n",
+		"bin/file":       "#!/bin/sh\nfor f; do echo \"$f: application/x-executable; charset=binary\"; done\n",
+		"view/bin/hello": "",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cmd := exec.Command(dash, "-c", `"$0" "$1"`, filepath.Join(dir, "build.sh"), view)
+	cmd.Env = append(os.Environ(), "PATH="+bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Errorf("the build script fails from dash: %v\n%s", err, out)
+	}
+}
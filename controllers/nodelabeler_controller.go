@@ -0,0 +1,173 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	"github.com/ArangoGutierrez/spack-operator/pkg/controller/multiarch-builder/components"
+)
+
+// NodeLabelerReconciler deploys the node-labeler DaemonSet, labeling every
+// node with the archspec microarchitectures its CPU can run
+type NodeLabelerReconciler struct {
+	client.Client
+	Log       logr.Logger
+	Scheme    *runtime.Scheme
+	AssetsDir string
+	// Namespace the node-labeler is deployed to, the one of the operator
+	Namespace string
+	// Image of the node-labeler, defaults to the image of the operator Pod
+	// named PodName since it ships the node-labeler binary
+	Image   string
+	PodName string
+}
+
+// Reconcile applies the node-labeler assets, restoring any of their
+// objects that was modified or deleted
+func (r *NodeLabelerReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	res, err := components.LoadResources(r.AssetsDir, components.NodeLabeler)
+	if err != nil {
+		r.Log.Error(err, "Failed to load the node-labeler assets")
+		return ctrl.Result{}, err
+	}
+	image, err := r.image(ctx)
+	if err != nil {
+		r.Log.Error(err, "Failed to get the node-labeler image")
+		return ctrl.Result{}, err
+	}
+
+	sa := &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: res.ServiceAccount.Name, Namespace: r.Namespace}}
+	if err := r.apply(ctx, sa, func() error {
+		sa.Labels = mergeLabels(sa.Labels, res.ServiceAccount.Labels)
+		return nil
+	}); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	cr := &rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: res.ClusterRole.Name}}
+	if err := r.apply(ctx, cr, func() error {
+		cr.Labels = mergeLabels(cr.Labels, res.ClusterRole.Labels)
+		cr.Rules = res.ClusterRole.Rules
+		return nil
+	}); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	crb := &rbacv1.ClusterRoleBinding{ObjectMeta: metav1.ObjectMeta{Name: res.ClusterRoleBinding.Name}}
+	if err := r.apply(ctx, crb, func() error {
+		crb.Labels = mergeLabels(crb.Labels, res.ClusterRoleBinding.Labels)
+		crb.RoleRef = res.ClusterRoleBinding.RoleRef
+		crb.Subjects = res.ClusterRoleBinding.Subjects
+		for i := range crb.Subjects {
+			crb.Subjects[i].Namespace = r.Namespace
+		}
+		return nil
+	}); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	ds := &appsv1.DaemonSet{ObjectMeta: metav1.ObjectMeta{Name: res.DaemonSet.Name, Namespace: r.Namespace}}
+	if err := r.apply(ctx, ds, func() error {
+		ds.Labels = mergeLabels(ds.Labels, res.DaemonSet.Labels)
+		// the selector of a DaemonSet can only be set on creation
+		if ds.CreationTimestamp.IsZero() {
+			ds.Spec.Selector = res.DaemonSet.Spec.Selector
+		}
+		ds.Spec.Template = res.DaemonSet.Spec.Template
+		for i := range ds.Spec.Template.Spec.Containers {
+			ds.Spec.Template.Spec.Containers[i].Image = image
+		}
+		return nil
+	}); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	return ctrl.Result{}, nil
+}
+
+// apply creates or updates an object of the node-labeler
+func (r *NodeLabelerReconciler) apply(ctx context.Context, obj client.Object, f controllerutil.MutateFn) error {
+	op, err := controllerutil.CreateOrUpdate(ctx, r.Client, obj, f)
+	if err != nil {
+		r.Log.Error(err, "Failed to reconcile the node-labeler", "object", obj.GetName())
+		return err
+	}
+	if op != controllerutil.OperationResultNone {
+		r.Log.Info("node-labeler reconciled", "object", obj.GetName(), "operation", op)
+	}
+	return nil
+}
+
+// image returns the image of the node-labeler
+func (r *NodeLabelerReconciler) image(ctx context.Context) (string, error) {
+	if r.Image != "" {
+		return r.Image, nil
+	}
+
+	pod := &corev1.Pod{}
+	if err := r.Get(ctx, types.NamespacedName{Namespace: r.Namespace, Name: r.PodName}, pod); err != nil {
+		return "", err
+	}
+	for _, c := range pod.Spec.Containers {
+		if c.Name == "manager" {
+			return c.Image, nil
+		}
+	}
+	return "", fmt.Errorf("pod %s has no manager container", r.PodName)
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *NodeLabelerReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	res, err := components.LoadResources(r.AssetsDir, components.NodeLabeler)
+	if err != nil {
+		return err
+	}
+	daemonSet := types.NamespacedName{Namespace: r.Namespace, Name: res.DaemonSet.Name}
+
+	// the node-labeler is not owned by any object, it is deployed on
+	// start up and restored whenever its DaemonSet changes
+	start := make(chan event.GenericEvent, 1)
+	start <- event.GenericEvent{Object: &appsv1.DaemonSet{
+		ObjectMeta: metav1.ObjectMeta{Namespace: daemonSet.Namespace, Name: daemonSet.Name},
+	}}
+
+	return ctrl.NewControllerManagedBy(mgr).
+		Named(components.NodeLabeler).
+		For(&appsv1.DaemonSet{}, builder.WithPredicates(predicate.NewPredicateFuncs(func(obj client.Object) bool {
+			return obj.GetNamespace() == daemonSet.Namespace && obj.GetName() == daemonSet.Name
+		}))).
+		Watches(&source.Channel{Source: start}, &handler.EnqueueRequestForObject{}).
+		Complete(r)
+}
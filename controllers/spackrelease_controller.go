@@ -0,0 +1,370 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	"github.com/go-logr/logr"
+	buildv1 "github.com/openshift/api/build/v1"
+	imagev1 "github.com/openshift/api/image/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	packagev1alpha1 "github.com/ArangoGutierrez/spack-operator/api/v1alpha1"
+)
+
+const (
+	// spackReleaseLabel is the label holding the name of the SpackRelease
+	// a base image BuildConfig belongs to, inherited by its OpenShift Builds
+	spackReleaseLabel = "multiarch.builder.io/spack-release"
+
+	// baseImagePullerRole grants the right to pull the base images, and no
+	// other image of the namespace they are built in
+	baseImagePullerRole = "spack-operator-base-image-puller"
+
+	// baseImagePullerBinding grants every service account of the cluster
+	// the base image puller Role, Builds of any namespace use the base images
+	baseImagePullerBinding = "spack-operator-base-image-pullers"
+
+	// osLabel is the label holding the distribution of a base image
+	// BuildConfig
+	osLabel = "multiarch.builder.io/os"
+)
+
+// SpackReleaseReconciler reconciles a SpackRelease object, building the
+// operator base images of every Spack release
+type SpackReleaseReconciler struct {
+	client.Client
+	Log    logr.Logger
+	Scheme *runtime.Scheme
+	// Namespace the base images are built and stored in, the one of the
+	// operator
+	Namespace string
+	// BaseImageStream is the ImageStream of the base images
+	BaseImageStream string
+	// DefaultOS is the distribution of the base images of the releases
+	// not listing any
+	DefaultOS packagev1alpha1.OperatingSystem
+}
+
+// +kubebuilder:rbac:groups=multiarch.builder.io,resources=spackreleases,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=multiarch.builder.io,resources=spackreleases/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=multiarch.builder.io,resources=spackreleases/finalizers,verbs=update
+
+// Reconcile converges the ImageStream and the BuildConfigs of the base
+// images of a Spack release, and reports the state of their latest builds
+func (r *SpackReleaseReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	_ = r.Log.WithValues("spackrelease", req.Name)
+
+	release := &packagev1alpha1.SpackRelease{}
+	if err := r.Get(ctx, req.NamespacedName, release); err != nil {
+		if errors.IsNotFound(err) {
+			// the BuildConfigs of the release are garbage collected
+			return ctrl.Result{}, nil
+		}
+		r.Log.Error(err, "Failed to get the SpackRelease")
+		return ctrl.Result{}, err
+	}
+
+	if err := r.syncImageStream(ctx); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	status := release.Status.DeepCopy()
+	status.Namespace = r.Namespace
+	status.ObservedGeneration = release.Generation
+	status.Images = []packagev1alpha1.BaseImageStatus{}
+
+	keep := map[string]bool{}
+	for _, os := range release.OperatingSystemsOrDefault(r.DefaultOS) {
+		image, err := r.syncBaseImage(ctx, release, os)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		keep[image.BuildConfig] = true
+		status.Images = append(status.Images, image)
+	}
+	if err := r.pruneBuildConfigs(ctx, release, keep); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	status.State, status.Reason = releaseState(status.Images)
+	if equality.Semantic.DeepEqual(status, &release.Status) {
+		return ctrl.Result{}, nil
+	}
+	status.LastUpdate = metav1.Now()
+	release.Status = *status
+	if err := r.Client.Status().Update(ctx, release); err != nil {
+		r.Log.Error(err, "status update failed")
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{}, nil
+}
+
+// syncBaseImage converges the BuildConfig of the base image of a release on
+// a distribution, and returns the state of its latest build
+func (r *SpackReleaseReconciler) syncBaseImage(ctx context.Context, release *packagev1alpha1.SpackRelease,
+	os packagev1alpha1.OperatingSystem) (packagev1alpha1.BaseImageStatus, error) {
+
+	image := packagev1alpha1.BaseImageStatus{OS: os}
+	profile, err := lookupOSProfile(os)
+	if err != nil {
+		image.State = packagev1alpha1.ErroredPackage
+		image.Reason = err.Error()
+		return image, nil
+	}
+
+	bc := &buildv1.BuildConfig{ObjectMeta: metav1.ObjectMeta{Name: releaseBuildConfigName(release, os), Namespace: r.Namespace}}
+	desired := r.releaseBuildConfig(release, os, profile)
+	op, err := controllerutil.CreateOrUpdate(ctx, r.Client, bc, func() error {
+		mutateBuildConfig(bc, desired)
+		bc.Spec.Source.Git = desired.Spec.Source.Git
+		return controllerutil.SetControllerReference(release, bc, r.Scheme)
+	})
+	if err != nil {
+		r.Log.Error(err, "Failed to reconcile the base image BuildConfig", "release", release.Name, "os", os)
+		return image, err
+	}
+	if op != controllerutil.OperationResultNone {
+		r.Log.Info("BuildConfig reconciled", "buildConfig", bc.Name, "operation", op)
+	}
+	image.Image = desired.Spec.Output.To.Name
+	image.BuildConfig = bc.Name
+
+	// the BuildConfig starts a build on creation and on every change
+	// through its ConfigChange trigger
+	latest, err := latestBuild(ctx, r.Client, r.Namespace, bc.Name)
+	if err != nil {
+		r.Log.Error(err, "Failed to list the builds of the base image", "buildConfig", bc.Name)
+		return image, err
+	}
+	if latest == nil {
+		image.State = packagev1alpha1.NewPackage
+		return image, nil
+	}
+	image.LatestBuild = latest.Name
+	image.State = buildPhaseStatus(latest.Status.Phase)
+	image.Reason = buildReason(latest)
+	if latest.Status.Output.To != nil {
+		image.ImageDigest = latest.Status.Output.To.ImageDigest
+	}
+	return image, nil
+}
+
+// pruneBuildConfigs deletes the BuildConfigs of a release not listed in
+// keep, the ones of the distributions it no longer lists
+func (r *SpackReleaseReconciler) pruneBuildConfigs(ctx context.Context, release *packagev1alpha1.SpackRelease, keep map[string]bool) error {
+	bcs := &buildv1.BuildConfigList{}
+	opts := []client.ListOption{
+		client.InNamespace(r.Namespace),
+		client.MatchingLabels{spackReleaseLabel: release.Name},
+	}
+	if err := r.Client.List(ctx, bcs, opts...); err != nil {
+		return err
+	}
+	for i := range bcs.Items {
+		if keep[bcs.Items[i].Name] {
+			continue
+		}
+		r.Log.Info("Deleting stale BuildConfig", "buildConfig", bcs.Items[i].Name)
+		if err := r.Client.Delete(ctx, &bcs.Items[i], client.PropagationPolicy(metav1.DeletePropagationBackground)); err != nil && !errors.IsNotFound(err) {
+			r.Log.Error(err, "Failed to delete the BuildConfig", "buildConfig", bcs.Items[i].Name)
+			return err
+		}
+	}
+	return nil
+}
+
+// releaseState returns the state of a release from the ones of its base
+// images, the first state found in statusPriority
+func releaseState(images []packagev1alpha1.BaseImageStatus) (packagev1alpha1.InstallStatus, string) {
+	for _, state := range statusPriority {
+		for _, image := range images {
+			if image.State == state {
+				reason := ""
+				if image.Reason != "" {
+					reason = string(image.OS) + ": " + image.Reason
+				}
+				return state, reason
+			}
+		}
+	}
+	return packagev1alpha1.EmptyStatus, ""
+}
+
+// syncImageStream ensures the ImageStream of the base images exists and can
+// be pulled from by the Builds of every namespace, which cannot pull the other
+// ImageStreams of its namespace. They are shared by all the releases and are
+// not owned by any of them.
+func (r *SpackReleaseReconciler) syncImageStream(ctx context.Context) error {
+	labels := map[string]string{"app": "spack-operator"}
+
+	is := &imagev1.ImageStream{ObjectMeta: metav1.ObjectMeta{Name: r.BaseImageStream, Namespace: r.Namespace}}
+	if _, err := controllerutil.CreateOrUpdate(ctx, r.Client, is, func() error {
+		is.Labels = mergeLabels(is.Labels, labels)
+		return nil
+	}); err != nil {
+		r.Log.Error(err, "Failed to reconcile the base ImageStream", "imageStream", r.BaseImageStream)
+		return err
+	}
+
+	// the registry authorizes the pulls on the layers of the ImageStream,
+	// served by both the legacy and the image.openshift.io API groups
+	role := &rbacv1.Role{ObjectMeta: metav1.ObjectMeta{Name: baseImagePullerRole, Namespace: r.Namespace}}
+	if _, err := controllerutil.CreateOrUpdate(ctx, r.Client, role, func() error {
+		role.Labels = mergeLabels(role.Labels, labels)
+		role.Rules = []rbacv1.PolicyRule{{
+			APIGroups:     []string{"", imagev1.GroupName},
+			Resources:     []string{"imagestreams/layers"},
+			ResourceNames: []string{r.BaseImageStream},
+			Verbs:         []string{"get"},
+		}}
+		return nil
+	}); err != nil {
+		r.Log.Error(err, "Failed to reconcile the base image puller Role")
+		return err
+	}
+
+	roleRef := rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "Role", Name: baseImagePullerRole}
+	rb := &rbacv1.RoleBinding{ObjectMeta: metav1.ObjectMeta{Name: baseImagePullerBinding, Namespace: r.Namespace}}
+	// the role of a RoleBinding cannot be changed, the binding of the
+	// system:image-puller ClusterRole of previous releases is recreated
+	err := r.Client.Get(ctx, client.ObjectKeyFromObject(rb), rb)
+	if err == nil && rb.RoleRef != roleRef {
+		r.Log.Info("Deleting the base image pullers RoleBinding of another role", "role", rb.RoleRef.Name)
+		if err := r.Client.Delete(ctx, rb); err != nil && !errors.IsNotFound(err) {
+			r.Log.Error(err, "Failed to delete the base image pullers RoleBinding")
+			return err
+		}
+		rb = &rbacv1.RoleBinding{ObjectMeta: metav1.ObjectMeta{Name: baseImagePullerBinding, Namespace: r.Namespace}}
+	} else if err != nil && !errors.IsNotFound(err) {
+		r.Log.Error(err, "Failed to get the base image pullers RoleBinding")
+		return err
+	}
+	if _, err := controllerutil.CreateOrUpdate(ctx, r.Client, rb, func() error {
+		rb.Labels = mergeLabels(rb.Labels, labels)
+		rb.RoleRef = roleRef
+		rb.Subjects = []rbacv1.Subject{{
+			APIGroup: rbacv1.GroupName,
+			Kind:     rbacv1.GroupKind,
+			Name:     "system:serviceaccounts",
+		}}
+		return nil
+	}); err != nil {
+		r.Log.Error(err, "Failed to reconcile the base image pullers RoleBinding")
+		return err
+	}
+	return nil
+}
+
+// releaseBuildConfig returns the buildConfig producing the base image of a
+// Spack release on a distribution
+func (r *SpackReleaseReconciler) releaseBuildConfig(release *packagev1alpha1.SpackRelease,
+	os packagev1alpha1.OperatingSystem, profile osProfile) *buildv1.BuildConfig {
+	dockerfile := new(string)
+	*dockerfile = baseImageRecipe(profile)
+	limit := int32(buildHistoryLimit)
+
+	bc := &buildv1.BuildConfig{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      releaseBuildConfigName(release, os),
+			Namespace: r.Namespace,
+			Labels: map[string]string{
+				"app":             "spack-operator",
+				spackReleaseLabel: release.Name,
+				osLabel:           string(os),
+			},
+		},
+		Spec: buildv1.BuildConfigSpec{
+			RunPolicy:                    buildv1.BuildRunPolicySerial,
+			SuccessfulBuildsHistoryLimit: &limit,
+			FailedBuildsHistoryLimit:     &limit,
+			Triggers: []buildv1.BuildTriggerPolicy{
+				{Type: buildv1.ConfigChangeBuildTriggerType},
+			},
+			CommonSpec: buildv1.CommonSpec{
+				Strategy: buildv1.BuildStrategy{
+					Type: "Docker",
+					DockerStrategy: &buildv1.DockerBuildStrategy{
+						From: &corev1.ObjectReference{
+							Kind: "DockerImage",
+							Name: profile.Image,
+						},
+					},
+				},
+				Source: buildv1.BuildSource{
+					Type:       "Git",
+					Dockerfile: dockerfile,
+					Git: &buildv1.GitBuildSource{
+						URI: release.GitURIOrDefault(),
+						Ref: release.GitRefOrDefault(),
+					},
+				},
+				Output: buildv1.BuildOutput{
+					To: &corev1.ObjectReference{
+						Kind: "ImageStreamTag",
+						Name: packagev1alpha1.BuildDefaults{BaseImageStream: r.BaseImageStream}.BaseImageTag(release.Spec.Version, os),
+					},
+					ImageLabels: []buildv1.ImageLabel{
+						{Name: "built-by", Value: "multiarch-operator"},
+						{Name: "spack.io/version", Value: release.Spec.Version},
+						{Name: "spack.io/os", Value: string(os)},
+					},
+				},
+			},
+		},
+	}
+	bc.Annotations = map[string]string{specHashAnnotation: hashOf(bc.Spec.CommonSpec)}
+	return bc
+}
+
+// releaseBuildConfigName returns the name of the buildConfig of the base
+// image of a Spack release on a distribution
+func releaseBuildConfigName(release *packagev1alpha1.SpackRelease, os packagev1alpha1.OperatingSystem) string {
+	return joinNonEmpty("spack", release.Name, string(os), "base")
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *SpackReleaseReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&packagev1alpha1.SpackRelease{}).
+		Owns(&buildv1.BuildConfig{}).
+		Watches(&source.Kind{Type: &buildv1.Build{}}, handler.EnqueueRequestsFromMapFunc(spackReleaseRequests)).
+		Complete(r)
+}
+
+// spackReleaseRequests maps an OpenShift Build of a base image to its
+// SpackRelease, using the label inherited from the BuildConfig
+func spackReleaseRequests(obj client.Object) []reconcile.Request {
+	name, ok := obj.GetLabels()[spackReleaseLabel]
+	if !ok {
+		return nil
+	}
+	return []reconcile.Request{{NamespacedName: types.NamespacedName{Name: name}}}
+}
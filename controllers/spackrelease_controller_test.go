@@ -0,0 +1,92 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-logr/logr"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	packagev1alpha1 "github.com/ArangoGutierrez/spack-operator/api/v1alpha1"
+)
+
+func TestReleaseState(t *testing.T) {
+	tests := []struct {
+		name   string
+		images []packagev1alpha1.BaseImageStatus
+		state  packagev1alpha1.InstallStatus
+		reason string
+	}{
+		{"no image", nil, packagev1alpha1.EmptyStatus, ""},
+		{"completed", []packagev1alpha1.BaseImageStatus{
+			{OS: packagev1alpha1.OperatingSystem("fedora"), State: packagev1alpha1.CompletedPackage},
+		}, packagev1alpha1.CompletedPackage, ""},
+		{"running before completed", []packagev1alpha1.BaseImageStatus{
+			{OS: packagev1alpha1.OperatingSystem("fedora"), State: packagev1alpha1.CompletedPackage},
+			{OS: packagev1alpha1.OperatingSystem("ubuntu"), State: packagev1alpha1.RunningPackage},
+		}, packagev1alpha1.RunningPackage, ""},
+		{"failed before running", []packagev1alpha1.BaseImageStatus{
+			{OS: packagev1alpha1.OperatingSystem("fedora"), State: packagev1alpha1.RunningPackage},
+			{OS: packagev1alpha1.OperatingSystem("ubuntu"), State: packagev1alpha1.FailedPackage, Reason: "DockerBuildFailed"},
+		}, packagev1alpha1.FailedPackage, "ubuntu: DockerBuildFailed"},
+	}
+	for _, tt := range tests {
+		state, reason := releaseState(tt.images)
+		if state != tt.state || reason != tt.reason {
+			t.Errorf("%s: got %s %q, want %s %q", tt.name, state, reason, tt.state, tt.reason)
+		}
+	}
+}
+
+func TestSyncImageStreamGrantsBaseImageOnly(t *testing.T) {
+	// the binding of the system:image-puller ClusterRole of previous releases
+	legacy := &rbacv1.RoleBinding{
+		ObjectMeta: metav1.ObjectMeta{Name: baseImagePullerBinding, Namespace: "spack-operator"},
+		RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: "system:image-puller"},
+	}
+	br := newTestReconciler(t, legacy)
+	r := &SpackReleaseReconciler{
+		Client:          br.Client,
+		Log:             logr.Discard(),
+		Scheme:          br.Scheme,
+		Namespace:       "spack-operator",
+		BaseImageStream: "spack",
+	}
+	ctx := context.Background()
+	if err := r.syncImageStream(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	role := &rbacv1.Role{}
+	if err := r.Get(ctx, types.NamespacedName{Namespace: r.Namespace, Name: baseImagePullerRole}, role); err != nil {
+		t.Fatal(err)
+	}
+	if len(role.Rules) != 1 || len(role.Rules[0].ResourceNames) != 1 || role.Rules[0].ResourceNames[0] != "spack" {
+		t.Errorf("the puller Role is not restricted to the base ImageStream: %+v", role.Rules)
+	}
+	rb := &rbacv1.RoleBinding{}
+	if err := r.Get(ctx, types.NamespacedName{Namespace: r.Namespace, Name: baseImagePullerBinding}, rb); err != nil {
+		t.Fatal(err)
+	}
+	if rb.RoleRef.Kind != "Role" || rb.RoleRef.Name != baseImagePullerRole {
+		t.Errorf("the pullers are bound to %s %s", rb.RoleRef.Kind, rb.RoleRef.Name)
+	}
+}
@@ -15,6 +15,7 @@ require (
 	github.com/prometheus/client_golang v1.9.0 // indirect
 	go.uber.org/atomic v1.7.0 // indirect
 	go.uber.org/zap v1.16.0 // indirect
+	golang.org/x/crypto v0.0.0-20201002170205-7f63de1d35b0
 	golang.org/x/net v0.0.0-20201209123823-ac852fbbde11 // indirect
 	golang.org/x/oauth2 v0.0.0-20201208152858-08078c50e5b5 // indirect
 	golang.org/x/tools v0.0.0-20210105154028-b0ab187a4818 // indirect
@@ -26,4 +27,5 @@ require (
 	k8s.io/client-go v0.20.2
 	k8s.io/klog v1.0.0
 	sigs.k8s.io/controller-runtime v0.8.2
+	sigs.k8s.io/yaml v1.2.0
 )
@@ -19,6 +19,7 @@ package main
 import (
 	"flag"
 	"os"
+	s "strings"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
@@ -33,9 +34,11 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
+	configv1alpha1 "github.com/ArangoGutierrez/spack-operator/api/config/v1alpha1"
 	packagev1alpha1 "github.com/ArangoGutierrez/spack-operator/api/v1alpha1"
 	"github.com/ArangoGutierrez/spack-operator/controllers"
 	"github.com/ArangoGutierrez/spack-operator/pkg/controller/multiarch-builder/components"
+	"github.com/ArangoGutierrez/spack-operator/pkg/registry"
 	// +kubebuilder:scaffold:imports
 )
 
@@ -48,6 +51,7 @@ func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
 
 	utilruntime.Must(packagev1alpha1.AddToScheme(scheme))
+	utilruntime.Must(configv1alpha1.AddToScheme(scheme))
 	// +kubebuilder:scaffold:scheme
 }
 
@@ -61,14 +65,45 @@ func main() {
 	//	metav1.NamespaceNone,
 	//}
 
+	var configFile string
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var registryCAFile string
+	var registryTokenFile string
+	var assetsDir string
+	var nodeLabelerImage string
+	var baseImageNamespace string
+	var buildBackend string
+	var imageRegistry string
+	var buildahImage string
+	var kanikoImage string
+	flag.StringVar(&configFile, "config", "",
+		"The controller will load its initial configuration from this file. "+
+			"Omit this flag to use the default configuration values and the flags below.")
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.StringVar(&registryCAFile, "registry-ca-file", "/var/run/secrets/kubernetes.io/serviceaccount/service-ca.crt",
+		"CA bundle trusted when pushing manifest lists to the image registry, ignored when missing. Empty to trust the system CAs only.")
+	flag.StringVar(&registryTokenFile, "registry-token-file", "/var/run/secrets/kubernetes.io/serviceaccount/token",
+		"Token used to authenticate against the image registry, empty to push anonymously.")
+	flag.StringVar(&assetsDir, "assets-dir", components.AssetsDir, "The directory with the manifests of the operands.")
+	flag.StringVar(&nodeLabelerImage, "node-labeler-image", "",
+		"Image of the node-labeler, defaults to the image of the operator.")
+	flag.StringVar(&baseImageNamespace, "base-image-namespace", os.Getenv("POD_NAMESPACE"),
+		"The namespace the base images of the SpackReleases are built in, defaults to the one of the operator.")
+	flag.StringVar(&buildBackend, "build-backend", "",
+		"The backend running the builds of the Builds not selecting one (OpenShift or Kubernetes), overrides the config file.")
+	flag.StringVar(&imageRegistry, "image-registry", "",
+		"The registry the Kubernetes backend pushes the images to, under a repository per namespace and image stream.")
+	flag.StringVar(&buildahImage, "buildah-image", "",
+		"The image running the buildah build Pods of the Kubernetes backend, defaults to the buildah tag of the "+
+			"base image stream in the image registry, or to "+controllers.DefaultBuildahImage+" without an image registry.")
+	flag.StringVar(&kanikoImage, "kaniko-image", controllers.DefaultKanikoImage,
+		"The image running the kaniko build Pods of the Kubernetes backend.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -77,14 +112,29 @@ func main() {
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
-	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
+	var err error
+	operatorConfig := configv1alpha1.OperatorConfig{}
+	options := ctrl.Options{
 		Scheme:                 scheme,
 		MetricsBindAddress:     metricsAddr,
 		Port:                   9443,
 		HealthProbeBindAddress: probeAddr,
 		LeaderElection:         enableLeaderElection,
 		LeaderElectionID:       "44020af5.builder.io",
-	})
+	}
+	if configFile != "" {
+		options, err = ctrl.Options{Scheme: scheme}.AndFrom(ctrl.ConfigFile().AtPath(configFile).OfKind(&operatorConfig))
+		if err != nil {
+			setupLog.Error(err, "unable to load the config file")
+			os.Exit(1)
+		}
+	}
+	if buildBackend != "" {
+		operatorConfig.BuildDefaults.Backend = packagev1alpha1.BuildBackend(buildBackend)
+	}
+	packagev1alpha1.SetBuildDefaults(operatorConfig.BuildDefaults)
+
+	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), options)
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
 		os.Exit(1)
@@ -101,15 +151,79 @@ func main() {
 		os.Exit(1)
 	}
 
+	// the buildah image of config/manifests/buildah_base_image.yaml
+	if buildahImage == "" && imageRegistry != "" && baseImageNamespace != "" {
+		buildahImage = s.TrimSuffix(imageRegistry, "/") + "/" + baseImageNamespace + "/" +
+			packagev1alpha1.CurrentBuildDefaults().BaseImageStream + ":" + controllers.BuildahImageTag
+	}
+
+	registryClient, err := registry.NewClient(registryCAFile, registryTokenFile)
+	if err != nil {
+		setupLog.Error(err, "unable to create the image registry client")
+		os.Exit(1)
+	}
+
 	if err = (&controllers.BuildReconciler{
 		Client:    mgr.GetClient(),
 		Log:       ctrl.Log.WithName("controllers").WithName("multiarch-builder"),
 		Scheme:    mgr.GetScheme(),
-		AssetsDir: components.AssetsDir,
+		AssetsDir: assetsDir,
+		Registry:  registryClient,
+		APIReader: mgr.GetAPIReader(),
+
+		ImageRegistry: imageRegistry,
+		BuildahImage:  buildahImage,
+		KanikoImage:   kanikoImage,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "multiarch-builder")
 		os.Exit(1)
 	}
+
+	if baseImageNamespace != "" {
+		if err = (&controllers.SpackReleaseReconciler{
+			Client:          mgr.GetClient(),
+			Log:             ctrl.Log.WithName("controllers").WithName("spack-release"),
+			Scheme:          mgr.GetScheme(),
+			Namespace:       baseImageNamespace,
+			BaseImageStream: packagev1alpha1.CurrentBuildDefaults().BaseImageStream,
+			DefaultOS:       packagev1alpha1.CurrentBuildDefaults().OS,
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "spack-release")
+			os.Exit(1)
+		}
+	} else {
+		setupLog.Info("no base image namespace, the SpackReleases are not reconciled")
+	}
+
+	if os.Getenv("ENABLE_WEBHOOKS") != "false" {
+		if err = (&packagev1alpha1.Build{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "Build")
+			os.Exit(1)
+		}
+		if err = (&packagev1alpha1.SpackRelease{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "SpackRelease")
+			os.Exit(1)
+		}
+	}
+
+	// the node-labeler is deployed next to the operator, which is not
+	// the case when running out of the cluster
+	if namespace := os.Getenv("POD_NAMESPACE"); namespace != "" {
+		if err = (&controllers.NodeLabelerReconciler{
+			Client:    mgr.GetClient(),
+			Log:       ctrl.Log.WithName("controllers").WithName("node-labeler"),
+			Scheme:    mgr.GetScheme(),
+			AssetsDir: assetsDir,
+			Namespace: namespace,
+			Image:     nodeLabelerImage,
+			PodName:   os.Getenv("POD_NAME"),
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "node-labeler")
+			os.Exit(1)
+		}
+	} else {
+		setupLog.Info("POD_NAMESPACE is not set, the node-labeler is not deployed")
+	}
 	// +kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("health", healthz.Ping); err != nil {
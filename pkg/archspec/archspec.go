@@ -0,0 +1,174 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package archspec describes the archspec (https://github.com/archspec/archspec)
+// microarchitectures Spack can target, and the node labels advertising them.
+package archspec
+
+import (
+	"sort"
+	"strings"
+)
+
+const (
+	// TargetLabel is the label holding the archspec microarchitecture of
+	// a node, or of the image built for it
+	TargetLabel = "archspec.io/cpu.target"
+
+	// CompatibleLabelPrefix prefixes the labels advertising each
+	// microarchitecture a node can run binaries built for
+	CompatibleLabelPrefix = "compatible.archspec.io/"
+)
+
+// Microarchitecture is an archspec microarchitecture
+type Microarchitecture struct {
+	// Name of the microarchitecture in archspec
+	Name string
+	// Architecture is the CPU architecture as reported by the
+	// kubernetes.io/arch node label
+	Architecture string
+	// Vendor of the CPUs implementing the microarchitecture, as reported
+	// in /proc/cpuinfo, or "generic" when it is vendor independent
+	Vendor string
+	// Parents are the microarchitectures this one is a superset of
+	Parents []string
+	// Features are the CPU flags the microarchitecture requires on top of
+	// the ones of its parents, as reported in /proc/cpuinfo
+	Features []string
+	// Generation of the POWER processors, which do not report features
+	Generation int
+}
+
+const (
+	generic = "generic"
+	intel   = "GenuineIntel"
+	amd     = "AuthenticAMD"
+	arm     = "ARM"
+	cavium  = "Cavium"
+	fujitsu = "Fujitsu"
+	ibm     = "IBM"
+)
+
+// Microarchitectures indexes the known microarchitectures by name
+var Microarchitectures = map[string]Microarchitecture{}
+
+func init() {
+	f := strings.Fields
+	for _, m := range []Microarchitecture{
+		// x86_64
+		{"x86_64", "amd64", generic, nil, nil, 0},
+		{"x86_64_v2", "amd64", generic, f("x86_64"), f("cx16 lahf_lm popcnt sse4_1 sse4_2 ssse3"), 0},
+		{"x86_64_v3", "amd64", generic, f("x86_64_v2"), f("avx avx2 bmi1 bmi2 f16c fma abm movbe xsave"), 0},
+		{"x86_64_v4", "amd64", generic, f("x86_64_v3"), f("avx512f avx512bw avx512cd avx512dq avx512vl"), 0},
+		{"nocona", "amd64", intel, f("x86_64"), f("mmx sse sse2 pni"), 0},
+		{"core2", "amd64", intel, f("nocona"), f("ssse3"), 0},
+		{"nehalem", "amd64", intel, f("core2 x86_64_v2"), f("sse4_1 sse4_2 popcnt"), 0},
+		{"westmere", "amd64", intel, f("nehalem"), f("aes pclmulqdq"), 0},
+		{"sandybridge", "amd64", intel, f("westmere"), f("avx"), 0},
+		{"ivybridge", "amd64", intel, f("sandybridge"), f("rdrand f16c"), 0},
+		{"haswell", "amd64", intel, f("ivybridge x86_64_v3"), f("movbe fma avx2 bmi1 bmi2"), 0},
+		{"broadwell", "amd64", intel, f("haswell"), f("rdseed adx"), 0},
+		{"skylake", "amd64", intel, f("broadwell"), f("clflushopt xsavec xsaveopt"), 0},
+		{"skylake_avx512", "amd64", intel, f("skylake x86_64_v4"), f("avx512f clwb avx512vl avx512bw avx512dq avx512cd"), 0},
+		{"cascadelake", "amd64", intel, f("skylake_avx512"), f("avx512_vnni"), 0},
+		{"icelake", "amd64", intel, f("cascadelake"), f("avx512_vbmi2 avx512_bitalg avx512_vpopcntdq gfni vaes vpclmulqdq sha_ni"), 0},
+		{"sapphirerapids", "amd64", intel, f("icelake"), f("amx_bf16 amx_int8 amx_tile avx512_bf16 avx512_fp16 serialize"), 0},
+		{"zen", "amd64", amd, f("x86_64_v3"), f("bmi1 bmi2 f16c fma fsgsbase avx avx2 rdseed clzero aes pclmulqdq cx16 movbe mmx sse sse2 sse4a ssse3 sse4_1 sse4_2 abm xsavec xsaveopt clflushopt popcnt"), 0},
+		{"zen2", "amd64", amd, f("zen"), f("clwb"), 0},
+		{"zen3", "amd64", amd, f("zen2"), f("pku vaes vpclmulqdq"), 0},
+		{"zen4", "amd64", amd, f("zen3 x86_64_v4"), f("avx512f avx512bw avx512cd avx512dq avx512vl avx512_bf16 avx512_vnni"), 0},
+		// aarch64
+		{"aarch64", "arm64", generic, nil, nil, 0},
+		{"armv8.1a", "arm64", generic, f("aarch64"), f("crc32 atomics asimdrdm"), 0},
+		{"armv8.2a", "arm64", generic, f("armv8.1a"), f("fphp asimdhp dcpop"), 0},
+		{"armv8.3a", "arm64", generic, f("armv8.2a"), f("jscvt fcma lrcpc paca pacg"), 0},
+		{"armv8.4a", "arm64", generic, f("armv8.3a"), f("dit uscat ilrcpc flagm"), 0},
+		{"armv8.5a", "arm64", generic, f("armv8.4a"), f("sb ssbs dcpodp flagm2 frint"), 0},
+		{"cortex_a72", "arm64", arm, f("aarch64"), f("fp asimd evtstrm aes pmull sha1 sha2 crc32 cpuid"), 0},
+		{"thunderx2", "arm64", cavium, f("armv8.1a"), f("fp asimd aes pmull sha1 sha2 cpuid"), 0},
+		{"neoverse_n1", "arm64", arm, f("cortex_a72 armv8.2a"), f("asimddp ssbs"), 0},
+		{"neoverse_v1", "arm64", arm, f("neoverse_n1 armv8.4a"), f("sve sha3 sm3 sm4 sha512 asimdfhm svei8mm svebf16 i8mm bf16 rng"), 0},
+		{"neoverse_n2", "arm64", arm, f("neoverse_n1 armv8.5a"), f("sve sve2 svebitperm svei8mm svebf16 i8mm bf16 asimdfhm"), 0},
+		{"neoverse_v2", "arm64", arm, f("neoverse_n2"), nil, 0},
+		{"a64fx", "arm64", fujitsu, f("armv8.2a"), f("fp asimd evtstrm aes pmull sha1 sha2 cpuid sve"), 0},
+		{"graviton", "arm64", arm, f("cortex_a72"), nil, 0},
+		{"graviton2", "arm64", arm, f("graviton neoverse_n1"), nil, 0},
+		{"graviton3", "arm64", arm, f("graviton2 neoverse_v1"), nil, 0},
+		// ppc64le
+		{"ppc64le", "ppc64le", generic, nil, nil, 0},
+		{"power8le", "ppc64le", ibm, f("ppc64le"), nil, 8},
+		{"power9le", "ppc64le", ibm, f("power8le"), nil, 9},
+		{"power10le", "ppc64le", ibm, f("power9le"), nil, 10},
+		// s390x
+		{"s390x", "s390x", generic, nil, nil, 0},
+	} {
+		Microarchitectures[m.Name] = m
+	}
+}
+
+// Lookup returns the microarchitecture with the given name
+func Lookup(name string) (Microarchitecture, bool) {
+	m, ok := Microarchitectures[name]
+	return m, ok
+}
+
+// Ancestors returns the names of the microarchitectures m is a superset
+// of, itself included
+func (m Microarchitecture) Ancestors() []string {
+	seen := map[string]bool{}
+	var visit func(string)
+	visit = func(n string) {
+		p, ok := Microarchitectures[n]
+		if !ok || seen[n] {
+			return
+		}
+		seen[n] = true
+		for _, parent := range p.Parents {
+			visit(parent)
+		}
+	}
+	visit(m.Name)
+
+	names := make([]string, 0, len(seen))
+	for n := range seen {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// isAlias returns true when m is another name for one of its parents,
+// requiring nothing more than them
+func (m Microarchitecture) isAlias() bool {
+	return len(m.Parents) > 0 && len(m.Features) == 0 && m.Generation == 0
+}
+
+// CompatibleLabel returns the node label advertising that a node can run
+// binaries built for the given microarchitecture
+func CompatibleLabel(name string) string {
+	return CompatibleLabelPrefix + name
+}
+
+// Variant returns the OCI platform variant of the images built for the
+// given microarchitecture, the x86-64 microarchitecture levels map to amd64
+// variants and the other microarchitectures have none
+func Variant(name string) string {
+	switch name {
+	case "x86_64_v2", "x86_64_v3", "x86_64_v4":
+		return name[len("x86_64_"):]
+	}
+	return ""
+}
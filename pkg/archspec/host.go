@@ -0,0 +1,145 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package archspec
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Host describes the CPU of a node as reported in /proc/cpuinfo
+type Host struct {
+	// Architecture is the CPU architecture as reported by the
+	// kubernetes.io/arch node label
+	Architecture string
+	Vendor       string
+	Features     map[string]bool
+	Generation   int
+}
+
+// armImplementers maps the "CPU implementer" codes of /proc/cpuinfo to
+// the archspec vendors
+var armImplementers = map[string]string{
+	"0x41": arm,
+	"0x42": cavium,
+	"0x43": cavium,
+	"0x46": fujitsu,
+}
+
+var powerGeneration = regexp.MustCompile(`POWER(\d+)`)
+
+// ParseCPUInfo describes a host of the given architecture from the
+// content of its /proc/cpuinfo. Only the first processor is considered,
+// the others being assumed identical.
+func ParseCPUInfo(arch string, cpuinfo io.Reader) (*Host, error) {
+	info := map[string]string{}
+	scanner := bufio.NewScanner(cpuinfo)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		kv := strings.SplitN(scanner.Text(), ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		key := strings.TrimSpace(kv[0])
+		if _, ok := info[key]; !ok {
+			info[key] = strings.TrimSpace(kv[1])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	host := &Host{Architecture: arch, Vendor: generic, Features: map[string]bool{}}
+	switch arch {
+	case "amd64":
+		host.Vendor = info["vendor_id"]
+		for _, flag := range strings.Fields(info["flags"]) {
+			host.Features[flag] = true
+		}
+	case "arm64":
+		if vendor, ok := armImplementers[info["CPU implementer"]]; ok {
+			host.Vendor = vendor
+		}
+		for _, feature := range strings.Fields(info["Features"]) {
+			host.Features[feature] = true
+		}
+	case "ppc64le":
+		if m := powerGeneration.FindStringSubmatch(info["cpu"]); m != nil {
+			host.Vendor = ibm
+			host.Generation, _ = strconv.Atoi(m[1])
+		}
+	case "s390x":
+	default:
+		return nil, fmt.Errorf("unsupported architecture %q", arch)
+	}
+	return host, nil
+}
+
+// Compatible returns the names of the microarchitectures the host can run
+// binaries built for, sorted by name
+func (h *Host) Compatible() []string {
+	compatible := map[string]bool{}
+	var check func(string) bool
+	check = func(name string) bool {
+		if ok, seen := compatible[name]; seen {
+			return ok
+		}
+		m := Microarchitectures[name]
+		ok := m.Architecture == h.Architecture &&
+			(m.Vendor == generic || m.Vendor == h.Vendor) &&
+			m.Generation <= h.Generation
+		for _, feature := range m.Features {
+			ok = ok && h.Features[feature]
+		}
+		for _, parent := range m.Parents {
+			ok = ok && check(parent)
+		}
+		compatible[name] = ok
+		return ok
+	}
+
+	names := []string{}
+	for name := range Microarchitectures {
+		if check(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Target returns the most specific microarchitecture the host can run
+// binaries built for, the one with the most ancestors. Aliases are never
+// picked, the microarchitecture they are another name for is.
+func (h *Host) Target() string {
+	target, depth := "", 0
+	for _, name := range h.Compatible() {
+		m := Microarchitectures[name]
+		if m.isAlias() {
+			continue
+		}
+		if d := len(m.Ancestors()); d > depth {
+			target, depth = name, d
+		}
+	}
+	return target
+}
@@ -0,0 +1,101 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package archspec
+
+import (
+	"strings"
+	"testing"
+)
+
+const (
+	haswellFlags = "fpu vme de pse tsc msr pae mce cx8 apic sep mtrr pge mca cmov pat pse36 clflush " +
+		"mmx fxsr sse sse2 ss ht syscall nx pdpe1gb rdtscp lm constant_tsc rep_good nopl xtopology " +
+		"cpuid pni pclmulqdq ssse3 fma cx16 pcid sse4_1 sse4_2 x2apic movbe popcnt aes xsave avx " +
+		"f16c rdrand hypervisor lahf_lm abm invpcid_single fsgsbase bmi1 avx2 smep bmi2 erms invpcid xsaveopt"
+	skylakeAVX512Flags = haswellFlags + " rdseed adx clflushopt clwb xsavec avx512f avx512dq avx512cd avx512bw avx512vl"
+	zen2Flags          = "fpu vme de pse tsc msr pae mce cx8 apic sep mtrr pge mca cmov pat pse36 clflush mmx " +
+		"fxsr fxsr_opt sse sse2 ht syscall nx mmxext pdpe1gb rdtscp lm pni pclmulqdq ssse3 fma cx16 sse4_1 " +
+		"sse4_2 movbe popcnt aes xsave avx f16c rdrand lahf_lm cmp_legacy abm sse4a misalignsse fsgsbase " +
+		"bmi1 avx2 bmi2 rdseed adx clflushopt clwb sha_ni xsaveopt xsavec clzero"
+	neoverseN1Features = "fp asimd evtstrm aes pmull sha1 sha2 crc32 atomics fphp asimdhp cpuid asimdrdm " +
+		"lrcpc dcpop asimddp ssbs"
+)
+
+func TestHostTarget(t *testing.T) {
+	tests := []struct {
+		arch     string
+		cpuinfo  string
+		target   string
+		compat   []string
+		incompat []string
+	}{
+		{
+			"amd64", "processor\t: 0\nvendor_id\t: GenuineIntel\nflags\t\t: " + haswellFlags + "\n",
+			"haswell", []string{"x86_64_v3", "ivybridge"}, []string{"x86_64_v4", "zen", "broadwell"},
+		},
+		{
+			"amd64", "vendor_id\t: GenuineIntel\nflags\t\t: " + skylakeAVX512Flags + "\n",
+			"skylake_avx512", []string{"x86_64_v4", "haswell"}, []string{"cascadelake"},
+		},
+		{
+			"amd64", "vendor_id\t: AuthenticAMD\nflags\t\t: " + zen2Flags + "\n",
+			"zen2", []string{"zen", "x86_64_v3"}, []string{"haswell", "zen3"},
+		},
+		{
+			"arm64", "processor\t: 0\nFeatures\t: " + neoverseN1Features + "\nCPU implementer\t: 0x41\nCPU part\t: 0xd0c\n",
+			"neoverse_n1", []string{"graviton2", "armv8.2a", "cortex_a72"}, []string{"neoverse_v1", "armv8.3a", "a64fx"},
+		},
+		{
+			"ppc64le", "processor\t: 0\ncpu\t\t: POWER9 (architected), altivec supported\n",
+			"power9le", []string{"power8le", "ppc64le"}, []string{"power10le"},
+		},
+		{
+			"s390x", "vendor_id       : IBM/S390\n",
+			"s390x", []string{"s390x"}, []string{"x86_64"},
+		},
+	}
+
+	for _, tt := range tests {
+		host, err := ParseCPUInfo(tt.arch, strings.NewReader(tt.cpuinfo))
+		if err != nil {
+			t.Fatalf("ParseCPUInfo(%s): %v", tt.arch, err)
+		}
+		if got := host.Target(); got != tt.target {
+			t.Errorf("Target() = %q, want %q", got, tt.target)
+		}
+		compatible := map[string]bool{}
+		for _, name := range host.Compatible() {
+			compatible[name] = true
+		}
+		for _, name := range append(tt.compat, tt.target) {
+			if !compatible[name] {
+				t.Errorf("%s host is not compatible with %s", tt.target, name)
+			}
+		}
+		for _, name := range tt.incompat {
+			if compatible[name] {
+				t.Errorf("%s host is compatible with %s", tt.target, name)
+			}
+		}
+	}
+}
+
+func TestParseCPUInfoUnsupported(t *testing.T) {
+	if _, err := ParseCPUInfo("mips64le", strings.NewReader("")); err == nil {
+		t.Error("expected an error for an unsupported architecture")
+	}
+}
@@ -1 +1,103 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
 package components
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+)
+
+// Resources holds the objects of an operand, decoded from the manifests
+// of its directory under the assets directory
+type Resources struct {
+	ServiceAccount     *corev1.ServiceAccount
+	ClusterRole        *rbacv1.ClusterRole
+	ClusterRoleBinding *rbacv1.ClusterRoleBinding
+	DaemonSet          *appsv1.DaemonSet
+}
+
+// LoadResources decodes the manifests of the operand in the given
+// directory under assetsDir
+func LoadResources(assetsDir, operand string) (*Resources, error) {
+	dir := filepath.Join(assetsDir, operand)
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, f := range files {
+		if !f.IsDir() {
+			names = append(names, f.Name())
+		}
+	}
+	sort.Strings(names)
+
+	res := &Resources{}
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		obj, _, err := scheme.Codecs.UniversalDeserializer().Decode(data, nil, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode %s: %v", path, err)
+		}
+		switch o := obj.(type) {
+		case *corev1.ServiceAccount:
+			res.ServiceAccount = o
+		case *rbacv1.ClusterRole:
+			res.ClusterRole = o
+		case *rbacv1.ClusterRoleBinding:
+			res.ClusterRoleBinding = o
+		case *appsv1.DaemonSet:
+			res.DaemonSet = o
+		default:
+			return nil, fmt.Errorf("unsupported object %T in %s", obj, path)
+		}
+	}
+	return res, nil
+}
+
+// LoadTemplates returns the content of the templates of the operand in the
+// given directory under assetsDir, indexed by file name
+func LoadTemplates(assetsDir, operand string) (map[string]string, error) {
+	dir := filepath.Join(assetsDir, operand)
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	templates := map[string]string{}
+	for _, f := range files {
+		if f.IsDir() {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(dir, f.Name()))
+		if err != nil {
+			return nil, err
+		}
+		templates[f.Name()] = string(data)
+	}
+	return templates, nil
+}
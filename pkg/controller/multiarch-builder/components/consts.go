@@ -3,4 +3,16 @@ package components
 const (
 	// AssetsDir defines the directory with assets under the operator image
 	AssetsDir = "/assets"
+
+	// NodeLabeler is the directory of the node-labeler assets
+	NodeLabeler = "node-labeler"
+
+	// BuildLogic is the directory of the templates of the build logic
+	BuildLogic = "build-logic"
+
+	// DockerfileTemplate renders the Dockerfile of the environment images
+	DockerfileTemplate = "Dockerfile.tmpl"
+
+	// BuildScriptTemplate renders the script installing the environments
+	BuildScriptTemplate = "build.sh.tmpl"
 )
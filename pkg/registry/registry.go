@@ -0,0 +1,495 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package registry implements the small subset of the OCI distribution API
+// the operator needs to assemble multi-architecture images: resolving
+// manifests, pushing image indexes (manifest lists) and tagging manifests.
+package registry
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Media types of the manifests handled by the client
+const (
+	MediaTypeDockerManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+	MediaTypeDockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	MediaTypeOCIManifest        = "application/vnd.oci.image.manifest.v1+json"
+	MediaTypeOCIIndex           = "application/vnd.oci.image.index.v1+json"
+)
+
+// acceptedMediaTypes are the manifest media types the client resolves
+var acceptedMediaTypes = []string{
+	MediaTypeOCIIndex,
+	MediaTypeOCIManifest,
+	MediaTypeDockerManifestList,
+	MediaTypeDockerManifest,
+}
+
+// Reference points to a manifest in a registry repository, by tag or digest
+type Reference struct {
+	// Registry is the host (and port) of the registry
+	Registry string
+	// Repository is the path of the repository in the registry
+	Repository string
+	// Reference is a tag or a digest
+	Reference string
+}
+
+// ParseReference parses an image reference of the form
+// registry/repository:tag or registry/repository@digest
+func ParseReference(s string) (Reference, error) {
+	i := strings.Index(s, "/")
+	if i <= 0 {
+		return Reference{}, fmt.Errorf("image reference %q has no registry", s)
+	}
+	ref := Reference{Registry: s[:i]}
+	repo := s[i+1:]
+
+	switch at := strings.Index(repo, "@"); {
+	case at >= 0:
+		ref.Repository, ref.Reference = repo[:at], repo[at+1:]
+	case strings.LastIndex(repo, ":") > strings.LastIndex(repo, "/"):
+		colon := strings.LastIndex(repo, ":")
+		ref.Repository, ref.Reference = repo[:colon], repo[colon+1:]
+	default:
+		ref.Repository, ref.Reference = repo, "latest"
+	}
+	if ref.Repository == "" || ref.Reference == "" {
+		return Reference{}, fmt.Errorf("invalid image reference %q", s)
+	}
+	return ref, nil
+}
+
+// String returns the image reference
+func (r Reference) String() string {
+	if strings.Contains(r.Reference, ":") {
+		return r.Registry + "/" + r.Repository + "@" + r.Reference
+	}
+	return r.Registry + "/" + r.Repository + ":" + r.Reference
+}
+
+// Platform describes the platform an image runs on
+type Platform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+// Descriptor describes a manifest stored in a registry
+type Descriptor struct {
+	MediaType string    `json:"mediaType"`
+	Digest    string    `json:"digest"`
+	Size      int64     `json:"size"`
+	Platform  *Platform `json:"platform,omitempty"`
+}
+
+// Index is an OCI image index or a Docker manifest list
+type Index struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType"`
+	Manifests     []Descriptor `json:"manifests"`
+}
+
+// Client talks to OCI distribution registries
+type Client struct {
+	// HTTPClient performs the requests, http.DefaultClient when nil
+	HTTPClient *http.Client
+	// Credentials returns the username and password used to authenticate
+	// against the registry, requests are anonymous when nil
+	Credentials func() (username, password string, err error)
+	// PlainHTTP talks to the registries without TLS
+	PlainHTTP bool
+}
+
+// NewClient returns a client trusting the CAs in caFile on top of the system
+// ones and authenticating with the token stored in tokenFile, as OpenShift
+// service accounts do against the integrated registry. Empty paths are
+// ignored, as well as a missing caFile: the service CA is only mounted in
+// the Pods of OpenShift clusters.
+func NewClient(caFile, tokenFile string) (*Client, error) {
+	c := &Client{}
+
+	if caFile != "" {
+		pem, err := ioutil.ReadFile(caFile)
+		if os.IsNotExist(err) {
+			return c.withToken(tokenFile), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %s", caFile)
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+		c.HTTPClient = &http.Client{Transport: transport}
+	}
+
+	return c.withToken(tokenFile), nil
+}
+
+// withToken authenticates c with the token stored in tokenFile, when given
+func (c *Client) withToken(tokenFile string) *Client {
+	if tokenFile != "" {
+		c.Credentials = func() (string, string, error) {
+			// service account tokens are rotated, read it on every use
+			token, err := ioutil.ReadFile(tokenFile)
+			if err != nil {
+				return "", "", err
+			}
+			return "serviceaccount", strings.TrimSpace(string(token)), nil
+		}
+	}
+	return c
+}
+
+// Resolve returns the descriptor of the manifest a reference points to
+func (c *Client) Resolve(ctx context.Context, ref Reference) (Descriptor, error) {
+	resp, err := c.do(ctx, ref, func() (*http.Request, error) {
+		req, err := http.NewRequest(http.MethodHead, c.manifestURL(ref), nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", strings.Join(acceptedMediaTypes, ", "))
+		return req, nil
+	})
+	if err != nil {
+		return Descriptor{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Descriptor{}, fmt.Errorf("resolving %s: unexpected status %s", ref, resp.Status)
+	}
+
+	desc := Descriptor{
+		MediaType: resp.Header.Get("Content-Type"),
+		Digest:    resp.Header.Get("Docker-Content-Digest"),
+	}
+	if desc.Size, err = strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64); err != nil {
+		return Descriptor{}, fmt.Errorf("resolving %s: invalid content length: %v", ref, err)
+	}
+	if desc.Digest == "" {
+		if !strings.HasPrefix(ref.Reference, "sha256:") {
+			return Descriptor{}, fmt.Errorf("resolving %s: registry did not return a digest", ref)
+		}
+		desc.Digest = ref.Reference
+	}
+	return desc, nil
+}
+
+// PushIndex pushes an index referencing the given manifests to a reference
+// and returns its descriptor. The index is a Docker manifest list when every
+// manifest is a Docker manifest, an OCI image index otherwise.
+func (c *Client) PushIndex(ctx context.Context, ref Reference, manifests []Descriptor) (Descriptor, error) {
+	index := Index{
+		SchemaVersion: 2,
+		MediaType:     IndexMediaType(manifests),
+		Manifests:     manifests,
+	}
+	body, err := json.Marshal(index)
+	if err != nil {
+		return Descriptor{}, err
+	}
+
+	resp, err := c.do(ctx, ref, func() (*http.Request, error) {
+		req, err := http.NewRequest(http.MethodPut, c.manifestURL(ref), bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", index.MediaType)
+		return req, nil
+	})
+	if err != nil {
+		return Descriptor{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+		return Descriptor{}, fmt.Errorf("pushing %s: unexpected status %s: %s", ref, resp.Status, msg)
+	}
+
+	sum := sha256.Sum256(body)
+	return Descriptor{
+		MediaType: index.MediaType,
+		Digest:    "sha256:" + hex.EncodeToString(sum[:]),
+		Size:      int64(len(body)),
+	}, nil
+}
+
+// Tag pushes the manifest a reference points to under a tag of the same
+// repository and returns its descriptor
+func (c *Client) Tag(ctx context.Context, src Reference, tag string) (Descriptor, error) {
+	resp, err := c.do(ctx, src, func() (*http.Request, error) {
+		req, err := http.NewRequest(http.MethodGet, c.manifestURL(src), nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", strings.Join(acceptedMediaTypes, ", "))
+		return req, nil
+	})
+	if err != nil {
+		return Descriptor{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Descriptor{}, fmt.Errorf("fetching %s: unexpected status %s", src, resp.Status)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return Descriptor{}, fmt.Errorf("fetching %s: %v", src, err)
+	}
+	mediaType := resp.Header.Get("Content-Type")
+	sum := sha256.Sum256(body)
+	digest := "sha256:" + hex.EncodeToString(sum[:])
+	if strings.HasPrefix(src.Reference, "sha256:") && src.Reference != digest {
+		return Descriptor{}, fmt.Errorf("fetching %s: got manifest %s", src, digest)
+	}
+
+	dst := Reference{Registry: src.Registry, Repository: src.Repository, Reference: tag}
+	put, err := c.do(ctx, dst, func() (*http.Request, error) {
+		req, err := http.NewRequest(http.MethodPut, c.manifestURL(dst), bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", mediaType)
+		return req, nil
+	})
+	if err != nil {
+		return Descriptor{}, err
+	}
+	defer put.Body.Close()
+	if put.StatusCode != http.StatusCreated && put.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(io.LimitReader(put.Body, 4096))
+		return Descriptor{}, fmt.Errorf("pushing %s: unexpected status %s: %s", dst, put.Status, msg)
+	}
+
+	return Descriptor{
+		MediaType: mediaType,
+		Digest:    digest,
+		Size:      int64(len(body)),
+	}, nil
+}
+
+// DockerConfigCredentials returns the username and password of a registry
+// in a Docker config.json, as stored in kubernetes.io/dockerconfigjson
+// Secrets. They are empty when the config has no entry for the registry.
+func DockerConfigCredentials(config []byte, registry string) (string, string, error) {
+	var parsed struct {
+		Auths map[string]struct {
+			Auth     string `json:"auth"`
+			Username string `json:"username"`
+			Password string `json:"password"`
+		} `json:"auths"`
+	}
+	if err := json.Unmarshal(config, &parsed); err != nil {
+		return "", "", fmt.Errorf("invalid Docker config: %v", err)
+	}
+	for server, auth := range parsed.Auths {
+		// the servers may be URLs, such as https://index.docker.io/v1/
+		host := strings.TrimPrefix(strings.TrimPrefix(server, "https://"), "http://")
+		if i := strings.Index(host, "/"); i >= 0 {
+			host = host[:i]
+		}
+		if host != registry {
+			continue
+		}
+		if auth.Auth == "" {
+			return auth.Username, auth.Password, nil
+		}
+		decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+		if err != nil {
+			return "", "", fmt.Errorf("invalid auth of %s: %v", server, err)
+		}
+		parts := strings.SplitN(string(decoded), ":", 2)
+		if len(parts) != 2 {
+			return "", "", fmt.Errorf("invalid auth of %s", server)
+		}
+		return parts[0], parts[1], nil
+	}
+	return "", "", nil
+}
+
+// IndexMediaType returns the media type of an index referencing manifests
+func IndexMediaType(manifests []Descriptor) string {
+	for _, m := range manifests {
+		if m.MediaType != MediaTypeDockerManifest {
+			return MediaTypeOCIIndex
+		}
+	}
+	return MediaTypeDockerManifestList
+}
+
+func (c *Client) manifestURL(ref Reference) string {
+	scheme := "https"
+	if c.PlainHTTP {
+		scheme = "http"
+	}
+	return fmt.Sprintf("%s://%s/v2/%s/manifests/%s", scheme, ref.Registry, ref.Repository, ref.Reference)
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+// do sends the request built by newReq, authenticating with basic auth and
+// following a bearer token challenge when the registry answers with one
+func (c *Client) do(ctx context.Context, ref Reference, newReq func() (*http.Request, error)) (*http.Response, error) {
+	var user, pass string
+	if c.Credentials != nil {
+		var err error
+		if user, pass, err = c.Credentials(); err != nil {
+			return nil, err
+		}
+	}
+
+	req, err := newReq()
+	if err != nil {
+		return nil, err
+	}
+	if user != "" || pass != "" {
+		req.SetBasicAuth(user, pass)
+	}
+	resp, err := c.httpClient().Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusUnauthorized {
+		return resp, nil
+	}
+
+	challenge := resp.Header.Get("WWW-Authenticate")
+	resp.Body.Close()
+	if !strings.HasPrefix(strings.ToLower(challenge), "bearer ") {
+		return nil, fmt.Errorf("%s: unauthorized", ref)
+	}
+	token, err := c.fetchToken(ctx, parseChallenge(challenge[len("bearer "):]), user, pass)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", ref, err)
+	}
+
+	req, err = newReq()
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return c.httpClient().Do(req.WithContext(ctx))
+}
+
+// fetchToken requests a bearer token from the realm of an auth challenge
+func (c *Client) fetchToken(ctx context.Context, params map[string]string, user, pass string) (string, error) {
+	realm, ok := params["realm"]
+	if !ok {
+		return "", fmt.Errorf("auth challenge without realm")
+	}
+	u, err := url.Parse(realm)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	for _, k := range []string{"service", "scope"} {
+		if v, ok := params[k]; ok {
+			q.Set(k, v)
+		}
+	}
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	if user != "" || pass != "" {
+		req.SetBasicAuth(user, pass)
+	}
+	resp, err := c.httpClient().Do(req.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("fetching token: unexpected status %s", resp.Status)
+	}
+
+	var tok struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tok); err != nil {
+		return "", err
+	}
+	if tok.Token != "" {
+		return tok.Token, nil
+	}
+	if tok.AccessToken != "" {
+		return tok.AccessToken, nil
+	}
+	return "", fmt.Errorf("fetching token: empty token")
+}
+
+// parseChallenge parses the comma separated key="value" parameters of a
+// WWW-Authenticate challenge
+func parseChallenge(s string) map[string]string {
+	params := map[string]string{}
+	for s != "" {
+		s = strings.TrimLeft(s, ", ")
+		eq := strings.Index(s, "=")
+		if eq < 0 {
+			break
+		}
+		key := strings.ToLower(strings.TrimSpace(s[:eq]))
+		s = s[eq+1:]
+
+		var value string
+		if strings.HasPrefix(s, `"`) {
+			end := strings.Index(s[1:], `"`)
+			if end < 0 {
+				value, s = s[1:], ""
+			} else {
+				value, s = s[1:end+1], s[end+2:]
+			}
+		} else {
+			end := strings.Index(s, ",")
+			if end < 0 {
+				end = len(s)
+			}
+			value, s = s[:end], s[end:]
+		}
+		params[key] = value
+	}
+	return params
+}
@@ -0,0 +1,303 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package registry
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type manifest struct {
+	mediaType string
+	body      []byte
+}
+
+// fakeRegistry is a local registry stand-in storing manifests in memory.
+// When token is set it requires a bearer token obtained from /token.
+type fakeRegistry struct {
+	mu        sync.Mutex
+	manifests map[string]manifest
+	token     string
+}
+
+func newFakeRegistry(token string) (*fakeRegistry, *httptest.Server) {
+	reg := &fakeRegistry{manifests: map[string]manifest{}, token: token}
+	srv := httptest.NewServer(reg)
+	return reg, srv
+}
+
+func (f *fakeRegistry) put(repo, ref, mediaType string, body []byte) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	sum := sha256.Sum256(body)
+	digest := "sha256:" + hex.EncodeToString(sum[:])
+	m := manifest{mediaType: mediaType, body: body}
+	f.manifests[repo+"@"+digest] = m
+	if ref != "" {
+		f.manifests[repo+":"+ref] = m
+	}
+	return digest
+}
+
+func (f *fakeRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/token" {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "serviceaccount" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{"token": f.token})
+		return
+	}
+	if f.token != "" && r.Header.Get("Authorization") != "Bearer "+f.token {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="http://`+r.Host+`/token",service="registry"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/v2/")
+	i := strings.LastIndex(path, "/manifests/")
+	if i < 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	repo, ref := path[:i], path[i+len("/manifests/"):]
+	sep := ":"
+	if strings.HasPrefix(ref, "sha256:") {
+		sep = "@"
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		f.mu.Lock()
+		m, ok := f.manifests[repo+sep+ref]
+		f.mu.Unlock()
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", m.mediaType)
+		_, _ = w.Write(m.body)
+	case http.MethodHead:
+		f.mu.Lock()
+		m, ok := f.manifests[repo+sep+ref]
+		f.mu.Unlock()
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		sum := sha256.Sum256(m.body)
+		w.Header().Set("Content-Type", m.mediaType)
+		w.Header().Set("Content-Length", strconv.Itoa(len(m.body)))
+		w.Header().Set("Docker-Content-Digest", "sha256:"+hex.EncodeToString(sum[:]))
+	case http.MethodPut:
+		body, _ := ioutil.ReadAll(r.Body)
+		tag := ""
+		if sep == ":" {
+			tag = ref
+		}
+		w.Header().Set("Docker-Content-Digest", f.put(repo, tag, r.Header.Get("Content-Type"), body))
+		w.WriteHeader(http.StatusCreated)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func TestParseReference(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Reference
+	}{
+		{"registry:5000/ns/stream:v1", Reference{"registry:5000", "ns/stream", "v1"}},
+		{"registry/ns/stream@sha256:abc", Reference{"registry", "ns/stream", "sha256:abc"}},
+		{"registry:5000/ns/stream", Reference{"registry:5000", "ns/stream", "latest"}},
+	}
+	for _, tt := range tests {
+		got, err := ParseReference(tt.in)
+		if err != nil {
+			t.Fatalf("ParseReference(%q): %v", tt.in, err)
+		}
+		if got != tt.want {
+			t.Errorf("ParseReference(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+		if got.String() != tt.in && tt.want.Reference != "latest" {
+			t.Errorf("String() = %q, want %q", got.String(), tt.in)
+		}
+	}
+
+	if _, err := ParseReference("stream:v1"); err == nil {
+		t.Error("expected an error for a reference without registry")
+	}
+}
+
+func TestPushIndex(t *testing.T) {
+	for _, token := range []string{"", "registry-token"} {
+		reg, srv := newFakeRegistry(token)
+		defer srv.Close()
+		host := strings.TrimPrefix(srv.URL, "http://")
+
+		c := &Client{
+			PlainHTTP:   true,
+			Credentials: func() (string, string, error) { return "serviceaccount", "secret", nil },
+		}
+		ctx := context.Background()
+
+		var manifests []Descriptor
+		for _, arch := range []string{"amd64", "arm64"} {
+			digest := reg.put("ns/stack", "v1-"+arch, MediaTypeDockerManifest, []byte(`{"arch":"`+arch+`"}`))
+			desc, err := c.Resolve(ctx, Reference{host, "ns/stack", "v1-" + arch})
+			if err != nil {
+				t.Fatalf("Resolve: %v", err)
+			}
+			if desc.Digest != digest || desc.MediaType != MediaTypeDockerManifest {
+				t.Fatalf("Resolve = %+v, want digest %s", desc, digest)
+			}
+			desc.Platform = &Platform{Architecture: arch, OS: "linux"}
+			manifests = append(manifests, desc)
+		}
+
+		index, err := c.PushIndex(ctx, Reference{host, "ns/stack", "v1"}, manifests)
+		if err != nil {
+			t.Fatalf("PushIndex: %v", err)
+		}
+		if index.MediaType != MediaTypeDockerManifestList {
+			t.Errorf("index media type = %s, want %s", index.MediaType, MediaTypeDockerManifestList)
+		}
+
+		pushed, err := c.Resolve(ctx, Reference{host, "ns/stack", "v1"})
+		if err != nil {
+			t.Fatalf("Resolve index: %v", err)
+		}
+		if pushed.Digest != index.Digest {
+			t.Errorf("pushed digest = %s, want %s", pushed.Digest, index.Digest)
+		}
+
+		var stored Index
+		if err := json.Unmarshal(reg.manifests["ns/stack:v1"].body, &stored); err != nil {
+			t.Fatalf("stored index: %v", err)
+		}
+		if len(stored.Manifests) != 2 || stored.Manifests[1].Platform.Architecture != "arm64" {
+			t.Errorf("stored index = %+v", stored)
+		}
+	}
+}
+
+func TestIndexMediaType(t *testing.T) {
+	docker := Descriptor{MediaType: MediaTypeDockerManifest}
+	oci := Descriptor{MediaType: MediaTypeOCIManifest}
+	if got := IndexMediaType([]Descriptor{docker, docker}); got != MediaTypeDockerManifestList {
+		t.Errorf("IndexMediaType(docker) = %s", got)
+	}
+	if got := IndexMediaType([]Descriptor{docker, oci}); got != MediaTypeOCIIndex {
+		t.Errorf("IndexMediaType(mixed) = %s", got)
+	}
+}
+
+func TestTag(t *testing.T) {
+	reg, srv := newFakeRegistry("registry-token")
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, "http://")
+
+	c := &Client{
+		PlainHTTP:   true,
+		Credentials: func() (string, string, error) { return "serviceaccount", "secret", nil },
+	}
+	digest := reg.put("ns/stack", "v1-mpi", MediaTypeOCIManifest, []byte(`{"layers":[]}`))
+
+	desc, err := c.Tag(context.Background(), Reference{host, "ns/stack", digest}, "latest-mpi")
+	if err != nil {
+		t.Fatalf("Tag: %v", err)
+	}
+	if desc.Digest != digest || desc.MediaType != MediaTypeOCIManifest {
+		t.Errorf("Tag = %+v, want digest %s", desc, digest)
+	}
+	if m, ok := reg.manifests["ns/stack:latest-mpi"]; !ok || m.mediaType != MediaTypeOCIManifest {
+		t.Errorf("tag latest-mpi not pushed: %+v", m)
+	}
+
+	if _, err := c.Tag(context.Background(), Reference{host, "ns/stack", "missing"}, "latest"); err == nil {
+		t.Error("expected an error tagging a missing manifest")
+	}
+}
+
+func TestDockerConfigCredentials(t *testing.T) {
+	auth := base64.StdEncoding.EncodeToString([]byte("robot:p4ss:word"))
+	config := []byte(`{"auths":{
+		"https://index.docker.io/v1/":{"auth":"` + auth + `"},
+		"registry.local:5000":{"username":"builder","password":"secret"}}}`)
+
+	tests := []struct {
+		registry, user, pass string
+	}{
+		{"index.docker.io", "robot", "p4ss:word"},
+		{"registry.local:5000", "builder", "secret"},
+		{"quay.io", "", ""},
+	}
+	for _, tt := range tests {
+		user, pass, err := DockerConfigCredentials(config, tt.registry)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.registry, err)
+			continue
+		}
+		if user != tt.user || pass != tt.pass {
+			t.Errorf("%s: got %s/%s, want %s/%s", tt.registry, user, pass, tt.user, tt.pass)
+		}
+	}
+
+	if _, _, err := DockerConfigCredentials([]byte("{"), "quay.io"); err == nil {
+		t.Error("expected an error parsing an invalid config")
+	}
+}
+
+func TestNewClient(t *testing.T) {
+	dir := t.TempDir()
+	token := filepath.Join(dir, "token")
+	if err := ioutil.WriteFile(token, []byte("s3cr3t\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// the service CA is only mounted on OpenShift
+	c, err := NewClient(filepath.Join(dir, "service-ca.crt"), token)
+	if err != nil {
+		t.Fatalf("NewClient with a missing CA file: %v", err)
+	}
+	if c.HTTPClient != nil {
+		t.Error("expected the system CAs to be trusted")
+	}
+	user, pass, err := c.Credentials()
+	if err != nil || user != "serviceaccount" || pass != "s3cr3t" {
+		t.Errorf("Credentials = %s/%s, %v", user, pass, err)
+	}
+
+	invalid := filepath.Join(dir, "invalid.crt")
+	if err := ioutil.WriteFile(invalid, []byte("not a certificate"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewClient(invalid, ""); err == nil {
+		t.Error("expected an error with a CA file holding no certificate")
+	}
+}